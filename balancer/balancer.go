@@ -0,0 +1,261 @@
+// Package balancer spreads reads for a shard across its leader and
+// replicas. Backends are probed on /healthz and ejected while unhealthy.
+package balancer
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sagor0078/distribKV/config"
+)
+
+const (
+	// DefaultHealthInterval is how often backends are probed.
+	DefaultHealthInterval = 2 * time.Second
+	// DefaultFailureThreshold is how many probes in a row must fail before
+	// a backend is ejected.
+	DefaultFailureThreshold = 2
+
+	// latencyWeight is the weight of a new sample in the latency average.
+	latencyWeight = 0.3
+	// failurePenalty is recorded as the latency of a failed request.
+	failurePenalty = time.Second
+)
+
+// Backend is a node that can serve reads for a shard.
+type Backend struct {
+	Addr string
+
+	outstanding atomic.Int64
+	latency     atomic.Int64 // moving average in nanoseconds
+	unhealthy   atomic.Bool
+	failures    int // consecutive failed probes, owned by the prober
+}
+
+// Outstanding returns the number of requests in flight to the backend.
+func (b *Backend) Outstanding() int64 {
+	return b.outstanding.Load()
+}
+
+// Latency returns the moving average latency of requests to the backend,
+// or zero before the first request completes.
+func (b *Backend) Latency() time.Duration {
+	return time.Duration(b.latency.Load())
+}
+
+// Healthy reports whether the backend passed its recent health probes.
+func (b *Backend) Healthy() bool {
+	return !b.unhealthy.Load()
+}
+
+// Start records a request to the backend. The returned function must be
+// called when it completes, with the error it failed with, if any.
+func (b *Backend) Start() func(error) {
+	b.outstanding.Add(1)
+	start := time.Now()
+	return func(err error) {
+		b.outstanding.Add(-1)
+		took := time.Since(start)
+		if err != nil {
+			took = max(took, failurePenalty)
+		}
+		b.observe(took)
+	}
+}
+
+func (b *Backend) observe(took time.Duration) {
+	for {
+		old := b.latency.Load()
+		next := int64(took)
+		if old != 0 {
+			next = int64(latencyWeight*float64(took) + (1-latencyWeight)*float64(old))
+		}
+		if b.latency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// Option configures a Balancer.
+type Option func(*Balancer)
+
+// WithHealthInterval sets how often backends are probed.
+func WithHealthInterval(d time.Duration) Option {
+	return func(b *Balancer) {
+		b.interval = d
+	}
+}
+
+// WithFailureThreshold sets how many probes in a row must fail before a
+// backend is ejected.
+func WithFailureThreshold(n int) Option {
+	return func(b *Balancer) {
+		b.threshold = n
+	}
+}
+
+// WithHTTPClient sets the client used for health probes.
+func WithHTTPClient(c *http.Client) Option {
+	return func(b *Balancer) {
+		b.client = c
+	}
+}
+
+// Balancer picks a backend among the leader and replicas of each shard.
+type Balancer struct {
+	strategy  Strategy
+	interval  time.Duration
+	threshold int
+	client    *http.Client
+
+	mu sync.RWMutex
+	// shards holds the leader followed by the replicas of each shard.
+	shards map[int][]*Backend
+	// backends indexes every backend by address, so their statistics
+	// survive topology updates.
+	backends map[string]*Backend
+}
+
+// New creates a balancer for the shards using strategy. Health probing
+// only runs while Run is running.
+func New(strategy Strategy, shards *config.Shards, opts ...Option) *Balancer {
+	b := &Balancer{
+		strategy:  strategy,
+		interval:  DefaultHealthInterval,
+		threshold: DefaultFailureThreshold,
+		client:    http.DefaultClient,
+		backends:  make(map[string]*Backend),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.Update(shards)
+	return b
+}
+
+// Update replaces the shard layout, for example after a topology reload.
+func (b *Balancer) Update(shards *config.Shards) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	groups := make(map[int][]*Backend, shards.Count)
+	backends := make(map[string]*Backend)
+	for idx, leader := range shards.Addrs {
+		for _, addr := range append([]string{leader}, shards.GetReplicas(idx)...) {
+			be, ok := b.backends[addr]
+			if !ok {
+				be = &Backend{Addr: addr}
+			}
+			backends[addr] = be
+			groups[idx] = append(groups[idx], be)
+		}
+	}
+	b.shards, b.backends = groups, backends
+}
+
+// Pick returns the backend to send a read for shard to. When every backend
+// of the shard is unhealthy, the leader is returned so the request still
+// gets a definite answer. It returns nil for an unknown shard.
+func (b *Balancer) Pick(shard int) *Backend {
+	b.mu.RLock()
+	group := b.shards[shard]
+	b.mu.RUnlock()
+	if len(group) == 0 {
+		return nil
+	}
+
+	healthy := make([]*Backend, 0, len(group))
+	for _, be := range group {
+		if be.Healthy() {
+			healthy = append(healthy, be)
+		}
+	}
+	if len(healthy) == 0 {
+		return group[0]
+	}
+	return b.strategy.Pick(healthy)
+}
+
+// Backends returns the backends of shard, leader first.
+func (b *Balancer) Backends(shard int) []*Backend {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]*Backend(nil), b.shards[shard]...)
+}
+
+// Run probes every backend's /healthz until ctx is cancelled. A backend is
+// ejected after the configured number of failed probes in a row and comes
+// back after one successful probe.
+func (b *Balancer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		b.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Balancer) probeAll(ctx context.Context) {
+	b.mu.RLock()
+	backends := make([]*Backend, 0, len(b.backends))
+	for _, be := range b.backends {
+		backends = append(backends, be)
+	}
+	b.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, be := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.probe(ctx, be)
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *Balancer) probe(ctx context.Context, be *Backend) {
+	ok := b.healthz(ctx, be.Addr)
+	if ctx.Err() != nil {
+		return // shutting down
+	}
+
+	if ok {
+		be.failures = 0
+		if be.unhealthy.Swap(false) {
+			log.Printf("Backend %s is healthy again", be.Addr)
+		}
+		return
+	}
+	be.failures++
+	if be.failures >= b.threshold && !be.unhealthy.Swap(true) {
+		log.Printf("Ejecting unhealthy backend %s: %d failed health checks", be.Addr, be.failures)
+	}
+}
+
+// healthz reports whether the node at addr answers /healthz with 200 OK
+// within the probe interval.
+func (b *Balancer) healthz(ctx context.Context, addr string) bool {
+	ctx, cancel := context.WithTimeout(ctx, b.interval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/healthz", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,115 @@
+package balancer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/balancer"
+	"github.com/Sagor0078/distribKV/config"
+)
+
+// newNode starts a node whose /healthz answers 200 while healthy is set.
+func newNode(t *testing.T, healthy *atomic.Bool) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func waitHealthy(t *testing.T, be *balancer.Backend, want bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for be.Healthy() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s healthy = %v, want %v", be.Addr, be.Healthy(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBalancerEjectsUnhealthyBackends(t *testing.T) {
+	var leaderUp, replicaUp atomic.Bool
+	leaderUp.Store(true)
+	replicaUp.Store(true)
+	leader, replica := newNode(t, &leaderUp), newNode(t, &replicaUp)
+
+	b := balancer.New(balancer.NewRoundRobin(), &config.Shards{
+		Count:    1,
+		Addrs:    map[int]string{0: leader},
+		Replicas: map[int][]string{0: {replica}},
+	}, balancer.WithHealthInterval(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	backends := b.Backends(0)
+	if len(backends) != 2 || backends[0].Addr != leader || backends[1].Addr != replica {
+		t.Fatalf("unexpected backends %v", backends)
+	}
+
+	seen := map[string]bool{}
+	for range 4 {
+		seen[b.Pick(0).Addr] = true
+	}
+	if !seen[leader] || !seen[replica] {
+		t.Errorf("reads were not spread over the shard: %v", seen)
+	}
+
+	replicaUp.Store(false)
+	waitHealthy(t, backends[1], false)
+	for range 4 {
+		if got := b.Pick(0).Addr; got != leader {
+			t.Fatalf("picked ejected backend %s", got)
+		}
+	}
+
+	// With every backend down, reads still go to the leader.
+	leaderUp.Store(false)
+	waitHealthy(t, backends[0], false)
+	if got := b.Pick(0).Addr; got != leader {
+		t.Errorf("picked %s with all backends down, want the leader", got)
+	}
+
+	replicaUp.Store(true)
+	waitHealthy(t, backends[1], true)
+	if got := b.Pick(0).Addr; got != replica {
+		t.Errorf("picked %s, want the recovered replica", got)
+	}
+
+	if b.Pick(1) != nil {
+		t.Errorf("picked a backend for an unknown shard")
+	}
+}
+
+func TestBalancerUpdateKeepsBackends(t *testing.T) {
+	b := balancer.New(balancer.NewLeastOutstanding(), &config.Shards{
+		Count: 1,
+		Addrs: map[int]string{0: "a"},
+	})
+	be := b.Pick(0)
+	be.Start()(errors.New("timeout"))
+
+	b.Update(&config.Shards{
+		Count:    2,
+		Addrs:    map[int]string{0: "b", 1: "a"},
+		Replicas: map[int][]string{1: {"c"}},
+	})
+	if got := b.Backends(1); len(got) != 2 || got[0] != be || got[1].Addr != "c" {
+		t.Errorf("backends of shard 1 after update: %v", got)
+	}
+	if be.Latency() == 0 {
+		t.Errorf("latency was lost across the update")
+	}
+}
@@ -0,0 +1,100 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Strategy picks the backend to send a read to. Pick is only called with
+// a non-empty list of healthy backends and must be safe for concurrent use.
+type Strategy interface {
+	Pick(backends []*Backend) *Backend
+}
+
+// Strategy names accepted by ParseStrategy.
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyLeastOutstanding = "least-outstanding"
+	StrategyLatency          = "latency"
+)
+
+// ParseStrategy returns the strategy with the given name.
+func ParseStrategy(name string) (Strategy, error) {
+	switch name {
+	case StrategyRoundRobin:
+		return NewRoundRobin(), nil
+	case StrategyLeastOutstanding:
+		return NewLeastOutstanding(), nil
+	case StrategyLatency:
+		return NewLatencyAware(), nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", name)
+	}
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// NewRoundRobin returns a strategy that cycles through the backends.
+func NewRoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (s *roundRobin) Pick(backends []*Backend) *Backend {
+	n := s.next.Add(1) - 1
+	return backends[n%uint64(len(backends))]
+}
+
+type leastOutstanding struct{}
+
+// NewLeastOutstanding returns a strategy that picks the backend with the
+// fewest requests in flight, breaking ties at random.
+func NewLeastOutstanding() Strategy {
+	return leastOutstanding{}
+}
+
+func (leastOutstanding) Pick(backends []*Backend) *Backend {
+	offset := rand.IntN(len(backends))
+	best := backends[offset]
+	for i := 1; i < len(backends); i++ {
+		b := backends[(offset+i)%len(backends)]
+		if b.Outstanding() < best.Outstanding() {
+			best = b
+		}
+	}
+	return best
+}
+
+type latencyAware struct{}
+
+// NewLatencyAware returns a strategy that prefers backends with low recent
+// latency, weighted by the requests they have in flight. It compares two
+// backends chosen at random, so a slow backend still gets some traffic and
+// its latency estimate can recover.
+func NewLatencyAware() Strategy {
+	return latencyAware{}
+}
+
+func (latencyAware) Pick(backends []*Backend) *Backend {
+	if len(backends) == 1 {
+		return backends[0]
+	}
+	i := rand.IntN(len(backends))
+	j := rand.IntN(len(backends) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := backends[i], backends[j]
+	if cost(b) < cost(a) {
+		return b
+	}
+	return a
+}
+
+// cost estimates how long a new request to b would take. Backends without
+// a latency sample yet cost nothing, so they are tried.
+func cost(b *Backend) float64 {
+	return float64(b.Latency()) * float64(b.Outstanding()+1)
+}
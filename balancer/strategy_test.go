@@ -0,0 +1,63 @@
+package balancer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/balancer"
+)
+
+func TestParseStrategy(t *testing.T) {
+	for _, name := range []string{balancer.StrategyRoundRobin, balancer.StrategyLeastOutstanding, balancer.StrategyLatency} {
+		if _, err := balancer.ParseStrategy(name); err != nil {
+			t.Errorf("ParseStrategy(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := balancer.ParseStrategy("random"); err == nil {
+		t.Errorf("ParseStrategy(random) succeeded")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	backends := []*balancer.Backend{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}}
+	s := balancer.NewRoundRobin()
+
+	counts := map[string]int{}
+	for range 30 {
+		counts[s.Pick(backends).Addr]++
+	}
+	for _, be := range backends {
+		if counts[be.Addr] != 10 {
+			t.Errorf("%s picked %d times, want 10", be.Addr, counts[be.Addr])
+		}
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	busy, idle := &balancer.Backend{Addr: "busy"}, &balancer.Backend{Addr: "idle"}
+	busy.Start()
+	busy.Start()
+	s := balancer.NewLeastOutstanding()
+
+	for range 20 {
+		if got := s.Pick([]*balancer.Backend{busy, idle}); got != idle {
+			t.Fatalf("picked %s, want idle", got.Addr)
+		}
+	}
+}
+
+func TestLatencyAware(t *testing.T) {
+	slow, fast := &balancer.Backend{Addr: "slow"}, &balancer.Backend{Addr: "fast"}
+	slow.Start()(errors.New("connection refused"))
+	fast.Start()(nil)
+	if slow.Latency() <= fast.Latency() {
+		t.Fatalf("failed request did not count as slow: %v <= %v", slow.Latency(), fast.Latency())
+	}
+	s := balancer.NewLatencyAware()
+
+	for range 20 {
+		if got := s.Pick([]*balancer.Backend{slow, fast}); got != fast {
+			t.Fatalf("picked %s, want fast", got.Addr)
+		}
+	}
+}
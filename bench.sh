@@ -1,5 +1,5 @@
 go run cmd/bench/main.go \
-  -shard-addrs="localhost:8080,localhost:8081,localhost:8082" \
+  -config-file=sharding.toml \
   -iterations=5000 \
   -read-iterations=10000 \
   -concurrency=10
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"time"
+
+	"github.com/Sagor0078/distribKV/config"
+)
+
+// Topology describes the routing topology of a node, as served by
+// GET /v1/admin/topology.
+type Topology struct {
+	Epoch    uint64           `json:"epoch"`
+	Shard    int              `json:"shard"`
+	Addrs    map[int]string   `json:"addrs"`
+	Replicas map[int][]string `json:"replicas,omitempty"`
+	// Shards is the config the topology was loaded from. Clients rebuild
+	// the partitioner from it.
+	Shards []config.Shard `json:"shards,omitempty"`
+}
+
+// Replication roles reported in ReplicationStatus.
+const (
+	RoleLeader       = "leader"
+	RoleReplica      = "replica"
+	RoleRaftLeader   = "raft-leader"
+	RoleRaftFollower = "raft-follower"
+)
+
+// ReplicationStatus is a node's view of replication within its shard, as
+// served by GET /v1/admin/replication.
+type ReplicationStatus struct {
+	Shard int    `json:"shard"`
+	Epoch uint64 `json:"epoch"`
+	Role  string `json:"role"`
+	// Position is the position of the shard's log applied on this node.
+	Position uint64 `json:"position"`
+	// Leader is the node this one follows, if any.
+	Leader string `json:"leader,omitempty"`
+
+	// Synced, Lag, Staleness and Pulls are reported by replicas. Lag and
+	// Staleness are meaningless until the replica has synced once.
+	Synced    bool          `json:"synced,omitempty"`
+	Lag       uint64        `json:"lag,omitempty"`
+	Staleness time.Duration `json:"staleness,omitempty"`
+	Pulls     *PullStats    `json:"pulls,omitempty"`
+
+	// Acks maps each replica to the position it has acknowledged, and
+	// Pending to the number of entries it has yet to. Replicas in the
+	// topology that never acknowledged anything have every entry pending.
+	// Backlog is the number of entries the leader's log holds
+	// until every replica has them. They are reported by leaders.
+	Acks    map[string]uint64 `json:"acks,omitempty"`
+	Pending map[string]uint64 `json:"pending,omitempty"`
+	Backlog uint64            `json:"backlog,omitempty"`
+}
+
+// PullStats describes a replica's round trips to the leader.
+type PullStats struct {
+	// LastPull is when a batch was last fetched and applied.
+	LastPull time.Time `json:"last_pull,omitzero"`
+	// Errors counts the failed round trips, and ConsecutiveErrors those
+	// since the last successful one.
+	Errors            uint64 `json:"errors"`
+	ConsecutiveErrors uint64 `json:"consecutive_errors"`
+	// LastError is why the last failed round trip failed, and LastErrorAt
+	// when.
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+}
+
+// Trailers of GET /v1/admin/backup, sent once the backup is complete. A
+// response without them was cut short.
+const (
+	// BackupSeqTrailer is the log position the backup reflects, or 0 for
+	// an incremental backup if no write was logged since the previous one.
+	BackupSeqTrailer = "X-Backup-Seq"
+	// BackupNextTrailer is the since to ask for the next incremental backup.
+	BackupNextTrailer = "X-Backup-Next"
+)
+
+// BackupDBHeader is the header of GET /v1/admin/backup holding the ID of
+// the database the backup is taken from. A wiped or restored node has a new
+// one, and incremental backups only follow backups of the same database.
+const BackupDBHeader = "X-Backup-DB"
@@ -0,0 +1,502 @@
+// Package client is a Go client for distribKV. It sends each request
+// straight to the shard that owns the key, using the same partitioner as
+// the servers, retries transient failures with backoff and follows
+// topology changes.
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sagor0078/distribKV/balancer"
+	"github.com/Sagor0078/distribKV/config"
+)
+
+const (
+	// DefaultRetries is how many times a failed request is retried.
+	DefaultRetries = 3
+	// DefaultBackoff is the delay before the first retry. It doubles with
+	// every further attempt.
+	DefaultBackoff = 50 * time.Millisecond
+
+	// maxBackoff caps the delay between retries.
+	maxBackoff = 2 * time.Second
+
+	// epochHeader carries the topology epoch, as web.EpochHeader.
+	epochHeader = "X-Topology-Epoch"
+	// writeTokenHeader carries the write token, as web.WriteTokenHeader.
+	writeTokenHeader = "X-Write-Token"
+)
+
+// ErrNotFound is returned when a key does not exist.
+var ErrNotFound = errors.New("key not found")
+
+// StatusError is returned when a server answers with an unexpected status.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.Code, e.Message)
+}
+
+// KV is a key-value pair returned by Scan and List.
+type KV struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithRetries sets how many times a failed request is retried.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// WithBackoff sets the delay before the first retry.
+func WithBackoff(d time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = d
+	}
+}
+
+// WithBalancer spreads reads over each shard's leader and replicas using
+// strategy, skipping backends that fail their health checks. Without it
+// every request goes to the shard leader.
+func WithBalancer(strategy balancer.Strategy) Option {
+	return func(c *Client) {
+		c.strategy = strategy
+	}
+}
+
+// Client is a distribKV client. It is safe for concurrent use.
+type Client struct {
+	http     *http.Client
+	retries  int
+	backoff  time.Duration
+	strategy balancer.Strategy
+	balancer *balancer.Balancer
+	stop     context.CancelFunc
+
+	// topologyFile, if set, is re-read when the cluster reports a newer
+	// topology.
+	topologyFile string
+
+	mu     sync.RWMutex
+	shards *config.Shards
+	// refreshMu lets a single goroutine refresh the topology at a time.
+	refreshMu sync.Mutex
+}
+
+// New creates a client that routes by shards.
+func New(shards *config.Shards, opts ...Option) *Client {
+	c := &Client{
+		http:    &http.Client{Timeout: 10 * time.Second},
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+		shards:  shards,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.strategy != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.balancer = balancer.New(c.strategy, shards, balancer.WithHTTPClient(c.http))
+		c.stop = cancel
+		go c.balancer.Run(ctx)
+	}
+	return c
+}
+
+// Open creates a client from a sharding config file, the same file the
+// servers are started with. The file is read again when the cluster moves
+// to a newer topology.
+func Open(file string, opts ...Option) (*Client, error) {
+	shards, err := config.LoadShards(file, "")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config %q: %w", file, err)
+	}
+	c := New(shards, opts...)
+	c.topologyFile = file
+	return c, nil
+}
+
+// Dial creates a client from the topology served by the node at addr.
+func Dial(ctx context.Context, addr string, opts ...Option) (*Client, error) {
+	c := New(&config.Shards{}, opts...)
+	shards, err := c.fetchTopology(ctx, addr)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.setTopology(shards)
+	return c, nil
+}
+
+// Close stops the client's background health checks.
+func (c *Client) Close() {
+	if c.stop != nil {
+		c.stop()
+	}
+}
+
+// ReadOption configures a read.
+type ReadOption func(url.Values)
+
+// WithConsistency sets the read consistency level: "eventual", "bounded",
+// "session" or "strong".
+func WithConsistency(level string) ReadOption {
+	return func(q url.Values) {
+		q.Set("consistency", level)
+	}
+}
+
+// WithMaxStaleness bounds how far behind the leader a replica serving the
+// read may be.
+func WithMaxStaleness(d time.Duration) ReadOption {
+	return func(q url.Values) {
+		q.Set("max-staleness", d.String())
+	}
+}
+
+// WithToken makes the read observe the write that returned token.
+func WithToken(token string) ReadOption {
+	return func(q url.Values) {
+		q.Set("consistency", "session")
+		q.Set("token", token)
+	}
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string, opts ...ReadOption) ([]byte, error) {
+	q := url.Values{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	resp, err := c.do(ctx, key, http.MethodGet, keyPath(key), q, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.body, nil
+}
+
+// Set stores value under key. It returns the write token, which can be
+// passed to WithToken to read the write back from a replica.
+func (c *Client) Set(ctx context.Context, key string, value []byte) (string, error) {
+	resp, err := c.do(ctx, key, http.MethodPut, keyPath(key), nil, value)
+	if err != nil {
+		return "", err
+	}
+	return resp.header.Get(writeTokenHeader), nil
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, key, http.MethodDelete, keyPath(key), nil, nil)
+	return err
+}
+
+// Scan returns up to limit pairs with start <= key < end across all shards,
+// in key order. Pass the returned next key as start to get the next page;
+// it is empty after the last page. An empty end scans to the last key.
+func (c *Client) Scan(ctx context.Context, start, end string, limit int) ([]KV, string, error) {
+	q := url.Values{"start": {start}, "limit": {strconv.Itoa(limit)}}
+	if end != "" {
+		q.Set("end", end)
+	}
+	return c.rangeQuery(ctx, "/v1/scan", q)
+}
+
+// List returns up to limit pairs whose key has prefix, in key order,
+// starting at cursor. The returned next cursor is empty after the last page.
+func (c *Client) List(ctx context.Context, prefix, cursor string, limit int) ([]KV, string, error) {
+	q := url.Values{"prefix": {prefix}, "cursor": {cursor}, "limit": {strconv.Itoa(limit)}}
+	return c.rangeQuery(ctx, "/v1/list", q)
+}
+
+func (c *Client) rangeQuery(ctx context.Context, path string, q url.Values) ([]KV, string, error) {
+	// Any node fans a range query out to every shard.
+	resp, err := c.do(ctx, "", http.MethodGet, path, q, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var page struct {
+		Items []KV   `json:"items"`
+		Next  string `json:"next"`
+	}
+	if err := json.Unmarshal(resp.body, &page); err != nil {
+		return nil, "", fmt.Errorf("error decoding %s response: %w", path, err)
+	}
+	return page.Items, page.Next, nil
+}
+
+// Op is an operation in a batch. Get reads Key and Delete removes it;
+// otherwise Value is stored under Key.
+type Op struct {
+	Key    string
+	Value  []byte
+	Get    bool
+	Delete bool
+}
+
+// Result is the outcome of one Op of a batch. Found reports whether a get
+// found its key, and Value holds what it read. Err is set if the op failed.
+type Result struct {
+	Key   string
+	Value []byte
+	Found bool
+	Err   error
+}
+
+// Batch runs ops, sending the ops owned by each shard to it in parallel as
+// one /v1/batch request, and returns one result per op in order. Each shard
+// runs its ops in order in a single transaction, gets observing the writes
+// before them, but the batch as a whole is not atomic: on error, the writes
+// of some shards may have been applied. The error joins the errors of the
+// failed ops.
+func (c *Client) Batch(ctx context.Context, ops []Op) ([]Result, error) {
+	shards := c.Topology()
+	byShard := make(map[int][]int)
+	for i, op := range ops {
+		idx := shards.Index(op.Key)
+		byShard[idx] = append(byShard[idx], i)
+	}
+
+	// Each shard fills in the results of its own ops.
+	results := make([]Result, len(ops))
+	errs := make(chan error, len(byShard))
+	for _, positions := range byShard {
+		go func() {
+			errs <- c.sendBatch(ctx, ops, positions, results)
+		}()
+	}
+
+	var err error
+	for range byShard {
+		err = errors.Join(err, <-errs)
+	}
+	return results, err
+}
+
+// batchOp and batchResult mirror db.BatchOp and web.BatchResult.
+type batchOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type batchResult struct {
+	Key    string `json:"key"`
+	Status int    `json:"status"`
+	Value  []byte `json:"value"`
+	Error  string `json:"error"`
+}
+
+// sendBatch sends the ops at positions, all owned by the same shard, to
+// that shard and stores their outcome at the same positions in results.
+func (c *Client) sendBatch(ctx context.Context, ops []Op, positions []int, results []Result) error {
+	req := make([]batchOp, len(positions))
+	for i, pos := range positions {
+		op := ops[pos]
+		switch {
+		case op.Get:
+			req[i] = batchOp{Op: "get", Key: op.Key}
+		case op.Delete:
+			req[i] = batchOp{Op: "delete", Key: op.Key}
+		default:
+			req[i] = batchOp{Op: "put", Key: op.Key, Value: op.Value}
+		}
+		results[pos].Key = op.Key
+	}
+	fail := func(err error) error {
+		for _, pos := range positions {
+			results[pos].Err = err
+		}
+		return err
+	}
+
+	body, err := json.Marshal(struct {
+		Ops []batchOp `json:"ops"`
+	}{req})
+	if err != nil {
+		return fail(err)
+	}
+	resp, err := c.do(ctx, req[0].Key, http.MethodPost, "/v1/batch", nil, body)
+	if err != nil {
+		return fail(err)
+	}
+
+	var res struct {
+		Results []batchResult `json:"results"`
+	}
+	if err := json.Unmarshal(resp.body, &res); err != nil {
+		return fail(fmt.Errorf("error decoding batch response: %w", err))
+	}
+	if len(res.Results) != len(req) {
+		return fail(fmt.Errorf("batch response has %d results for %d ops", len(res.Results), len(req)))
+	}
+	var errs []error
+	for i, r := range res.Results {
+		result := &results[positions[i]]
+		switch {
+		case r.Status == http.StatusOK:
+			result.Value, result.Found = r.Value, true
+		case r.Status == http.StatusNotFound && req[i].Op == "get":
+		case r.Status >= 300:
+			result.Err = fmt.Errorf("key %q: %w", r.Key, &StatusError{Code: r.Status, Message: r.Error})
+			errs = append(errs, result.Err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func keyPath(key string) string {
+	return "/v1/keys/" + url.PathEscape(key)
+}
+
+// response is a fully read response.
+type response struct {
+	header http.Header
+	body   []byte
+}
+
+// do sends a request for key to the node that owns it, or to any node when
+// key is empty, retrying failures that another attempt may fix.
+func (c *Client) do(ctx context.Context, key, method, path string, q url.Values, body []byte) (*response, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			if err := c.sleep(ctx, attempt); err != nil {
+				return nil, errors.Join(lastErr, err)
+			}
+		}
+
+		resp, retry, err := c.attempt(ctx, key, method, path, q, body)
+		if err == nil || !retry {
+			return resp, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// attempt sends a request once and reports whether it is worth retrying.
+func (c *Client) attempt(ctx context.Context, key, method, path string, q url.Values, body []byte) (*response, bool, error) {
+	shards := c.Topology()
+	read := method == http.MethodGet
+	addr, be := c.pick(shards, key, read && q.Get("consistency") != "strong")
+
+	target := "http://" + addr + path
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bodyReader)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set(epochHeader, strconv.FormatUint(shards.Epoch, 10))
+
+	var done func(error)
+	if be != nil {
+		done = be.Start()
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if done != nil {
+			done(err)
+		}
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if done != nil {
+		var failed error
+		if resp.StatusCode >= http.StatusInternalServerError {
+			failed = fmt.Errorf("%s answered %d", addr, resp.StatusCode)
+		}
+		done(cmp.Or(err, failed))
+	}
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+
+	// The node runs a newer topology: route by it from now on.
+	if theirs, err := strconv.ParseUint(resp.Header.Get(epochHeader), 10, 64); err == nil && theirs > shards.Epoch {
+		if err := c.refresh(ctx, theirs); err != nil {
+			return nil, true, err
+		}
+	}
+
+	switch code := resp.StatusCode; {
+	case code < 300:
+		return &response{header: resp.Header, body: data}, false, nil
+	case code == http.StatusNotFound && strings.HasPrefix(path, "/v1/keys/"):
+		return nil, false, ErrNotFound
+	case code == http.StatusMisdirectedRequest:
+		// Our topology is newer than the node's; it is reloading.
+		return nil, true, &StatusError{Code: code, Message: strings.TrimSpace(string(data))}
+	default:
+		retry := code == http.StatusBadGateway || code == http.StatusServiceUnavailable ||
+			code == http.StatusGatewayTimeout || code == http.StatusTooManyRequests
+		return nil, retry, &StatusError{Code: code, Message: strings.TrimSpace(string(data))}
+	}
+}
+
+// pick returns the address to send a request for key to, and the balanced
+// backend it belongs to, if any.
+func (c *Client) pick(shards *config.Shards, key string, balanced bool) (string, *balancer.Backend) {
+	if key == "" {
+		// Any node will do; spread range queries over the leaders.
+		return shards.Addrs[rand.IntN(shards.Count)], nil
+	}
+	idx := shards.Index(key)
+	if balanced && c.balancer != nil {
+		if be := c.balancer.Pick(idx); be != nil {
+			return be.Addr, be
+		}
+	}
+	return shards.Addrs[idx], nil
+}
+
+// sleep waits before the given retry attempt, with exponential backoff and
+// jitter.
+func (c *Client) sleep(ctx context.Context, attempt int) error {
+	d := min(c.backoff<<(attempt-1), maxBackoff)
+	d = d/2 + rand.N(d/2+1)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
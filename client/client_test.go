@@ -0,0 +1,230 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/client"
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// testCluster is a set of nodes sharing one topology.
+type testCluster struct {
+	shards *config.Shards
+	dbs    []*db.Database
+	// served counts the requests each node handled.
+	served []atomic.Int64
+}
+
+// newCluster starts n shards routing by a hash ring at the given epoch.
+func newCluster(t *testing.T, n int, epoch uint64) *testCluster {
+	t.Helper()
+
+	c := &testCluster{dbs: make([]*db.Database, n), served: make([]atomic.Int64, n)}
+	handlers := make([]http.Handler, n)
+	layout := make([]config.Shard, n)
+	for i := range n {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.served[i].Add(1)
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(ts.Close)
+		layout[i] = config.Shard{Name: fmt.Sprintf("shard-%d", i), Idx: i, Address: strings.TrimPrefix(ts.URL, "http://")}
+	}
+
+	var err error
+	if c.shards, err = config.ParseTopology(layout); err != nil {
+		t.Fatalf("invalid topology: %v", err)
+	}
+	c.shards.Epoch = epoch
+
+	for i := range n {
+		database, closer, err := db.NewDatabase(t.TempDir(), false)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := closer(); err != nil {
+				t.Errorf("Cleanup error: %v", err)
+			}
+		})
+		c.dbs[i] = database
+
+		shards := *c.shards
+		shards.CurIdx, shards.CurName = i, layout[i].Name
+		srv := web.NewServer(database, &shards)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v1/keys/{key...}", srv.KeyHandler)
+		mux.HandleFunc("/v1/scan", srv.ScanHandler)
+		mux.HandleFunc("/v1/list", srv.ListHandler)
+		mux.HandleFunc("/v1/batch", srv.BatchHandler)
+		mux.HandleFunc("/v1/admin/topology", srv.TopologyHandler)
+		handlers[i] = mux
+	}
+	return c
+}
+
+func TestClientRoutesToOwner(t *testing.T) {
+	cluster := newCluster(t, 3, 1)
+	c := client.New(cluster.shards)
+	defer c.Close()
+	ctx := context.Background()
+
+	for i := range 20 {
+		key := fmt.Sprintf("key-%d", i)
+		if _, err := c.Set(ctx, key, []byte("v"+key)); err != nil {
+			t.Fatalf("Set(%s) failed: %v", key, err)
+		}
+		// The key went straight to its owner, not through another shard.
+		if _, err := cluster.dbs[cluster.shards.Index(key)].GetKey(key); err != nil {
+			t.Errorf("%s is not stored on its owner: %v", key, err)
+		}
+	}
+	var total int64
+	for i := range cluster.served {
+		total += cluster.served[i].Load()
+	}
+	if total != 20 {
+		t.Errorf("nodes served %d requests for 20 writes; some were proxied", total)
+	}
+
+	val, err := c.Get(ctx, "key-7")
+	if err != nil || string(val) != "vkey-7" {
+		t.Errorf("Get(key-7) = %q, %v", val, err)
+	}
+	if err := c.Delete(ctx, "key-7"); err != nil {
+		t.Errorf("Delete(key-7) failed: %v", err)
+	}
+	if _, err := c.Get(ctx, "key-7"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+
+	results, err := c.Batch(ctx, []client.Op{
+		{Key: "key-1", Delete: true},
+		{Key: "key-2", Value: []byte("first")},
+		{Key: "key-2", Value: []byte("second")},
+		{Key: "key-2", Get: true},
+		{Key: "key-1", Get: true},
+		{Key: "key-3", Get: true},
+	})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("Batch returned %d results, want 6", len(results))
+	}
+	// Gets see the writes before them in the batch.
+	if r := results[3]; r.Key != "key-2" || !r.Found || string(r.Value) != "second" || r.Err != nil {
+		t.Errorf("batch get of key-2 = %+v", r)
+	}
+	if r := results[4]; r.Found || r.Err != nil {
+		t.Errorf("batch get of deleted key-1 = %+v", r)
+	}
+	if r := results[5]; !r.Found || string(r.Value) != "vkey-3" {
+		t.Errorf("batch get of key-3 = %+v", r)
+	}
+	if val, _ := c.Get(ctx, "key-2"); string(val) != "second" {
+		t.Errorf("Get(key-2) after batch = %q, want second", val)
+	}
+
+	items, next, err := c.Scan(ctx, "key-10", "key-15", 3)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(items) != 3 || items[0].Key != "key-10" || items[2].Key != "key-12" || next != "key-13" {
+		t.Errorf("Scan returned %v, next %q", items, next)
+	}
+	items, _, err = c.List(ctx, "key-1", "", 100)
+	if err != nil || len(items) != 10 { // key-1 deleted, key-10..key-19 left
+		t.Errorf("List returned %d items, %v", len(items), err)
+	}
+}
+
+func TestClientRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	shards := &config.Shards{Count: 1, CurIdx: -1, Addrs: map[int]string{0: strings.TrimPrefix(ts.URL, "http://")}}
+	c := client.New(shards, client.WithBackoff(time.Millisecond))
+	defer c.Close()
+
+	val, err := c.Get(context.Background(), "k")
+	if err != nil || string(val) != "ok" || calls.Load() != 3 {
+		t.Errorf("Get = %q, %v after %d calls", val, err, calls.Load())
+	}
+
+	calls.Store(-10)
+	_, err = c.Get(context.Background(), "k")
+	var statusErr *client.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Get after exhausting retries returned %v", err)
+	}
+	if calls.Load() != -10+client.DefaultRetries+1 {
+		t.Errorf("made %d attempts, want %d", calls.Load()+10, client.DefaultRetries+1)
+	}
+}
+
+func TestClientFollowsTopologyChanges(t *testing.T) {
+	cluster := newCluster(t, 2, 2)
+
+	// The client still thinks the first node owns every key.
+	stale := &config.Shards{Epoch: 1, Count: 1, CurIdx: -1, Addrs: map[int]string{0: cluster.shards.Addrs[0]}}
+	c := client.New(stale)
+	defer c.Close()
+	ctx := context.Background()
+
+	key := "key-0"
+	for i := 0; cluster.shards.Index(key) != 1; i++ {
+		key = fmt.Sprintf("key-%d", i)
+	}
+	if _, err := c.Set(ctx, key, []byte("v")); err != nil {
+		t.Fatalf("Set through a stale topology failed: %v", err)
+	}
+	if topo := c.Topology(); topo.Epoch != 2 || topo.Count != 2 {
+		t.Fatalf("client topology is epoch %d with %d shards, want epoch 2 with 2", topo.Epoch, topo.Count)
+	}
+
+	before := cluster.served[0].Load()
+	if val, err := c.Get(ctx, key); err != nil || string(val) != "v" {
+		t.Errorf("Get = %q, %v", val, err)
+	}
+	if cluster.served[0].Load() != before {
+		t.Errorf("read after the topology change went through the old owner")
+	}
+}
+
+func TestDial(t *testing.T) {
+	cluster := newCluster(t, 2, 5)
+	c, err := client.Dial(context.Background(), cluster.shards.Addrs[1])
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+
+	topo := c.Topology()
+	if topo.Epoch != 5 || topo.Count != 2 {
+		t.Fatalf("dialed topology is epoch %d with %d shards", topo.Epoch, topo.Count)
+	}
+	for i := range 50 {
+		key := fmt.Sprintf("k%d", i)
+		if got, want := topo.Index(key), cluster.shards.Index(key); got != want {
+			t.Fatalf("client routes %s to %d, servers to %d", key, got, want)
+		}
+	}
+}
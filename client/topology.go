@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Sagor0078/distribKV/config"
+)
+
+// Topology returns the topology the client currently routes by.
+func (c *Client) Topology() *config.Shards {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.shards
+}
+
+func (c *Client) setTopology(shards *config.Shards) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shards = shards
+	if c.balancer != nil {
+		c.balancer.Update(shards)
+	}
+}
+
+// refresh loads a topology with at least the given epoch, from the config
+// file if the client was opened from one, or else from the cluster.
+func (c *Client) refresh(ctx context.Context, epoch uint64) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	cur := c.Topology()
+	if cur.Epoch >= epoch {
+		return nil // refreshed by someone else meanwhile
+	}
+
+	var errs []error
+	if c.topologyFile != "" {
+		shards, err := config.LoadShards(c.topologyFile, "")
+		if err == nil && shards.Epoch >= epoch {
+			c.setTopology(shards)
+			return nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error parsing config %q: %w", c.topologyFile, err))
+		}
+	}
+
+	idxs := make([]int, 0, len(cur.Addrs))
+	for idx := range cur.Addrs {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	for _, idx := range idxs {
+		shards, err := c.fetchTopology(ctx, cur.Addrs[idx])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if shards.Epoch >= epoch {
+			c.setTopology(shards)
+			return nil
+		}
+	}
+	return fmt.Errorf("no topology with epoch %d found: %w", epoch, errors.Join(errs...))
+}
+
+// fetchTopology gets the topology from the node at addr.
+func (c *Client) fetchTopology(ctx context.Context, addr string) (*config.Shards, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/v1/admin/topology", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("topology request to %s returned %d", addr, resp.StatusCode)
+	}
+
+	var t Topology
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, fmt.Errorf("error decoding topology from %s: %w", addr, err)
+	}
+
+	var shards *config.Shards
+	if len(t.Shards) > 0 {
+		if shards, err = config.ParseTopology(t.Shards); err != nil {
+			return nil, fmt.Errorf("invalid topology from %s: %w", addr, err)
+		}
+	} else {
+		// The node routes without a config file, by key hash modulo the
+		// shard count.
+		shards = &config.Shards{Count: len(t.Addrs), CurIdx: -1, Addrs: t.Addrs, Replicas: t.Replicas}
+	}
+	if shards.Count == 0 {
+		return nil, fmt.Errorf("empty topology from %s", addr)
+	}
+	shards.Epoch = t.Epoch
+	return shards, nil
+}
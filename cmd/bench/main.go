@@ -1,72 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
-	"net/http"
-	"net/url"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/Sagor0078/distribKV/balancer"
+	"github.com/Sagor0078/distribKV/client"
 )
 
 var (
-	shardAddrs      = flag.String("shard-addrs", "localhost:8080", "Comma-separated list of shard addresses")
-	iterations      = flag.Int("iterations", 1000, "The number of iterations for writing")
-	readIterations  = flag.Int("read-iterations", 10000, "The number of iterations for reading")
-	concurrency     = flag.Int("concurrency", 1, "Number of goroutines to run in parallel")
-	servers         []string
-	httpClient      = &http.Client{Timeout: 5 * time.Second}
+	configFile     = flag.String("config-file", "sharding.toml", "Config file for static sharding, as given to the servers")
+	lbStrategy     = flag.String("lb-strategy", "", "Spread reads over replicas: round-robin, least-outstanding or latency")
+	iterations     = flag.Int("iterations", 1000, "The number of iterations for writing")
+	readIterations = flag.Int("read-iterations", 10000, "The number of iterations for reading")
+	concurrency    = flag.Int("concurrency", 1, "Number of goroutines to run in parallel")
+	kv             *client.Client
 )
 
-func shardIndex(key string, numShards int) int {
-	h := 0
-	for i := 0; i < len(key); i++ {
-		h = int(key[i]) + 31*h
-	}
-	return h % numShards
-}
-
 func writeRand() (key string) {
 	key = fmt.Sprintf("key-%d", rand.Intn(1000000))
 	value := fmt.Sprintf("value-%d", rand.Intn(1000000))
 
-	values := url.Values{}
-	values.Set("key", key)
-	values.Set("value", value)
-
-	shard := shardIndex(key, len(servers))
-	addr := servers[shard]
-
-	resp, err := httpClient.Get("http://" + addr + "/set?" + values.Encode())
-	if err != nil {
+	if _, err := kv.Set(context.Background(), key, []byte(value)); err != nil {
 		log.Fatalf("Error during set: %v", err)
 	}
-	io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-
 	return key
 }
 
 func readRand(allKeys []string) (key string) {
 	key = allKeys[rand.Intn(len(allKeys))]
 
-	values := url.Values{}
-	values.Set("key", key)
-
-	shard := shardIndex(key, len(servers))
-	addr := servers[shard]
-
-	resp, err := httpClient.Get("http://" + addr + "/get?" + values.Encode())
-	if err != nil {
+	if _, err := kv.Get(context.Background(), key); err != nil && !errors.Is(err, client.ErrNotFound) {
 		log.Fatalf("Error during get: %v", err)
 	}
-	io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-
 	return key
 }
 
@@ -143,10 +115,22 @@ func main() {
 	flag.Parse()
 	rand.Seed(time.Now().UnixNano())
 
-	servers = strings.Split(*shardAddrs, ",")
+	var opts []client.Option
+	if *lbStrategy != "" {
+		strategy, err := balancer.ParseStrategy(*lbStrategy)
+		if err != nil {
+			log.Fatalf("Error parsing lb-strategy: %v", err)
+		}
+		opts = append(opts, client.WithBalancer(strategy))
+	}
+	var err error
+	if kv, err = client.Open(*configFile, opts...); err != nil {
+		log.Fatalf("Error creating client: %v", err)
+	}
+	defer kv.Close()
 
 	fmt.Printf("Benchmarking with %d write iterations, %d read iterations, %d concurrency, on %d shards\n",
-		*iterations, *readIterations, *concurrency, len(servers))
+		*iterations, *readIterations, *concurrency, kv.Topology().Count)
 
 	allKeys := benchmarkWrite()
 	benchmarkRead(allKeys)
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sagor0078/distribKV/client"
+	"github.com/Sagor0078/distribKV/db"
+)
+
+// manifestFile is the name of the manifest in a shard's backup directory.
+const manifestFile = "manifest.json"
+
+// backupManifest lists the backups of one shard, oldest first.
+type backupManifest struct {
+	Shard   string        `json:"shard"`
+	Backups []backupEntry `json:"backups"`
+}
+
+// backupEntry is one backup file of a shard. DB is the ID of the leader's
+// database it was taken from; incremental backups only follow a backup of
+// the same database.
+type backupEntry struct {
+	// ID names the backup run; the backups of all shards taken by one
+	// backup command share it.
+	ID     string    `json:"id"`
+	File   string    `json:"file"`
+	Full   bool      `json:"full"`
+	Since  uint64    `json:"since"`
+	Next   uint64    `json:"next"`
+	Seq    uint64    `json:"seq"`
+	Leader string    `json:"leader"`
+	DB     string    `json:"db"`
+	Time   time.Time `json:"time"`
+}
+
+func readManifest(dir, shard string) (*backupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &backupManifest{Shard: shard}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m backupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest in %s: %w", dir, err)
+	}
+	return &m, nil
+}
+
+func (m *backupManifest) write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, manifestFile), func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// chain returns the backups to restore to reach the backup with the given
+// ID, or the latest one if id is empty: the full backup it builds on and
+// the incremental ones after that, in order.
+func (m *backupManifest) chain(id string) ([]backupEntry, error) {
+	end := len(m.Backups) - 1
+	if id != "" {
+		for end >= 0 && m.Backups[end].ID != id {
+			end--
+		}
+	}
+	if end < 0 {
+		if id == "" {
+			return nil, fmt.Errorf("shard %s has no backups", m.Shard)
+		}
+		return nil, fmt.Errorf("shard %s has no backup %s", m.Shard, id)
+	}
+
+	start := end
+	for !m.Backups[start].Full {
+		if start == 0 {
+			return nil, fmt.Errorf("backup %s of shard %s has no full backup before it", id, m.Shard)
+		}
+		start--
+	}
+	chain := m.Backups[start : end+1]
+	for i := 1; i < len(chain); i++ {
+		if chain[i].Since != chain[i-1].Next {
+			return nil, fmt.Errorf("backup %s of shard %s does not follow %s", chain[i].ID, m.Shard, chain[i-1].ID)
+		}
+	}
+	return chain, nil
+}
+
+// shardName returns the name of a shard in the client's topology.
+func (c *cli) shardName(idx int) string {
+	for _, s := range c.kv.Topology().Layout {
+		if s.Idx == idx && s.Name != "" {
+			return s.Name
+		}
+	}
+	return fmt.Sprintf("shard-%d", idx)
+}
+
+func (c *cli) backup(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "full") {
+		return errors.New("usage: backup <dir> [full]")
+	}
+	dir, full := args[0], len(args) == 2
+	id := time.Now().UTC().Format("20060102T150405Z")
+
+	type backupResult struct {
+		node
+		Name   string       `json:"name"`
+		Backup *backupEntry `json:"backup,omitempty"`
+		Error  string       `json:"error,omitempty"`
+	}
+	var leaders []node
+	for _, n := range c.nodes() {
+		if n.Leader {
+			leaders = append(leaders, n)
+		}
+	}
+	results := make([]backupResult, len(leaders))
+	forEach(leaders, func(i int, n node) {
+		results[i].node = n
+		results[i].Name = c.shardName(n.Shard)
+		entry, err := c.backupShard(ctx, filepath.Join(dir, results[i].Name), results[i].Name, n.Addr, id, full)
+		if err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		results[i].Backup = entry
+	})
+
+	if c.json {
+		return c.printJSON(results)
+	}
+	rows := make([][]string, len(results))
+	var failed int
+	for i, r := range results {
+		if r.Error != "" {
+			failed++
+			rows[i] = []string{r.Name, r.Addr, "-", "-", "failed: " + r.Error}
+			continue
+		}
+		kind := "incremental"
+		if r.Backup.Full {
+			kind = "full"
+		}
+		rows[i] = []string{r.Name, r.Addr, r.Backup.File, kind, strconv.FormatUint(r.Backup.Seq, 10)}
+	}
+	c.printTable([]string{"SHARD", "ADDRESS", "FILE", "TYPE", "POSITION"}, rows)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d shards were not backed up", failed, len(results))
+	}
+	return nil
+}
+
+// backupShard takes a backup of the shard led by addr into dir and records
+// it in the shard's manifest. The backup is incremental unless full is set,
+// the shard has no backup yet or its leader's database is not the one the
+// last backup was taken from, as when the leader was wiped or restored.
+func (c *cli) backupShard(ctx context.Context, dir, name, addr, id string, full bool) (*backupEntry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range m.Backups {
+		if b.ID == id {
+			return nil, fmt.Errorf("shard %s already has a backup %s", name, id)
+		}
+	}
+
+	entry := backupEntry{ID: id, Full: true, Leader: addr, Time: time.Now().UTC()}
+	var last *backupEntry
+	if n := len(m.Backups); n > 0 {
+		last = &m.Backups[n-1]
+	}
+	if !full && last != nil && last.DB != "" {
+		entry.Full, entry.Since = false, last.Next
+	}
+
+	resp, err := c.fetchBackup(ctx, addr, entry.Since, last)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed && !entry.Full {
+		// The leader's database is not the one last backed up.
+		resp.Body.Close()
+		entry.Full, entry.Since = true, 0
+		if resp, err = c.fetchBackup(ctx, addr, 0, nil); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	entry.File = id + "-incr.backup"
+	if entry.Full {
+		entry.File = id + "-full.backup"
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s returned %d: %s", resp.Request.URL.Path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	entry.DB = resp.Header.Get(client.BackupDBHeader)
+
+	err = writeFileAtomic(filepath.Join(dir, entry.File), func(f *os.File) error {
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			return err
+		}
+		// Trailers are only sent once the whole backup is.
+		if entry.Seq, err = strconv.ParseUint(resp.Trailer.Get(client.BackupSeqTrailer), 10, 64); err != nil {
+			return fmt.Errorf("backup from %s is incomplete", addr)
+		}
+		if entry.Next, err = strconv.ParseUint(resp.Trailer.Get(client.BackupNextTrailer), 10, 64); err != nil {
+			return fmt.Errorf("backup from %s is incomplete", addr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if entry.Seq == 0 && last != nil && !entry.Full {
+		entry.Seq = last.Seq
+	}
+
+	m.Backups = append(m.Backups, entry)
+	if err := m.write(dir); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// fetchBackup requests a backup from the leader at addr, incremental after
+// the backup last if since is not 0.
+func (c *cli) fetchBackup(ctx context.Context, addr string, since uint64, last *backupEntry) (*http.Response, error) {
+	q := url.Values{"since": {strconv.FormatUint(since, 10)}}
+	if since > 0 {
+		q.Set("db", last.DB)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/v1/admin/backup?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+// writeFileAtomic writes a file through fn, replacing path only once fn
+// succeeds.
+func writeFileAtomic(path string, fn func(f *os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := fn(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (c *cli) restore(ctx context.Context, args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return errors.New("usage: restore <dir> <shard|all> <db-location> [backup-id]")
+	}
+	dir, shard, location := args[0], args[1], args[2]
+	var id string
+	if len(args) == 4 {
+		id = args[3]
+	}
+
+	// With all, every shard is restored into a directory of its own under
+	// location, named like the shard.
+	targets := map[string]string{shard: location}
+	if shard == "all" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		targets = map[string]string{}
+		for _, e := range entries {
+			if _, err := os.Stat(filepath.Join(dir, e.Name(), manifestFile)); e.IsDir() && err == nil {
+				targets[e.Name()] = filepath.Join(location, e.Name())
+			}
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("no shard backups in %s", dir)
+		}
+	}
+
+	type restoreResult struct {
+		Shard    string `json:"shard"`
+		Location string `json:"location"`
+		Backup   string `json:"backup"`
+		Seq      uint64 `json:"seq"`
+	}
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []restoreResult
+	for _, name := range names {
+		backup, seq, err := restoreShard(filepath.Join(dir, name), name, targets[name], id)
+		if err != nil {
+			return fmt.Errorf("restoring shard %s: %w", name, err)
+		}
+		results = append(results, restoreResult{Shard: name, Location: targets[name], Backup: backup, Seq: seq})
+	}
+
+	if c.json {
+		return c.printJSON(results)
+	}
+	rows := make([][]string, len(results))
+	for i, r := range results {
+		rows[i] = []string{r.Shard, r.Location, r.Backup, strconv.FormatUint(r.Seq, 10)}
+	}
+	c.printTable([]string{"SHARD", "DB-LOCATION", "BACKUP", "POSITION"}, rows)
+	return nil
+}
+
+// restoreShard restores the backup of a shard with the given ID, or its
+// latest one, into a new database at location, and returns the ID and the
+// log position restored.
+func restoreShard(dir, name, location, id string) (string, uint64, error) {
+	m, err := readManifest(dir, name)
+	if err != nil {
+		return "", 0, err
+	}
+	chain, err := m.chain(id)
+	if err != nil {
+		return "", 0, err
+	}
+
+	readers := make([]io.Reader, len(chain))
+	for i, entry := range chain {
+		f, err := os.Open(filepath.Join(dir, entry.File))
+		if err != nil {
+			return "", 0, err
+		}
+		defer f.Close()
+		readers[i] = f
+	}
+
+	database, closeDB, err := db.NewDatabase(location, false)
+	if err != nil {
+		return "", 0, err
+	}
+	defer closeDB()
+	seq, err := database.Restore(readers...)
+	if err != nil {
+		return "", 0, err
+	}
+	return chain[len(chain)-1].ID, seq, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackupManifestChain(t *testing.T) {
+	m := &backupManifest{Shard: "alpha", Backups: []backupEntry{
+		{ID: "1", Full: true, Next: 10},
+		{ID: "2", Since: 10, Next: 20},
+		{ID: "3", Full: true, Next: 30},
+		{ID: "4", Since: 30, Next: 40},
+		{ID: "5", Since: 40, Next: 50},
+	}}
+
+	ids := func(chain []backupEntry) []string {
+		var ids []string
+		for _, e := range chain {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		id   string
+		want []string
+	}{
+		{"", []string{"3", "4", "5"}},
+		{"5", []string{"3", "4", "5"}},
+		{"4", []string{"3", "4"}},
+		{"3", []string{"3"}},
+		{"2", []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		chain, err := m.chain(tt.id)
+		if err != nil {
+			t.Errorf("chain(%q) failed: %v", tt.id, err)
+			continue
+		}
+		if got := ids(chain); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("chain(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+
+	failures := []struct {
+		name string
+		m    *backupManifest
+		id   string
+		want string
+	}{
+		{"empty", &backupManifest{Shard: "alpha"}, "", "has no backups"},
+		{"unknown id", m, "9", "has no backup 9"},
+		{"no full backup", &backupManifest{Shard: "alpha", Backups: []backupEntry{{ID: "1", Since: 10}}}, "1", "no full backup"},
+		{"gap", &backupManifest{Shard: "alpha", Backups: []backupEntry{
+			{ID: "1", Full: true, Next: 10},
+			{ID: "2", Since: 15, Next: 20},
+		}}, "", "does not follow"},
+	}
+	for _, tt := range failures {
+		_, err := tt.m.chain(tt.id)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: chain(%q) returned %v, want an error containing %q", tt.name, tt.id, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sagor0078/distribKV/client"
+)
+
+const defaultLimit = 100
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: get <key>")
+	}
+	val, err := c.kv.Get(ctx, args[0])
+	if errors.Is(err, client.ErrNotFound) {
+		return fmt.Errorf("key %q not found", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(pair{Key: args[0], Value: string(val)})
+	}
+	fmt.Fprintf(c.out, "%s\n", val)
+	return nil
+}
+
+func (c *cli) set(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: set <key> <value>")
+	}
+	token, err := c.kv.Set(ctx, args[0], []byte(args[1]))
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"key": args[0], "token": token})
+	}
+	fmt.Fprintf(c.out, "OK (write token %s)\n", token)
+	return nil
+}
+
+func (c *cli) del(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: del <key>")
+	}
+	if err := c.kv.Delete(ctx, args[0]); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]any{"key": args[0], "deleted": true})
+	}
+	fmt.Fprintln(c.out, "OK")
+	return nil
+}
+
+func (c *cli) scan(ctx context.Context, args []string) error {
+	if len(args) > 3 {
+		return errors.New("usage: scan [start] [end] [limit]")
+	}
+	var start, end string
+	if len(args) > 0 {
+		start = args[0]
+	}
+	if len(args) > 1 {
+		end = args[1]
+	}
+	limit, err := parseLimit(args, 2)
+	if err != nil {
+		return err
+	}
+	items, next, err := c.kv.Scan(ctx, start, end, limit)
+	if err != nil {
+		return err
+	}
+	return c.printPage(items, next)
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: list <prefix> [limit]")
+	}
+	limit, err := parseLimit(args, 1)
+	if err != nil {
+		return err
+	}
+	items, next, err := c.kv.List(ctx, args[0], "", limit)
+	if err != nil {
+		return err
+	}
+	return c.printPage(items, next)
+}
+
+func parseLimit(args []string, i int) (int, error) {
+	if len(args) <= i {
+		return defaultLimit, nil
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid limit %q", args[i])
+	}
+	return n, nil
+}
+
+// pair is a key-value pair as printed, with the value as text.
+type pair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (c *cli) printPage(items []client.KV, next string) error {
+	pairs := make([]pair, len(items))
+	for i, kv := range items {
+		pairs[i] = pair{Key: kv.Key, Value: string(kv.Value)}
+	}
+	if c.json {
+		return c.printJSON(struct {
+			Items []pair `json:"items"`
+			Next  string `json:"next,omitempty"`
+		}{pairs, next})
+	}
+
+	rows := make([][]string, len(pairs))
+	for i, p := range pairs {
+		rows[i] = []string{p.Key, p.Value}
+	}
+	c.printTable([]string{"KEY", "VALUE"}, rows)
+	if next != "" {
+		fmt.Fprintf(c.out, "(more keys from %q)\n", next)
+	}
+	return nil
+}
+
+// node is a node of the cluster.
+type node struct {
+	Shard int    `json:"shard"`
+	Addr  string `json:"addr"`
+	// Leader is set for shard leaders, as opposed to replicas.
+	Leader bool `json:"leader"`
+}
+
+// nodes returns every node in the client's topology, by shard, leaders first.
+func (c *cli) nodes() []node {
+	topo := c.kv.Topology()
+	var nodes []node
+	for idx := range topo.Count {
+		nodes = append(nodes, node{Shard: idx, Addr: topo.Addrs[idx], Leader: true})
+		for _, addr := range topo.GetReplicas(idx) {
+			nodes = append(nodes, node{Shard: idx, Addr: addr})
+		}
+	}
+	return nodes
+}
+
+func (c *cli) topology(ctx context.Context, args []string) error {
+	type nodeTopology struct {
+		node
+		Epoch uint64 `json:"epoch,omitempty"`
+		Error string `json:"error,omitempty"`
+	}
+
+	nodes := c.nodes()
+	results := make([]nodeTopology, len(nodes))
+	forEach(nodes, func(i int, n node) {
+		var t client.Topology
+		results[i].node = n
+		if err := c.getJSON(ctx, n.Addr, "/v1/admin/topology", &t); err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		results[i].Epoch = t.Epoch
+	})
+
+	if c.json {
+		return c.printJSON(struct {
+			Epoch uint64         `json:"epoch"`
+			Nodes []nodeTopology `json:"nodes"`
+		}{c.kv.Topology().Epoch, results})
+	}
+
+	fmt.Fprintf(c.out, "Topology epoch %d, %d shards\n", c.kv.Topology().Epoch, c.kv.Topology().Count)
+	rows := make([][]string, len(results))
+	for i, r := range results {
+		epoch := strconv.FormatUint(r.Epoch, 10)
+		if r.Error != "" {
+			epoch = "unreachable: " + r.Error
+		}
+		rows[i] = []string{strconv.Itoa(r.Shard), role(r.node), r.Addr, epoch}
+	}
+	c.printTable([]string{"SHARD", "ROLE", "ADDRESS", "EPOCH"}, rows)
+	return nil
+}
+
+func (c *cli) replicationStatus(ctx context.Context, args []string) error {
+	type nodeStatus struct {
+		Addr   string                    `json:"addr"`
+		Status *client.ReplicationStatus `json:"status,omitempty"`
+		Error  string                    `json:"error,omitempty"`
+	}
+
+	nodes := c.nodes()
+	results := make([]nodeStatus, len(nodes))
+	forEach(nodes, func(i int, n node) {
+		results[i].Addr = n.Addr
+		var st client.ReplicationStatus
+		if err := c.getJSON(ctx, n.Addr, "/v1/admin/replication", &st); err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		results[i].Status = &st
+	})
+
+	if c.json {
+		return c.printJSON(results)
+	}
+
+	rows := make([][]string, len(results))
+	for i, r := range results {
+		if r.Status == nil {
+			rows[i] = []string{strconv.Itoa(nodes[i].Shard), r.Addr, role(nodes[i]), "-", "-", "-", "-", "unreachable: " + r.Error}
+			continue
+		}
+		st := r.Status
+		lag, staleness, lastPull := "-", "-", "-"
+		if st.Synced {
+			lag = strconv.FormatUint(st.Lag, 10)
+			staleness = st.Staleness.Round(time.Millisecond).String()
+		}
+		var notes []string
+		if st.Leader != "" {
+			notes = append(notes, "following "+st.Leader)
+		}
+		if p := st.Pulls; p != nil {
+			if !p.LastPull.IsZero() {
+				lastPull = time.Since(p.LastPull).Round(time.Millisecond).String() + " ago"
+			}
+			if p.Errors > 0 {
+				notes = append(notes, fmt.Sprintf("%d pull errors (%d in a row), last: %s", p.Errors, p.ConsecutiveErrors, p.LastError))
+			}
+		}
+		for _, replica := range sortedKeys(st.Pending) {
+			notes = append(notes, fmt.Sprintf("%s acked %d, %d pending", replica, st.Acks[replica], st.Pending[replica]))
+		}
+		if st.Backlog > 0 {
+			notes = append(notes, fmt.Sprintf("%d entries in log", st.Backlog))
+		}
+		rows[i] = []string{strconv.Itoa(st.Shard), r.Addr, st.Role, strconv.FormatUint(st.Position, 10), lag, staleness, lastPull, strings.Join(notes, ", ")}
+	}
+	c.printTable([]string{"SHARD", "ADDRESS", "ROLE", "POSITION", "LAG", "STALENESS", "LAST PULL", "NOTES"}, rows)
+	return nil
+}
+
+func (c *cli) purge(ctx context.Context, args []string) error {
+	type purgeResult struct {
+		node
+		Error string `json:"error,omitempty"`
+	}
+
+	var leaders []node
+	for _, n := range c.nodes() {
+		if n.Leader {
+			leaders = append(leaders, n)
+		}
+	}
+	results := make([]purgeResult, len(leaders))
+	forEach(leaders, func(i int, n node) {
+		results[i].node = n
+		if err := c.post(ctx, n.Addr, "/purge"); err != nil {
+			results[i].Error = err.Error()
+		}
+	})
+
+	if c.json {
+		return c.printJSON(results)
+	}
+	rows := make([][]string, len(results))
+	for i, r := range results {
+		result := "purged"
+		if r.Error != "" {
+			result = "failed: " + r.Error
+		}
+		rows[i] = []string{strconv.Itoa(r.Shard), r.Addr, result}
+	}
+	c.printTable([]string{"SHARD", "ADDRESS", "RESULT"}, rows)
+	return nil
+}
+
+func (c *cli) help(ctx context.Context, args []string) error {
+	printCommands(c.out)
+	return nil
+}
+
+func role(n node) string {
+	if n.Leader {
+		return "leader"
+	}
+	return "replica"
+}
+
+// forEach runs fn for every node in parallel and waits for all of them.
+func forEach(nodes []node, fn func(i int, n node)) {
+	done := make(chan struct{})
+	for i, n := range nodes {
+		go func() {
+			fn(i, n)
+			done <- struct{}{}
+		}()
+	}
+	for range nodes {
+		<-done
+	}
+}
+
+func (c *cli) getJSON(ctx context.Context, addr, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (c *cli) post(ctx context.Context, addr, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+func (c *cli) do(req *http.Request) ([]byte, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d: %s", req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) printTable(header []string, rows [][]string) {
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,198 @@
+// Command cli (distribkv-cli) is an operator tool for a distribKV cluster.
+// It reads the cluster layout from sharding.toml, or from a node given with
+// -addr, and runs one command given on the command line, or reads commands
+// interactively when there is none.
+//
+//	go build -o distribkv-cli ./cmd/cli
+//	distribkv-cli -config-file=sharding.toml get key-1
+//	distribkv-cli -output=json replication-status
+//	distribkv-cli
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sagor0078/distribKV/client"
+)
+
+var (
+	configFile = flag.String("config-file", "sharding.toml", "Config file for static sharding, as given to the servers")
+	addr       = flag.String("addr", "", "Load the topology from this node instead of the config file")
+	output     = flag.String("output", "table", "Output format: table or json")
+	timeout    = flag.Duration("timeout", 10*time.Second, "Timeout for each command")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command [args...]]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\nCommands:")
+		printCommands(flag.CommandLine.Output())
+	}
+	flag.Parse()
+
+	if *output != "table" && *output != "json" {
+		log.Fatalf("Invalid output format %q: must be table or json", *output)
+	}
+
+	kv, err := connect()
+	if err != nil {
+		log.Fatalf("Error connecting to the cluster: %v", err)
+	}
+	defer kv.Close()
+
+	c := &cli{
+		kv:   kv,
+		http: &http.Client{Timeout: *timeout},
+		out:  os.Stdout,
+		json: *output == "json",
+	}
+
+	if flag.NArg() > 0 {
+		if err := c.run(flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	c.repl(os.Stdin)
+}
+
+func connect() (*client.Client, error) {
+	if *addr == "" {
+		return client.Open(*configFile)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return client.Dial(ctx, *addr)
+}
+
+// cli runs commands against a cluster.
+type cli struct {
+	kv   *client.Client
+	http *http.Client
+	out  io.Writer
+	json bool
+}
+
+// command is a CLI command. run gets the arguments after the command name.
+type command struct {
+	args string
+	help string
+	run  func(c *cli, ctx context.Context, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"get":                {"<key>", "Print the value of a key", (*cli).get},
+		"set":                {"<key> <value>", "Store a value", (*cli).set},
+		"del":                {"<key>", "Delete a key", (*cli).del},
+		"scan":               {"[start] [end] [limit]", "List keys in [start, end) across all shards", (*cli).scan},
+		"list":               {"<prefix> [limit]", "List keys with a prefix across all shards", (*cli).list},
+		"topology":           {"", "Show the shards and the topology epoch each node runs", (*cli).topology},
+		"replication-status": {"", "Show the replication position, lag and errors of every node", (*cli).replicationStatus},
+		"purge":              {"", "Delete keys each shard leader no longer owns", (*cli).purge},
+		"backup":             {"<dir> [full]", "Back up every shard leader into dir, incrementally unless full (raise -timeout for large shards)", (*cli).backup},
+		"restore":            {"<dir> <shard|all> <db-location> [backup-id]", "Restore a shard, or all of them into db-location/<shard>, to a backup (the latest by default)", (*cli).restore},
+		"help":               {"", "Show this help", (*cli).help},
+	}
+}
+
+func printCommands(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %-50s %s\n", strings.TrimSpace(name+" "+cmd.args), cmd.help)
+	}
+}
+
+// run runs a command line split into words.
+func (c *cli) run(words []string) error {
+	cmd, ok := commands[words[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, try help", words[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return cmd.run(c, ctx, words[1:])
+}
+
+// repl reads and runs commands until in is exhausted or the user quits.
+func (c *cli) repl(in io.Reader) {
+	topo := c.kv.Topology()
+	fmt.Fprintf(c.out, "Connected to %d shards at topology epoch %d. Type help for commands, exit to quit.\n", topo.Count, topo.Epoch)
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(c.out, "distribkv> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(c.out)
+			return
+		}
+		words, err := splitWords(scanner.Text())
+		if err != nil {
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if words[0] == "exit" || words[0] == "quit" {
+			return
+		}
+		if err := c.run(words); err != nil {
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+		}
+	}
+}
+
+// splitWords splits a line on spaces. Double quotes group words and a
+// backslash escapes the next character.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '"':
+			quoted, inWord = !quoted, true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
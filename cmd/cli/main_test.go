@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  get   k  ", []string{"get", "k"}},
+		{"set k\tv", []string{"set", "k", "v"}},
+		{`set title "Sunset over the sea"`, []string{"set", "title", "Sunset over the sea"}},
+		{`set k ""`, []string{"set", "k", ""}},
+		{`set a"b c"d`, []string{"set", "ab cd"}},
+		{`set k a\ b\"c\\`, []string{"set", "k", `a b"c\`}},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.line)
+		if err != nil {
+			t.Errorf("splitWords(%q) failed: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	if _, err := splitWords(`set k "unterminated`); err == nil {
+		t.Error("splitWords accepted an unterminated quote")
+	}
+}
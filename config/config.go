@@ -2,7 +2,6 @@ package config
 
 import (
 	"fmt"
-	"hash/fnv"
 	"os"
 
 	"github.com/BurntSushi/toml"
@@ -10,24 +9,35 @@ import (
 
 // Shard defines a node in the cluster with replicas.
 type Shard struct {
-	Name     string   `toml:"name"`
-	Idx      int      `toml:"idx"`
-	Address  string   `toml:"address"`
-	Replicas []string `toml:"replicas"`
+	Name     string   `toml:"name" json:"name"`
+	Idx      int      `toml:"idx" json:"idx"`
+	Address  string   `toml:"address" json:"address"`
+	Replicas []string `toml:"replicas" json:"replicas,omitempty"`
+	// VirtualNodes is the number of positions the shard takes on the hash
+	// ring. Defaults to DefaultVirtualNodes.
+	VirtualNodes int `toml:"virtual_nodes" json:"virtual_nodes,omitempty"`
 }
 
 // Config holds the list of shards.
 type Config struct {
+	// Epoch versions the topology. It must be increased whenever the shard
+	// layout changes so nodes can tell a stale config from a current one.
+	Epoch  uint64  `toml:"epoch"`
 	Shards []Shard `toml:"shards"`
 }
 
 // ParseFile parses the TOML file into a Config.
 func ParseFile(filename string) (Config, error) {
-	var c Config
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return Config{}, fmt.Errorf("error reading file: %w", err)
 	}
+	return Parse(data)
+}
+
+// Parse parses TOML data into a Config.
+func Parse(data []byte) (Config, error) {
+	var c Config
 	if _, err := toml.Decode(string(data), &c); err != nil {
 		return Config{}, fmt.Errorf("TOML decode error: %w", err)
 	}
@@ -36,29 +46,77 @@ func ParseFile(filename string) (Config, error) {
 
 // Shards holds parsed shard metadata for routing.
 type Shards struct {
-	Count    int
-	CurIdx   int
+	// Epoch is the version of the config the topology was loaded from.
+	Epoch  uint64
+	Count  int
+	CurIdx int
+	// CurName is the name of the current shard, or empty when the topology
+	// was parsed for routing only.
+	CurName  string
 	Addrs    map[int]string
 	Replicas map[int][]string
+	// Partitioner maps keys to shards. When nil, keys are assigned by
+	// ModuloPartitioner.
+	Partitioner Partitioner
+	// Layout is the shard configuration the topology was parsed from, so
+	// it can be handed to clients that need the same partitioner.
+	Layout []Shard
+}
+
+// LoadShards reads a sharding config file and parses its topology. With an
+// empty name the topology is only used for routing and has no current shard.
+func LoadShards(filename, curShardName string) (*Shards, error) {
+	c, err := ParseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var s *Shards
+	if curShardName == "" {
+		s, err = ParseTopology(c.Shards)
+	} else {
+		s, err = ParseShards(c.Shards, curShardName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Epoch = c.Epoch
+	return s, nil
 }
 
 // ParseShards validates and converts shard configuration.
 func ParseShards(shards []Shard, curShardName string) (*Shards, error) {
+	s, err := ParseTopology(shards)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sh := range shards {
+		if sh.Name == curShardName {
+			s.CurIdx = sh.Idx
+			s.CurName = sh.Name
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("current shard %q not found", curShardName)
+}
+
+// ParseTopology validates and converts shard configuration without
+// selecting a current shard. CurIdx of the result is -1.
+func ParseTopology(shards []Shard) (*Shards, error) {
 	count := len(shards)
 	addrs := make(map[int]string)
 	replicas := make(map[int][]string)
-	curIdx := -1
 
 	for _, s := range shards {
 		if _, exists := addrs[s.Idx]; exists {
 			return nil, fmt.Errorf("duplicate shard index: %d", s.Idx)
 		}
+		if s.VirtualNodes < 0 {
+			return nil, fmt.Errorf("shard %q: negative virtual_nodes %d", s.Name, s.VirtualNodes)
+		}
 		addrs[s.Idx] = s.Address
 		replicas[s.Idx] = s.Replicas
-
-		if s.Name == curShardName {
-			curIdx = s.Idx
-		}
 	}
 
 	for i := 0; i < count; i++ {
@@ -67,23 +125,22 @@ func ParseShards(shards []Shard, curShardName string) (*Shards, error) {
 		}
 	}
 
-	if curIdx == -1 {
-		return nil, fmt.Errorf("current shard %q not found", curShardName)
-	}
-
 	return &Shards{
-		Count:    count,
-		CurIdx:   curIdx,
-		Addrs:    addrs,
-		Replicas: replicas,
+		Count:       count,
+		CurIdx:      -1,
+		Addrs:       addrs,
+		Replicas:    replicas,
+		Partitioner: NewHashRing(shards),
+		Layout:      shards,
 	}, nil
 }
 
 // Index determines the shard index for a given key.
 func (s *Shards) Index(key string) int {
-	h := fnv.New64()
-	_, _ = h.Write([]byte(key))
-	return int(h.Sum64() % uint64(s.Count))
+	if s.Partitioner != nil {
+		return s.Partitioner.Index(key)
+	}
+	return ModuloPartitioner{Count: s.Count}.Index(key)
 }
 
 // GetReplicas returns the replicas for a given shard index.
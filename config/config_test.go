@@ -8,6 +8,8 @@ import (
 )
 
 const tomlData = `
+epoch = 3
+
 [[shards]]
 name = "shard-0"
 idx = 0
@@ -19,6 +21,7 @@ name = "shard-1"
 idx = 1
 address = "127.0.0.3:8080"
 replicas = ["127.0.0.33:8080"]
+virtual_nodes = 64
 `
 
 func writeTempTOML(t *testing.T) string {
@@ -44,6 +47,28 @@ func TestParseFile(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, cfg.Shards, 2)
 	assert.Equal(t, "shard-0", cfg.Shards[0].Name)
+	assert.Equal(t, 0, cfg.Shards[0].VirtualNodes)
+	assert.Equal(t, 64, cfg.Shards[1].VirtualNodes)
+	assert.Equal(t, uint64(3), cfg.Epoch)
+}
+
+func TestLoadShards(t *testing.T) {
+	filename := writeTempTOML(t)
+	defer os.Remove(filename)
+
+	s, err := config.LoadShards(filename, "shard-1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), s.Epoch)
+	assert.Equal(t, 1, s.CurIdx)
+	assert.Equal(t, "shard-1", s.CurName)
+
+	s, err = config.LoadShards(filename, "")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, s.CurIdx)
+	assert.Equal(t, 2, s.Count)
+
+	_, err = config.LoadShards(filename, "shard-9")
+	assert.Error(t, err)
 }
 
 func TestParseShards(t *testing.T) {
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// DefaultVirtualNodes is the number of ring positions a shard gets when
+// virtual_nodes is not set in the config.
+const DefaultVirtualNodes = 128
+
+// Partitioner maps a key to the index of the shard that owns it.
+type Partitioner interface {
+	Index(key string) int
+}
+
+// ModuloPartitioner assigns keys by hash modulo the shard count. Adding a
+// shard remaps almost every key, so it is only used when no ring is configured.
+type ModuloPartitioner struct {
+	Count int
+}
+
+// Index returns fnv64(key) % Count.
+func (p ModuloPartitioner) Index(key string) int {
+	h := fnv.New64()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum64() % uint64(p.Count))
+}
+
+// HashRing is a consistent hashing ring. Each shard owns several virtual
+// nodes on the ring and a key belongs to the first virtual node clockwise
+// from its hash, so adding or removing a shard only moves about 1/N of keys.
+type HashRing struct {
+	points []uint64
+	owners []int
+}
+
+// NewHashRing builds a ring from the shards' names and virtual node counts.
+// Virtual node positions depend on the shard name, not its index, so
+// existing shards keep their positions when shards are added.
+func NewHashRing(shards []Shard) *HashRing {
+	type vnode struct {
+		point uint64
+		owner int
+	}
+
+	var vnodes []vnode
+	for _, s := range shards {
+		n := s.VirtualNodes
+		if n <= 0 {
+			n = DefaultVirtualNodes
+		}
+		for i := 0; i < n; i++ {
+			vnodes = append(vnodes, vnode{point: ringHash(fmt.Sprintf("%s#%d", s.Name, i)), owner: s.Idx})
+		}
+	}
+	sort.Slice(vnodes, func(i, j int) bool {
+		if vnodes[i].point != vnodes[j].point {
+			return vnodes[i].point < vnodes[j].point
+		}
+		return vnodes[i].owner < vnodes[j].owner
+	})
+
+	r := &HashRing{
+		points: make([]uint64, len(vnodes)),
+		owners: make([]int, len(vnodes)),
+	}
+	for i, v := range vnodes {
+		r.points[i] = v.point
+		r.owners[i] = v.owner
+	}
+	return r
+}
+
+// Index returns the shard owning key.
+func (r *HashRing) Index(key string) int {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// ringHash is FNV-64a followed by a 64-bit finalizer, which spreads short,
+// similar strings such as "shard-0#1" evenly around the ring.
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
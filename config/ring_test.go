@@ -0,0 +1,90 @@
+package config_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func ringShards(n int) []config.Shard {
+	shards := make([]config.Shard, n)
+	for i := range shards {
+		shards[i] = config.Shard{Name: fmt.Sprintf("shard-%d", i), Idx: i}
+	}
+	return shards
+}
+
+func movedFraction(before, after config.Partitioner, keys int) float64 {
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if before.Index(key) != after.Index(key) {
+			moved++
+		}
+	}
+	return float64(moved) / float64(keys)
+}
+
+func TestHashRingAddingShardMovesOneNth(t *testing.T) {
+	const keys = 50000
+	before := config.NewHashRing(ringShards(4))
+	after := config.NewHashRing(ringShards(5))
+
+	// Ideally 1/5 of keys move to the new shard, and only to it.
+	moved := movedFraction(before, after, keys)
+	assert.InDelta(t, 0.2, moved, 0.05, "ring moved %.3f of keys", moved)
+
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if before.Index(key) != after.Index(key) {
+			assert.Equal(t, 4, after.Index(key), "key %q moved between existing shards", key)
+		}
+	}
+
+	// Modulo sharding, for comparison, remaps most keys.
+	modulo := movedFraction(config.ModuloPartitioner{Count: 4}, config.ModuloPartitioner{Count: 5}, keys)
+	assert.Greater(t, modulo, 0.7)
+}
+
+func TestHashRingBalance(t *testing.T) {
+	const keys = 100000
+	ring := config.NewHashRing(ringShards(4))
+
+	counts := make(map[int]int)
+	for i := 0; i < keys; i++ {
+		counts[ring.Index(fmt.Sprintf("key-%d", i))]++
+	}
+	assert.Len(t, counts, 4)
+	for idx, c := range counts {
+		assert.InDelta(t, keys/4, c, keys/4*0.25, "shard %d owns %d keys", idx, c)
+	}
+}
+
+func TestHashRingVirtualNodesWeighting(t *testing.T) {
+	const keys = 50000
+	shards := ringShards(2)
+	shards[0].VirtualNodes = 300
+	shards[1].VirtualNodes = 100
+	ring := config.NewHashRing(shards)
+
+	owned := 0
+	for i := 0; i < keys; i++ {
+		if ring.Index(fmt.Sprintf("key-%d", i)) == 0 {
+			owned++
+		}
+	}
+	assert.InDelta(t, 0.75, float64(owned)/keys, 0.08)
+}
+
+func TestParseShardsUsesRing(t *testing.T) {
+	s, err := config.ParseShards(ringShards(3), "shard-1")
+	assert.NoError(t, err)
+
+	ring := config.NewHashRing(ringShards(3))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, ring.Index(key), s.Index(key))
+	}
+}
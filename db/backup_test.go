@@ -0,0 +1,91 @@
+package db_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_BackupAndRestore(t *testing.T) {
+	leader, closeLeader, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeLeader()) })
+
+	require.NoError(t, leader.SetKey("a", []byte("1")))
+	require.NoError(t, leader.SetKey("b", []byte("2")))
+
+	var full bytes.Buffer
+	fullInfo, err := leader.Backup(&full, 0)
+	require.NoError(t, err)
+	require.Equal(t, leader.LastSeq(), fullInfo.Seq)
+
+	// The incremental backup carries changes and deletes since the full one.
+	require.NoError(t, leader.SetKey("a", []byte("1b")))
+	require.NoError(t, leader.DeleteKey("b"))
+	require.NoError(t, leader.SetKey("c", []byte("3")))
+
+	var incr bytes.Buffer
+	incrInfo, err := leader.Backup(&incr, fullInfo.Next)
+	require.NoError(t, err)
+	require.Equal(t, leader.LastSeq(), incrInfo.Seq)
+	require.Greater(t, incrInfo.Next, fullInfo.Next)
+
+	// Nothing changed: an empty incremental backup.
+	var empty bytes.Buffer
+	emptyInfo, err := leader.Backup(&empty, incrInfo.Next)
+	require.NoError(t, err)
+	require.Zero(t, emptyInfo.Seq)
+	require.Equal(t, incrInfo.Next, emptyInfo.Next)
+
+	restored, closeRestored, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeRestored()) })
+
+	seq, err := restored.Restore(bytes.NewReader(full.Bytes()), &incr, &empty)
+	require.NoError(t, err)
+	require.Equal(t, incrInfo.Seq, seq)
+	require.Equal(t, seq, restored.LastSeq())
+
+	val, err := restored.GetKey("a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("1b"), val)
+	_, err = restored.GetKey("b")
+	require.ErrorIs(t, err, db.ErrNotFound)
+	val, err = restored.GetKey("c")
+	require.NoError(t, err)
+	require.Equal(t, []byte("3"), val)
+
+	// The log starts over after the restored position.
+	_, err = restored.ReplicationLog(0, 0)
+	require.ErrorIs(t, err, db.ErrLogTruncated)
+	require.NoError(t, restored.SetKey("d", []byte("4")))
+	entries, err := restored.ReplicationLog(seq, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, seq+1, entries[0].Seq)
+
+	// Backups are only restored into an empty database.
+	_, err = restored.Restore(io.LimitReader(&full, 0))
+	require.ErrorIs(t, err, db.ErrNotEmpty)
+
+	// The restored database is not the one backed up.
+	require.NotEmpty(t, restored.ID())
+	require.NotEqual(t, leader.ID(), restored.ID())
+}
+
+func TestDatabase_IDSurvivesReopening(t *testing.T) {
+	dir := createTempDir(t)
+	database, closeDB, err := db.NewDatabase(dir, false)
+	require.NoError(t, err)
+	id := database.ID()
+	require.NotEmpty(t, id)
+	require.NoError(t, closeDB())
+
+	database, closeDB, err = db.NewDatabase(dir, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeDB()) })
+	require.Equal(t, id, database.ID())
+}
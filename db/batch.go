@@ -0,0 +1,127 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Operations in a batch.
+const (
+	BatchPut    = "put"
+	BatchDelete = "delete"
+	BatchGet    = "get"
+)
+
+// BatchOp is one operation of a batch.
+type BatchOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// IsWrite reports whether the operation changes the store.
+func (op BatchOp) IsWrite() bool {
+	return op.Op != BatchGet
+}
+
+// BatchResult is the outcome of a get in a batch. Writes have empty results.
+type BatchResult struct {
+	Found bool
+	Value []byte
+}
+
+// Batch runs ops in order in a single transaction, logging each write for
+// replication. Gets observe the writes made earlier in the batch. Either
+// every write is applied or, on error, none is; writes to keys held by a
+// prepared transaction fail the batch with ErrLocked.
+func (d *Database) Batch(ops []BatchOp) ([]BatchResult, error) {
+	if !hasWrites(ops) {
+		results := make([]BatchResult, len(ops))
+		err := d.db.View(func(txn *badger.Txn) error {
+			return runBatch(txn, ops, results, nil)
+		})
+		return results, err
+	}
+	if d.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	results := make([]BatchResult, len(ops))
+	seq := d.seq
+	err := d.db.Update(func(txn *badger.Txn) error {
+		seq = d.seq
+		return runBatch(txn, ops, results, func(e LogEntry) error {
+			var err error
+			seq, err = d.appendLog(txn, seq, e)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if seq != d.seq {
+		d.publishLocked(seq)
+	}
+	return results, nil
+}
+
+func hasWrites(ops []BatchOp) bool {
+	for _, op := range ops {
+		if op.IsWrite() {
+			return true
+		}
+	}
+	return false
+}
+
+// runBatch executes ops in txn, storing get results in results. Each write
+// is passed to logWrite unless it is nil.
+func runBatch(txn *badger.Txn, ops []BatchOp, results []BatchResult, logWrite func(LogEntry) error) error {
+	for i, op := range ops {
+		var entry LogEntry
+		switch op.Op {
+		case BatchGet:
+			item, err := txn.Get([]byte(op.Key))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if results[i].Value, err = item.ValueCopy(nil); err != nil {
+				return err
+			}
+			results[i].Found = true
+			continue
+		case BatchPut:
+			if err := checkUnlocked(txn, op.Key); err != nil {
+				return err
+			}
+			if err := txn.Set([]byte(op.Key), op.Value); err != nil {
+				return err
+			}
+			entry = LogEntry{Op: OpSet, Key: op.Key, Value: op.Value}
+		case BatchDelete:
+			if err := checkUnlocked(txn, op.Key); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte(op.Key)); err != nil {
+				return err
+			}
+			entry = LogEntry{Op: OpDelete, Key: op.Key}
+		default:
+			return fmt.Errorf("unknown batch operation %q", op.Op)
+		}
+		if logWrite != nil {
+			if err := logWrite(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Batch(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	require.NoError(t, dbInstance.SetKey("old", []byte("x")))
+
+	results, err := dbInstance.Batch([]db.BatchOp{
+		{Op: db.BatchGet, Key: "a"},
+		{Op: db.BatchPut, Key: "a", Value: []byte("1")},
+		{Op: db.BatchGet, Key: "a"},
+		{Op: db.BatchDelete, Key: "old"},
+		{Op: db.BatchGet, Key: "old"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []db.BatchResult{
+		{},
+		{},
+		{Found: true, Value: []byte("1")},
+		{},
+		{},
+	}, results)
+
+	// Each write is replicated, in batch order.
+	entries, err := dbInstance.ReplicationLog(1, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, db.OpSet, entries[0].Op)
+	require.Equal(t, db.OpDelete, entries[1].Op)
+	require.Equal(t, uint64(3), dbInstance.LastSeq())
+
+	// A failing batch applies nothing.
+	_, err = dbInstance.Batch([]db.BatchOp{
+		{Op: db.BatchPut, Key: "b", Value: []byte("2")},
+		{Op: "increment", Key: "b"},
+	})
+	require.Error(t, err)
+	_, err = dbInstance.GetKey("b")
+	require.ErrorIs(t, err, db.ErrNotFound)
+	require.Equal(t, uint64(3), dbInstance.LastSeq())
+}
+
+func TestDatabase_BatchReadOnly(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	results, err := dbInstance.Batch([]db.BatchOp{{Op: db.BatchGet, Key: "a"}})
+	require.NoError(t, err)
+	require.False(t, results[0].Found)
+
+	_, err = dbInstance.Batch([]db.BatchOp{{Op: db.BatchPut, Key: "a"}})
+	require.ErrorIs(t, err, db.ErrReadOnly)
+}
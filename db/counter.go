@@ -0,0 +1,111 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// maxConflictRetries bounds how often Increment retries a transaction that
+// conflicted with a concurrent write.
+const maxConflictRetries = 10
+
+var (
+	// ErrNotInteger is returned when incrementing a key whose value is not
+	// a decimal integer.
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrOverflow is returned when an increment would overflow an int64.
+	ErrOverflow = errors.New("counter overflow")
+)
+
+// Increment adds delta to the integer stored under key and returns the new
+// value, logging the write for replication. A missing key counts as 0.
+// Counters are stored as decimal text, so they read like any other value,
+// and keep their expiry. The read and the write happen in one transaction,
+// retried if it conflicts with a concurrent write.
+func (d *Database) Increment(key string, delta int64) (int64, error) {
+	if d.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var n int64
+	var seq uint64
+	err := retryConflicts(func() error {
+		return d.db.Update(func(txn *badger.Txn) error {
+			if err := checkUnlocked(txn, key); err != nil {
+				return err
+			}
+			var expiresAt uint64
+			var err error
+			if n, expiresAt, err = increment(txn, key, delta); err != nil {
+				return err
+			}
+			val := []byte(strconv.FormatInt(n, 10))
+			seq, err = d.appendLog(txn, d.seq, LogEntry{Op: OpSet, Key: key, Value: val, ExpiresAt: expiresAt})
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	d.publishLocked(seq)
+	return n, nil
+}
+
+// increment writes the incremented counter under key in txn and returns it
+// with the key's expiry.
+func increment(txn *badger.Txn, key string, delta int64) (int64, uint64, error) {
+	var val []byte
+	var expiresAt uint64
+	item, err := txn.Get([]byte(key))
+	switch {
+	case err == nil:
+		expiresAt = item.ExpiresAt()
+		if val, err = item.ValueCopy(nil); err != nil {
+			return 0, 0, err
+		}
+	case !errors.Is(err, badger.ErrKeyNotFound):
+		return 0, 0, err
+	}
+
+	n, err := AddToCounter(key, val, delta)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, expiresAt, setEntry(txn, []byte(key), []byte(strconv.FormatInt(n, 10)), expiresAt)
+}
+
+// AddToCounter returns the counter stored under key as val plus delta. A
+// nil val counts as 0. It fails with ErrNotInteger if val is not a decimal
+// integer and with ErrOverflow if the sum does not fit an int64.
+func AddToCounter(key string, val []byte, delta int64) (int64, error) {
+	var cur int64
+	if val != nil {
+		var err error
+		if cur, err = strconv.ParseInt(string(val), 10, 64); err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrNotInteger, key)
+		}
+	}
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return 0, fmt.Errorf("%w: %q is %d", ErrOverflow, key, cur)
+	}
+	return cur + delta, nil
+}
+
+// retryConflicts runs fn until it does not fail with badger.ErrConflict, at
+// most maxConflictRetries times.
+func retryConflicts(fn func() error) error {
+	var err error
+	for range maxConflictRetries {
+		if err = fn(); !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package db_test
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Increment(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	// Concurrent increments are not lost.
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				_, err := dbInstance.Increment("hits", 1)
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := dbInstance.GetKey("hits")
+	require.NoError(t, err)
+	require.Equal(t, "200", string(val))
+
+	n, err := dbInstance.Increment("hits", -250)
+	require.NoError(t, err)
+	require.Equal(t, int64(-50), n)
+
+	require.NoError(t, dbInstance.SetKey("name", []byte("alice")))
+	_, err = dbInstance.Increment("name", 1)
+	require.ErrorIs(t, err, db.ErrNotInteger)
+
+	_, err = dbInstance.Increment("big", math.MaxInt64)
+	require.NoError(t, err)
+	_, err = dbInstance.Increment("big", 1)
+	require.ErrorIs(t, err, db.ErrOverflow)
+
+	// Counters keep their expiry.
+	require.NoError(t, dbInstance.SetKeyWithTTL("window", []byte("1"), time.Hour))
+	_, err = dbInstance.Increment("window", 1)
+	require.NoError(t, err)
+	e, err := dbInstance.GetEntry("window")
+	require.NoError(t, err)
+	require.Equal(t, "2", string(e.Value))
+	require.NotZero(t, e.ExpiresAt)
+
+	// Increments are replicated as plain writes.
+	replica, closeReplica, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeReplica()) })
+	entries, err := dbInstance.ReplicationLog(0, 0)
+	require.NoError(t, err)
+	require.NoError(t, replica.ApplyLogEntries(entries))
+	val, err = replica.GetKey("hits")
+	require.NoError(t, err)
+	require.Equal(t, "-50", string(val))
+}
+
+func TestAddToCounter(t *testing.T) {
+	n, err := db.AddToCounter("c", nil, 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+
+	n, err = db.AddToCounter("c", []byte("-3"), 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), n)
+
+	_, err = db.AddToCounter("c", []byte("abc"), 1)
+	require.ErrorIs(t, err, db.ErrNotInteger)
+
+	_, err = db.AddToCounter("c", []byte("-9223372036854775808"), -1)
+	require.ErrorIs(t, err, db.ErrOverflow)
+}
@@ -1,49 +1,84 @@
 package db
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 )
 
 var (
-	replicaPrefix = []byte("replica:")
+	// ErrNotFound is returned when a key does not exist.
+	ErrNotFound = errors.New("key not found")
+	// ErrReadOnly is returned for client writes to a read-only replica.
+	ErrReadOnly = errors.New("read-only mode")
 )
 
 // Database wraps a Badger DB instance.
 type Database struct {
 	db       *badger.DB
 	readOnly bool
+	id       string
+
+	// mu serializes writes so log sequence numbers follow commit order.
+	mu  sync.Mutex
+	seq uint64
+	// written is closed and replaced whenever seq advances.
+	written chan struct{}
 }
 
 // NewDatabase initializes and returns a new Badger database.
 // It ensures the dbPath exists before opening, to prevent "no manifest found" errors in read-only mode.
+//
+// A read-only database rejects client writes with ErrReadOnly and only
+// changes through ApplyLogEntries. Badger itself is still opened writable,
+// because that is how a replica applies the leader's replication log.
 func NewDatabase(dbPath string, readOnly bool) (*Database, func() error, error) {
 	// Ensure directory exists
 	if err := os.MkdirAll(dbPath, 0755); err != nil {
 		return nil, nil, fmt.Errorf("failed to create DB directory %q: %w", dbPath, err)
 	}
 
-	opts := badger.DefaultOptions(dbPath).WithReadOnly(false)
+	opts := badger.DefaultOptions(dbPath)
 	db, err := badger.Open(opts)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	var seq uint64
+	err = db.View(func(txn *badger.Txn) error {
+		var err error
+		seq, err = readSeq(txn, lastSeqKey)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	id, err := loadID(db)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
 	closeFunc := func() error {
 		return db.Close()
 	}
 
-	return &Database{db: db, readOnly: readOnly}, closeFunc, nil
+	return &Database{db: db, readOnly: readOnly, id: id, seq: seq, written: make(chan struct{})}, closeFunc, nil
 }
 
 // BootstrapReplica copies all files from srcDBPath to replicaDBPath.
 // This is useful to initialize replicas before opening them in read-only mode.
+//
+// Deprecated: the source must be closed and on the same machine, and files
+// already in replicaDBPath are kept. Load a snapshot of the leader with
+// LoadSnapshot instead.
 func BootstrapReplica(srcDBPath, replicaDBPath string) error {
 	// Ensure target dir exists
 	if err := os.MkdirAll(replicaDBPath, 0755); err != nil {
@@ -92,90 +127,165 @@ func copyFile(src, dst string) error {
 	return err
 }
 
-// prefixKey adds a prefix for replication keys
-func prefixKey(prefix, key []byte) []byte {
-	return append(prefix, key...)
+// SetKey writes a key to the main store and appends it to the replication log.
+// It fails with ErrLocked while a prepared transaction holds the key.
+func (d *Database) SetKey(key string, value []byte) error {
+	_, err := d.SetKeyIf(key, value, Condition{})
+	return err
 }
 
-// SetKey writes a key to the main store and the replication queue.
-func (d *Database) SetKey(key string, value []byte) error {
+// SetKeyIf is SetKey for a write that only happens if cond holds, and fails
+// with ErrConditionFailed otherwise. It returns the key's new version.
+func (d *Database) SetKeyIf(key string, value []byte, cond Condition) (uint64, error) {
+	return d.SetKeyExpiring(key, value, 0, cond)
+}
+
+// SetKeyWithTTL is SetKey for a key that expires after ttl.
+func (d *Database) SetKeyWithTTL(key string, value []byte, ttl time.Duration) error {
+	_, err := d.SetKeyExpiring(key, value, ExpiryAfter(ttl), Condition{})
+	return err
+}
+
+// SetKeyExpiring is SetKeyIf for a key that expires at expiresAt, in Unix
+// seconds, unless it is 0. The expiry is logged so replicas expire the key
+// at the same moment.
+func (d *Database) SetKeyExpiring(key string, value []byte, expiresAt uint64, cond Condition) (uint64, error) {
 	if d.readOnly {
-		return errors.New("read-only mode")
+		return 0, ErrReadOnly
 	}
 
-	return d.db.Update(func(txn *badger.Txn) error {
-		if err := txn.Set([]byte(key), value); err != nil {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var seq uint64
+	err := d.db.Update(func(txn *badger.Txn) error {
+		if err := checkUnlocked(txn, key); err != nil {
 			return err
 		}
-		return txn.Set(prefixKey(replicaPrefix, []byte(key)), value)
+		if err := cond.check(txn, key); err != nil {
+			return err
+		}
+		if err := setEntry(txn, []byte(key), value, expiresAt); err != nil {
+			return err
+		}
+		var err error
+		seq, err = d.appendLog(txn, d.seq, LogEntry{Op: OpSet, Key: key, Value: value, ExpiresAt: expiresAt})
+		return err
 	})
+	if err != nil {
+		return 0, err
+	}
+	d.publishLocked(seq)
+	return seq, nil
 }
 
-// SetKeyOnReplica writes a key directly to the main store (used by replicas).
-func (d *Database) SetKeyOnReplica(key string, value []byte) error {
+// SetKeysIfAbsent writes the pairs whose keys do not exist yet, logging each
+// write for replication, in a single transaction. It returns how many keys
+// were written. Existing keys are left untouched, so a copy of older data
+// never overwrites a newer write.
+func (d *Database) SetKeysIfAbsent(kvs []KV) (int, error) {
 	if d.readOnly {
-		return errors.New("read-only mode")
+		return 0, ErrReadOnly
 	}
-	return d.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(key), value)
-	})
-}
 
-// GetNextKeyForReplication fetches the first replication entry.
-func (d *Database) GetNextKeyForReplication() ([]byte, []byte, error) {
-	var k, v []byte
-	err := d.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var written int
+	seq := d.seq
+	err := d.db.Update(func(txn *badger.Txn) error {
+		written, seq = 0, d.seq
+		for _, kv := range kvs {
+			_, err := txn.Get([]byte(kv.Key))
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
 
-		for it.Seek(replicaPrefix); it.ValidForPrefix(replicaPrefix); it.Next() {
-			item := it.Item()
-			key := item.KeyCopy(nil)
-			err := item.Value(func(val []byte) error {
-				k = key[len(replicaPrefix):] // Strip prefix
-				v = append([]byte{}, val...)
-				return nil
-			})
-			return err
+			if err := setEntry(txn, []byte(kv.Key), kv.Value, kv.ExpiresAt); err != nil {
+				return err
+			}
+			if seq, err = d.appendLog(txn, seq, LogEntry{Op: OpSet, Key: kv.Key, Value: kv.Value, ExpiresAt: kv.ExpiresAt}); err != nil {
+				return err
+			}
+			written++
 		}
 		return nil
 	})
-
 	if err != nil {
-		return nil, nil, err
+		return 0, err
+	}
+	if seq != d.seq {
+		d.publishLocked(seq)
 	}
-	return k, v, nil
+	return written, nil
 }
 
-// DeleteReplicationKey deletes a key from the replication queue if the value matches.
-func (d *Database) DeleteReplicationKey(key, value []byte) error {
+// SetKeyOnReplica writes a key directly to the main store (used by replicas).
+func (d *Database) SetKeyOnReplica(key string, value []byte) error {
 	if d.readOnly {
-		return errors.New("read-only mode")
+		return ErrReadOnly
 	}
-
-	prefixedKey := prefixKey(replicaPrefix, key)
 	return d.db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get(prefixedKey)
-		if err != nil {
+		if err := txn.Set([]byte(key), value); err != nil {
 			return err
 		}
+		return recordUnloggedVersion(txn, LogEntry{Op: OpSet, Key: key})
+	})
+}
 
-		var actual []byte
-		err = item.Value(func(val []byte) error {
-			actual = val
-			return nil
-		})
-		if err != nil {
+// DeleteKey removes a key from the main store and records a tombstone in the
+// replication log so replicas delete it too. It fails with ErrLocked while a
+// prepared transaction holds the key.
+func (d *Database) DeleteKey(key string) error {
+	return d.DeleteKeyIf(key, Condition{})
+}
+
+// DeleteKeyIf is DeleteKey for a delete that only happens if cond holds, and
+// fails with ErrConditionFailed otherwise.
+func (d *Database) DeleteKeyIf(key string, cond Condition) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var seq uint64
+	err := d.db.Update(func(txn *badger.Txn) error {
+		if err := checkUnlocked(txn, key); err != nil {
 			return err
 		}
-
-		if !bytes.Equal(actual, value) {
-			return fmt.Errorf("value mismatch for key %s: expected %s, got %s", key, value, actual)
+		if err := cond.check(txn, key); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte(key)); err != nil {
+			return err
 		}
+		var err error
+		seq, err = d.appendLog(txn, d.seq, LogEntry{Op: OpDelete, Key: key})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	d.publishLocked(seq)
+	return nil
+}
 
-		// Proceed with deletion
-		return txn.Delete(prefixedKey)
+// DeleteKeyOnReplica removes a key from the main store without logging it
+// (used by replicas).
+func (d *Database) DeleteKeyOnReplica(key string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	return d.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return recordVersion(txn, LogEntry{Op: OpDelete, Key: key})
 	})
 }
 
@@ -184,6 +294,9 @@ func (d *Database) GetKey(key string) ([]byte, error) {
 	var result []byte
 	err := d.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(key))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		}
 		if err != nil {
 			return err
 		}
@@ -201,7 +314,7 @@ func (d *Database) GetKey(key string) ([]byte, error) {
 // DeleteExtraKeys removes keys that don't belong to this shard.
 func (d *Database) DeleteExtraKeys(isExtra func(string) bool) error {
 	if d.readOnly {
-		return errors.New("read-only mode")
+		return ErrReadOnly
 	}
 
 	var keysToDelete [][]byte
@@ -212,8 +325,8 @@ func (d *Database) DeleteExtraKeys(isExtra func(string) bool) error {
 
 		for it.Rewind(); it.Valid(); it.Next() {
 			key := it.Item().Key()
-			if bytes.HasPrefix(key, replicaPrefix) {
-				continue // skip replica entries
+			if isInternalKey(key) {
+				continue // skip replication log and metadata
 			}
 			kStr := string(key)
 			if isExtra(kStr) {
@@ -231,6 +344,9 @@ func (d *Database) DeleteExtraKeys(isExtra func(string) bool) error {
 			if err := txn.Delete(k); err != nil {
 				return err
 			}
+			if err := recordVersion(txn, LogEntry{Op: OpDelete, Key: string(k)}); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -1,6 +1,7 @@
 package db_test
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
@@ -29,28 +30,237 @@ func TestDatabase_SetGetKey(t *testing.T) {
 	require.Equal(t, value, val)
 }
 
-func TestDatabase_ReplicationQueue(t *testing.T) {
+func TestDatabase_ReplicationLog(t *testing.T) {
 	dir := createTempDir(t)
 	dbInstance, closeFunc, err := db.NewDatabase(dir, false)
 	require.NoError(t, err)
 	t.Cleanup(func() { require.NoError(t, closeFunc()) })
 
-	key := "replicated-key"
-	value := []byte("replicated-value")
-	require.NoError(t, dbInstance.SetKey(key, value))
+	// Two writes to the same key must both be kept, in order.
+	require.NoError(t, dbInstance.SetKey("b", []byte("1")))
+	require.NoError(t, dbInstance.SetKey("a", []byte("2")))
+	require.NoError(t, dbInstance.SetKey("b", []byte("3")))
+	require.Equal(t, uint64(3), dbInstance.LastSeq())
+
+	entries, err := dbInstance.ReplicationLog(0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []db.LogEntry{
+		{Seq: 1, Op: db.OpSet, Key: "b", Value: []byte("1")},
+		{Seq: 2, Op: db.OpSet, Key: "a", Value: []byte("2")},
+		{Seq: 3, Op: db.OpSet, Key: "b", Value: []byte("3")},
+	}, entries)
+
+	// Reading does not consume: each replica reads from its own offset.
+	entries, err = dbInstance.ReplicationLog(1, 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, uint64(2), entries[0].Seq)
+
+	entries, err = dbInstance.ReplicationLog(3, 0)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
 
-	k, v, err := dbInstance.GetNextKeyForReplication()
+func TestDatabase_ReplicationLogSurvivesRestart(t *testing.T) {
+	dir := createTempDir(t)
+	dbInstance, closeFunc, err := db.NewDatabase(dir, false)
 	require.NoError(t, err)
-	require.Equal(t, key, string(k))
-	require.Equal(t, value, v)
+	require.NoError(t, dbInstance.SetKey("k", []byte("v1")))
+	require.NoError(t, closeFunc())
+
+	dbInstance, closeFunc, err = db.NewDatabase(dir, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	require.Equal(t, uint64(1), dbInstance.LastSeq())
+	require.NoError(t, dbInstance.SetKey("k", []byte("v2")))
+	require.Equal(t, uint64(2), dbInstance.LastSeq())
+}
+
+func TestDatabase_ApplyLogEntry(t *testing.T) {
+	leader, leaderClose, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, leaderClose()) })
+
+	replicas := make([]*db.Database, 2)
+	for i := range replicas {
+		replica, replicaClose, err := db.NewDatabase(createTempDir(t), true)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, replicaClose()) })
+		replicas[i] = replica
+	}
+
+	require.NoError(t, leader.SetKey("k", []byte("first")))
+	require.NoError(t, leader.SetKey("k", []byte("second")))
+
+	entries, err := leader.ReplicationLog(0, 0)
+	require.NoError(t, err)
+
+	// The first replica catches up fully; the second only applies one entry.
+	for _, e := range entries {
+		require.NoError(t, replicas[0].ApplyLogEntry(e))
+	}
+	require.NoError(t, replicas[1].ApplyLogEntry(entries[0]))
 
-	require.NoError(t, dbInstance.DeleteReplicationKey([]byte(k), v))
+	val, err := replicas[0].GetKey("k")
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), val)
+
+	val, err = replicas[1].GetKey("k")
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), val)
+
+	applied, err := replicas[1].AppliedSeq()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), applied)
+
+	// Re-delivering an old entry must not roll the value back.
+	require.NoError(t, replicas[0].ApplyLogEntry(entries[0]))
+	val, err = replicas[0].GetKey("k")
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), val)
+}
+
+func TestDatabase_ApplyLogEntriesBatch(t *testing.T) {
+	leader, leaderClose, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, leaderClose()) })
+
+	replica, replicaClose, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, replicaClose()) })
+
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, leader.SetKey(k, []byte(k+"-val")))
+	}
+	entries, err := leader.ReplicationLog(0, 0)
+	require.NoError(t, err)
+
+	// An unknown operation makes the whole batch fail atomically.
+	bad := append(append([]db.LogEntry{}, entries...), db.LogEntry{Seq: 4, Op: "bogus", Key: "d"})
+	require.Error(t, replica.ApplyLogEntries(bad))
+	_, err = replica.GetKey("a")
+	require.Error(t, err)
+
+	require.NoError(t, replica.ApplyLogEntries(entries))
+	applied, err := replica.AppliedSeq()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), applied)
+	for _, k := range []string{"a", "b", "c"} {
+		val, err := replica.GetKey(k)
+		require.NoError(t, err)
+		require.Equal(t, []byte(k+"-val"), val)
+	}
+}
+
+func TestDatabase_TrimReplicationLog(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, dbInstance.SetKey("k", []byte{byte(i)}))
+	}
+	require.NoError(t, dbInstance.AckReplication("r1", 3))
+	require.NoError(t, dbInstance.AckReplication("r1", 2)) // never moves back
+
+	acks, err := dbInstance.ReplicationAcks()
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint64{"r1": 3}, acks)
+
+	require.NoError(t, dbInstance.TrimReplicationLog(3))
+
+	trimmed, err := dbInstance.TrimmedSeq()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), trimmed)
+
+	_, err = dbInstance.ReplicationLog(2, 0)
+	require.ErrorIs(t, err, db.ErrLogTruncated)
+
+	entries, err := dbInstance.ReplicationLog(3, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, uint64(4), entries[0].Seq)
+}
+
+func TestDatabase_TrimLongReplicationLog(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	// More entries than a single trim transaction deletes.
+	ops := make([]db.BatchOp, 2500)
+	for i := range ops {
+		ops[i] = db.BatchOp{Op: db.BatchPut, Key: fmt.Sprintf("k%d", i), Value: []byte("v")}
+	}
+	_, err = dbInstance.Batch(ops)
+	require.NoError(t, err)
+
+	require.NoError(t, dbInstance.TrimReplicationLog(2400))
+	trimmed, err := dbInstance.TrimmedSeq()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2400), trimmed)
+
+	entries, err := dbInstance.ReplicationLog(2400, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 100)
+	require.Equal(t, uint64(2401), entries[0].Seq)
+}
+
+func TestDatabase_DeleteKeyReplicatesTombstone(t *testing.T) {
+	leader, leaderClose, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, leaderClose()) })
+
+	replica, replicaClose, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, replicaClose()) })
+
+	require.NoError(t, leader.SetKey("gone", []byte("soon")))
+	require.NoError(t, leader.DeleteKey("gone"))
+
+	_, err = leader.GetKey("gone")
+	require.Error(t, err)
+
+	entries, err := leader.ReplicationLog(0, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, db.LogEntry{Seq: 2, Op: db.OpDelete, Key: "gone"}, entries[1])
+
+	require.NoError(t, replica.ApplyLogEntries(entries[:1]))
+	_, err = replica.GetKey("gone")
+	require.NoError(t, err)
+
+	require.NoError(t, replica.ApplyLogEntries(entries[1:]))
+	_, err = replica.GetKey("gone")
+	require.Error(t, err)
+}
+
+func TestDatabase_SetKeysIfAbsent(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	require.NoError(t, dbInstance.SetKey("b", []byte("newer")))
+
+	written, err := dbInstance.SetKeysIfAbsent([]db.KV{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("stale")},
+		{Key: "c", Value: []byte("3")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, written)
+
+	val, err := dbInstance.GetKey("b")
+	require.NoError(t, err)
+	require.Equal(t, []byte("newer"), val)
 
-	// Try to fetch again, should be nothing
-	k, v, err = dbInstance.GetNextKeyForReplication()
+	// Only the keys actually written are replicated.
+	entries, err := dbInstance.ReplicationLog(1, 0)
 	require.NoError(t, err)
-	require.Nil(t, k)
-	require.Nil(t, v)
+	require.Len(t, entries, 2)
+	require.Equal(t, "a", entries[0].Key)
+	require.Equal(t, "c", entries[1].Key)
 }
 
 func TestDatabase_SetKeyOnReplica(t *testing.T) {
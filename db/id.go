@@ -0,0 +1,37 @@
+package db
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// idKey holds the identifier of the database, see Database.ID.
+var idKey = []byte("meta:db-id")
+
+// ID returns the random identifier the database was given when it was
+// created. Snapshots and backups leave it behind, so a database that was
+// wiped, or restored from a backup, has an ID of its own.
+func (d *Database) ID() string {
+	return d.id
+}
+
+// loadID reads the identifier of db, giving it one if it has none yet.
+func loadID(db *badger.DB) (string, error) {
+	var id string
+	err := db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(idKey)
+		if err == nil {
+			val, err := item.ValueCopy(nil)
+			id = string(val)
+			return err
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		id = rand.Text()
+		return txn.Set(idKey, []byte(id))
+	})
+	return id, err
+}
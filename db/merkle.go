@@ -0,0 +1,253 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// MerkleDepth is the depth of the trees built by MerkleTree. Their leaves
+// split the client keys into 1<<MerkleDepth buckets by a hash of the key.
+const MerkleDepth = 10
+
+// MerkleBuckets is the number of leaves of a MerkleTree.
+const MerkleBuckets = 1 << MerkleDepth
+
+// MerkleBucket returns the bucket of key.
+func MerkleBucket(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % MerkleBuckets)
+}
+
+// MerkleTree is a hash tree over the client keys of a database, their
+// values and expiry. Two databases holding the same keys build the same
+// tree; where they differ, only the buckets below differing nodes do.
+type MerkleTree struct {
+	// Seq is the log position the tree reflects: the last written on a
+	// leader, the last applied on a replica.
+	Seq uint64 `json:"seq"`
+	// Nodes holds the hashes of the tree in heap order: the root at 1 and
+	// the children of node i at 2i and 2i+1, so bucket b is at
+	// MerkleBuckets+b. Nodes[0] is unused.
+	Nodes []uint64 `json:"nodes"`
+}
+
+// Diff returns the buckets whose hashes differ between t and other, found
+// by descending only into differing nodes.
+func (t *MerkleTree) Diff(other *MerkleTree) []int {
+	if len(t.Nodes) != 2*MerkleBuckets || len(other.Nodes) != 2*MerkleBuckets {
+		// Trees of another shape cannot be compared: check everything.
+		buckets := make([]int, MerkleBuckets)
+		for i := range buckets {
+			buckets[i] = i
+		}
+		return buckets
+	}
+
+	var buckets []int
+	var descend func(i int)
+	descend = func(i int) {
+		if t.Nodes[i] == other.Nodes[i] {
+			return
+		}
+		if i >= MerkleBuckets {
+			buckets = append(buckets, i-MerkleBuckets)
+			return
+		}
+		descend(2 * i)
+		descend(2*i + 1)
+	}
+	descend(1)
+	return buckets
+}
+
+// sum64 returns the first 8 bytes of the hash in h.
+func sum64(h hash.Hash) uint64 {
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// MerkleTree builds the Merkle tree of the database. It reads every client
+// key in a single transaction.
+func (d *Database) MerkleTree() (*MerkleTree, error) {
+	leaves := make([]hash.Hash, MerkleBuckets)
+	for i := range leaves {
+		leaves[i] = sha256.New()
+	}
+	tree := &MerkleTree{Nodes: make([]uint64, 2*MerkleBuckets)}
+
+	err := d.db.View(func(txn *badger.Txn) error {
+		seqKey := lastSeqKey
+		if d.readOnly {
+			seqKey = appliedSeqKey
+		}
+		var err error
+		if tree.Seq, err = readSeq(txn, seqKey); err != nil {
+			return err
+		}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		// Keys are visited in order, so each bucket hashes its keys in
+		// the same order on every node.
+		var expiry [8]byte
+		for it.Rewind(); skipInternalKeys(it); it.Next() {
+			item := it.Item()
+			h := leaves[MerkleBucket(string(item.Key()))]
+			binary.BigEndian.PutUint64(expiry[:], item.ExpiresAt())
+			err := item.Value(func(val []byte) error {
+				writeHashed(h, item.Key())
+				writeHashed(h, val)
+				h.Write(expiry[:])
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for b, h := range leaves {
+		tree.Nodes[MerkleBuckets+b] = sum64(h)
+	}
+	h := sha256.New()
+	var children [16]byte
+	for i := MerkleBuckets - 1; i >= 1; i-- {
+		binary.BigEndian.PutUint64(children[:8], tree.Nodes[2*i])
+		binary.BigEndian.PutUint64(children[8:], tree.Nodes[2*i+1])
+		h.Reset()
+		h.Write(children[:])
+		tree.Nodes[i] = sum64(h)
+	}
+	return tree, nil
+}
+
+// writeHashed writes b to h prefixed with its length, so adjacent fields
+// cannot run into each other.
+func writeHashed(h hash.Hash, b []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+// BucketEntries returns the client keys in the given Merkle buckets as set
+// entries, with the key's version as Seq.
+func (d *Database) BucketEntries(buckets []int) ([]LogEntry, error) {
+	want := bucketSet(buckets)
+	var entries []LogEntry
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); skipInternalKeys(it); it.Next() {
+			item := it.Item()
+			key := string(item.Key())
+			if !want[MerkleBucket(key)] {
+				continue
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			version, err := readSeq(txn, prefixed(versionPrefix, key))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, LogEntry{Seq: version, Op: OpSet, Key: key, Value: val, ExpiresAt: item.ExpiresAt()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RepairBuckets makes the given Merkle buckets of a replica hold exactly
+// the leader's entries, as returned by BucketEntries on the leader, and
+// returns how many keys it changed. Keys get the leader's versions. The
+// replica's applied position is left alone: replicating writes the leader
+// made after reading its entries moves the keys on to the same state.
+func (d *Database) RepairBuckets(buckets []int, leader []LogEntry) (int, error) {
+	want := bucketSet(buckets)
+	byKey := make(map[string]LogEntry, len(leader))
+	for _, e := range leader {
+		if want[MerkleBucket(e.Key)] {
+			byKey[e.Key] = e
+		}
+	}
+
+	var repaired int
+	err := d.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		var extra []string
+		for it.Rewind(); skipInternalKeys(it); it.Next() {
+			item := it.Item()
+			key := string(item.Key())
+			if !want[MerkleBucket(key)] {
+				continue
+			}
+			e, ok := byKey[key]
+			if !ok {
+				extra = append(extra, key)
+				continue
+			}
+			same := item.ExpiresAt() == e.ExpiresAt
+			if same {
+				if err := item.Value(func(val []byte) error {
+					same = bytes.Equal(val, e.Value)
+					return nil
+				}); err != nil {
+					it.Close()
+					return err
+				}
+			}
+			if same {
+				delete(byKey, key)
+			}
+		}
+		it.Close()
+
+		for _, key := range extra {
+			if err := txn.Delete([]byte(key)); err != nil {
+				return err
+			}
+			if err := recordVersion(txn, LogEntry{Op: OpDelete, Key: key}); err != nil {
+				return err
+			}
+			repaired++
+		}
+		for _, e := range byKey {
+			if err := setEntry(txn, []byte(e.Key), e.Value, e.ExpiresAt); err != nil {
+				return err
+			}
+			if err := recordVersion(txn, e); err != nil {
+				return err
+			}
+			repaired++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return repaired, nil
+}
+
+func bucketSet(buckets []int) map[int]bool {
+	set := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		set[b] = true
+	}
+	return set
+}
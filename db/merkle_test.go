@@ -0,0 +1,61 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_MerkleRepair(t *testing.T) {
+	leader, closeLeader, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeLeader()) })
+	replica, closeReplica, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeReplica()) })
+
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, leader.SetKey(key, []byte("v-"+key)))
+	}
+	entries, err := leader.ReplicationLog(0, 0)
+	require.NoError(t, err)
+
+	// The replica misses b, and holds a key the leader never had.
+	require.NoError(t, replica.ApplyLogEntries([]db.LogEntry{entries[0], entries[2]}))
+	require.NoError(t, replica.ApplyLogEntry(db.LogEntry{Seq: 4, Op: db.OpSet, Key: "ghost", Value: []byte("x")}))
+
+	leaderTree, err := leader.MerkleTree()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), leaderTree.Seq)
+	replicaTree, err := replica.MerkleTree()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), replicaTree.Seq)
+
+	buckets := leaderTree.Diff(replicaTree)
+	require.Contains(t, buckets, db.MerkleBucket("b"))
+	require.Contains(t, buckets, db.MerkleBucket("ghost"))
+	require.LessOrEqual(t, len(buckets), 2)
+
+	fromLeader, err := leader.BucketEntries(buckets)
+	require.NoError(t, err)
+	repaired, err := replica.RepairBuckets(buckets, fromLeader)
+	require.NoError(t, err)
+	require.Equal(t, 2, repaired)
+
+	replicaTree, err = replica.MerkleTree()
+	require.NoError(t, err)
+	require.Empty(t, leaderTree.Diff(replicaTree))
+
+	e, err := replica.GetEntry("b")
+	require.NoError(t, err)
+	require.Equal(t, "v-b", string(e.Value))
+	require.Equal(t, entries[1].Seq, e.Version)
+	_, err = replica.GetKey("ghost")
+	require.ErrorIs(t, err, db.ErrNotFound)
+
+	// Repairing buckets that already match changes nothing.
+	repaired, err = replica.RepairBuckets(buckets, fromLeader)
+	require.NoError(t, err)
+	require.Zero(t, repaired)
+}
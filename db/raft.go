@@ -0,0 +1,137 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// raftAppliedKey holds the index of the last Raft log entry applied to the
+// database.
+var raftAppliedKey = []byte("meta:raft-applied")
+
+// RaftApplied returns the index of the last Raft log entry applied to the
+// database, which a restarted Raft node resumes after.
+func (d *Database) RaftApplied() (uint64, error) {
+	var index uint64
+	err := d.db.View(func(txn *badger.Txn) error {
+		var err error
+		index, err = readSeq(txn, raftAppliedKey)
+		return err
+	})
+	return index, err
+}
+
+// applyRaft runs write in a transaction that also records index as the last
+// Raft log entry applied, so the entry is never applied twice. An entry the
+// state of the database rejects is applied without effect: its index is
+// recorded on its own and the rejection returned.
+func (d *Database) applyRaft(index uint64, write func(txn *badger.Txn) error) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	err := retryConflicts(func() error {
+		return d.db.Update(func(txn *badger.Txn) error {
+			if err := write(txn); err != nil {
+				return err
+			}
+			return txn.Set(raftAppliedKey, encodeSeq(index))
+		})
+	})
+	if IsRejected(err) {
+		if err := d.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(raftAppliedKey, encodeSeq(index))
+		}); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// IsRejected reports whether err refuses a write because of the state of
+// the database, which every node applying the same entries refuses alike.
+// Such writes are applied without effect.
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrConditionFailed) || errors.Is(err, ErrLocked)
+}
+
+// ApplyRaftSet writes key, without logging it, as the Raft log entry at
+// index if cond holds, and fails with ErrConditionFailed otherwise. The key
+// expires at expiresAt, in Unix seconds, unless it is 0.
+//
+// Writes applied from a Raft log take the index of their entry as the
+// key's version, so every member of the group agrees on it, whether or not
+// it restarted in between.
+func (d *Database) ApplyRaftSet(index uint64, key string, value []byte, expiresAt uint64, cond Condition) error {
+	return d.applyRaft(index, func(txn *badger.Txn) error {
+		if err := cond.check(txn, key); err != nil {
+			return err
+		}
+		if err := setEntry(txn, []byte(key), value, expiresAt); err != nil {
+			return err
+		}
+		return recordVersion(txn, LogEntry{Seq: index, Op: OpSet, Key: key, ExpiresAt: expiresAt})
+	})
+}
+
+// ApplyRaftDelete deletes key, without logging it, as the Raft log entry at
+// index if cond holds, and fails with ErrConditionFailed otherwise.
+func (d *Database) ApplyRaftDelete(index uint64, key string, cond Condition) error {
+	return d.applyRaft(index, func(txn *badger.Txn) error {
+		if err := cond.check(txn, key); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return recordVersion(txn, LogEntry{Op: OpDelete, Key: key})
+	})
+}
+
+// ApplyRaftSetIfAbsent writes the pairs whose keys do not exist yet,
+// without logging them, as the Raft log entry at index, like
+// SetKeysIfAbsent. It returns how many keys were written.
+func (d *Database) ApplyRaftSetIfAbsent(index uint64, kvs []KV) (int, error) {
+	var written int
+	err := d.applyRaft(index, func(txn *badger.Txn) error {
+		written = 0
+		for _, kv := range kvs {
+			_, err := txn.Get([]byte(kv.Key))
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			if err := setEntry(txn, []byte(kv.Key), kv.Value, kv.ExpiresAt); err != nil {
+				return err
+			}
+			if err := recordVersion(txn, LogEntry{Seq: index, Op: OpSet, Key: kv.Key, ExpiresAt: kv.ExpiresAt}); err != nil {
+				return err
+			}
+			written++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// ApplyRaftBatch runs ops, without logging the writes, as the Raft log entry
+// at index, like Batch: in order and in a single transaction, gets
+// observing the writes made earlier in the batch.
+func (d *Database) ApplyRaftBatch(index uint64, ops []BatchOp) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+	err := d.applyRaft(index, func(txn *badger.Txn) error {
+		return runBatch(txn, ops, results, func(e LogEntry) error {
+			e.Seq = index
+			return recordVersion(txn, e)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
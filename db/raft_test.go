@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_RaftAppliedSurvivesRestart(t *testing.T) {
+	dir := createTempDir(t)
+	dbInstance, closeFunc, err := db.NewDatabase(dir, false)
+	require.NoError(t, err)
+
+	applied, err := dbInstance.RaftApplied()
+	require.NoError(t, err)
+	require.Zero(t, applied)
+
+	require.NoError(t, dbInstance.ApplyRaftSet(1, "k", []byte("v1"), 0, db.Condition{}))
+	results, err := dbInstance.ApplyRaftBatch(2, []db.BatchOp{
+		{Op: db.BatchGet, Key: "b"},
+		{Op: db.BatchPut, Key: "b", Value: []byte("x")},
+		{Op: db.BatchGet, Key: "b"},
+	})
+	require.NoError(t, err)
+	// Gets observe the writes made earlier in the batch.
+	require.False(t, results[0].Found)
+	require.Equal(t, db.BatchResult{Found: true, Value: []byte("x")}, results[2])
+	// A rejected entry is applied too, without effect.
+	err = dbInstance.ApplyRaftSet(3, "k", []byte("v2"), 0, db.Condition{Absent: true})
+	require.ErrorIs(t, err, db.ErrConditionFailed)
+	require.NoError(t, closeFunc())
+
+	dbInstance, closeFunc, err = db.NewDatabase(dir, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	applied, err = dbInstance.RaftApplied()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), applied)
+
+	// Versions are the indexes of the entries that wrote the keys.
+	e, err := dbInstance.GetEntry("k")
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(e.Value))
+	require.Equal(t, uint64(1), e.Version)
+	e, err = dbInstance.GetEntry("b")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), e.Version)
+
+	require.NoError(t, dbInstance.ApplyRaftDelete(4, "k", db.Condition{}))
+	_, err = dbInstance.GetKey("k")
+	require.ErrorIs(t, err, db.ErrNotFound)
+	applied, err = dbInstance.RaftApplied()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), applied)
+}
+
+func TestDatabase_ApplyRaftSetIfAbsent(t *testing.T) {
+	dir := createTempDir(t)
+	dbInstance, closeFunc, err := db.NewDatabase(dir, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	require.NoError(t, dbInstance.ApplyRaftSet(1, "a", []byte("new"), 0, db.Condition{}))
+	written, err := dbInstance.ApplyRaftSetIfAbsent(2, []db.KV{
+		{Key: "a", Value: []byte("old")},
+		{Key: "b", Value: []byte("copied")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, written)
+
+	val, err := dbInstance.GetKey("a")
+	require.NoError(t, err)
+	require.Equal(t, "new", string(val))
+	e, err := dbInstance.GetEntry("b")
+	require.NoError(t, err)
+	require.Equal(t, "copied", string(e.Value))
+	require.Equal(t, uint64(2), e.Version)
+
+	applied, err := dbInstance.RaftApplied()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), applied)
+}
@@ -0,0 +1,383 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var (
+	replogPrefix     = []byte("replog:")
+	metaPrefix       = []byte("meta:")
+	lastSeqKey       = []byte("meta:last-seq")
+	appliedSeqKey    = []byte("meta:applied-seq")
+	trimmedSeqKey    = []byte("meta:trimmed-seq")
+	replicaAckPrefix = []byte("meta:ack:")
+)
+
+// ErrLogTruncated is returned when the requested log entries have already
+// been trimmed from the replication log.
+var ErrLogTruncated = errors.New("replication log truncated")
+
+// Operations recorded in the replication log.
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+// LogEntry is a single write in the replication log. Entries are numbered
+// by a monotonic sequence so replicas can apply them in write order.
+type LogEntry struct {
+	Seq   uint64 `json:"seq"`
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	// ExpiresAt is the expiry of a set key in Unix seconds, if it has one.
+	// Replicas expire the key at the same moment as the leader.
+	ExpiresAt uint64 `json:"expires_at,omitempty"`
+}
+
+// logKey returns the replication log key for seq. Big-endian encoding keeps
+// log entries sorted by sequence number.
+func logKey(seq uint64) []byte {
+	k := make([]byte, len(replogPrefix)+8)
+	copy(k, replogPrefix)
+	binary.BigEndian.PutUint64(k[len(replogPrefix):], seq)
+	return k
+}
+
+// ErrReservedKey is the error for a client key in the namespace of the
+// database's own bookkeeping, see IsReservedKey.
+var ErrReservedKey = errors.New(`keys starting with "meta:" or "replog:" are reserved`)
+
+// isInternalKey reports whether key belongs to the database's own bookkeeping
+// rather than to a client.
+func isInternalKey(key []byte) bool {
+	return bytes.HasPrefix(key, replogPrefix) || bytes.HasPrefix(key, metaPrefix)
+}
+
+// skipInternalKeys moves it past the internal keys it is positioned at,
+// seeking over each internal namespace at once rather than key by key. It
+// reports whether it is still valid.
+func skipInternalKeys(it *badger.Iterator) bool {
+	for it.Valid() {
+		key := it.Item().Key()
+		switch {
+		case bytes.HasPrefix(key, replogPrefix):
+			it.Seek(prefixEnd(replogPrefix))
+		case bytes.HasPrefix(key, metaPrefix):
+			it.Seek(prefixEnd(metaPrefix))
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// prefixEnd returns the first key after every key starting with prefix,
+// which must not end in 0xff.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	end[len(end)-1]++
+	return end
+}
+
+// IsReservedKey reports whether key is in the namespace of the database's
+// own bookkeeping. Clients must not read or write such keys: a write could
+// corrupt the replication log or the versions of other keys.
+func IsReservedKey(key string) bool {
+	return isInternalKey([]byte(key))
+}
+
+func encodeSeq(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+// readSeq reads a sequence number stored under key, or 0 if it is not set.
+func readSeq(txn *badger.Txn, key []byte) (uint64, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var seq uint64
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return fmt.Errorf("corrupt sequence number under %q", key)
+		}
+		seq = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return seq, err
+}
+
+// appendLog records e in the replication log under the sequence number
+// following prev and returns it. The sequence number becomes the written
+// key's version. Callers must hold d.mu and call publishLocked only after
+// the transaction commits.
+func (d *Database) appendLog(txn *badger.Txn, prev uint64, e LogEntry) (uint64, error) {
+	e.Seq = prev + 1
+	data, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	if err := txn.Set(logKey(e.Seq), data); err != nil {
+		return 0, err
+	}
+	if err := txn.Set(lastSeqKey, encodeSeq(e.Seq)); err != nil {
+		return 0, err
+	}
+	if err := recordVersion(txn, e); err != nil {
+		return 0, err
+	}
+	return e.Seq, nil
+}
+
+// publishLocked advances the last written sequence and wakes up waiters.
+// Callers must hold d.mu.
+func (d *Database) publishLocked(seq uint64) {
+	d.seq = seq
+	close(d.written)
+	d.written = make(chan struct{})
+}
+
+// WaitForWrite blocks until a write with a sequence number greater than
+// after has been committed, or ctx is done.
+func (d *Database) WaitForWrite(ctx context.Context, after uint64) error {
+	for {
+		d.mu.Lock()
+		seq, written := d.seq, d.written
+		d.mu.Unlock()
+
+		if seq > after {
+			return nil
+		}
+		select {
+		case <-written:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// LastSeq returns the sequence number of the most recent write.
+func (d *Database) LastSeq() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.seq
+}
+
+// ReplicationLog returns up to limit log entries with a sequence number
+// greater than after, in write order.
+func (d *Database) ReplicationLog(after uint64, limit int) ([]LogEntry, error) {
+	var entries []LogEntry
+	err := d.db.View(func(txn *badger.Txn) error {
+		trimmed, err := readSeq(txn, trimmedSeqKey)
+		if err != nil {
+			return err
+		}
+		if after < trimmed {
+			return fmt.Errorf("%w: entries up to %d are gone, requested after %d", ErrLogTruncated, trimmed, after)
+		}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(logKey(after + 1)); it.ValidForPrefix(replogPrefix); it.Next() {
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+			var e LogEntry
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &e)
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// AppliedSeq returns the sequence number of the last log entry applied on
+// this replica.
+func (d *Database) AppliedSeq() (uint64, error) {
+	var seq uint64
+	err := d.db.View(func(txn *badger.Txn) error {
+		var err error
+		seq, err = readSeq(txn, appliedSeqKey)
+		return err
+	})
+	return seq, err
+}
+
+// ApplyLogEntry applies a single leader log entry on a replica.
+func (d *Database) ApplyLogEntry(e LogEntry) error {
+	return d.ApplyLogEntries([]LogEntry{e})
+}
+
+// ApplyLogEntries applies a batch of leader log entries on a replica and
+// records the last sequence number as applied, all in one transaction.
+// Entries at or below the applied position are skipped, so re-delivery is
+// harmless. Keys get the same versions as on the leader.
+func (d *Database) ApplyLogEntries(entries []LogEntry) error {
+	return d.db.Update(func(txn *badger.Txn) error {
+		applied, err := readSeq(txn, appliedSeqKey)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if e.Seq <= applied {
+				continue
+			}
+			switch e.Op {
+			case OpSet:
+				if err := setEntry(txn, []byte(e.Key), e.Value, e.ExpiresAt); err != nil {
+					return err
+				}
+			case OpDelete:
+				if err := txn.Delete([]byte(e.Key)); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown log operation %q at seq %d", e.Op, e.Seq)
+			}
+			if err := recordVersion(txn, e); err != nil {
+				return err
+			}
+			applied = e.Seq
+		}
+		return txn.Set(appliedSeqKey, encodeSeq(applied))
+	})
+}
+
+// AckReplication records that replica has applied the log up to seq.
+// Acknowledgements never move backwards.
+func (d *Database) AckReplication(replica string, seq uint64) error {
+	key := append(append([]byte{}, replicaAckPrefix...), replica...)
+	return d.db.Update(func(txn *badger.Txn) error {
+		acked, err := readSeq(txn, key)
+		if err != nil {
+			return err
+		}
+		if seq <= acked {
+			return nil
+		}
+		return txn.Set(key, encodeSeq(seq))
+	})
+}
+
+// ReplicationAcks returns the acknowledged position of every replica that
+// has reported one.
+func (d *Database) ReplicationAcks() (map[string]uint64, error) {
+	acks := make(map[string]uint64)
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(replicaAckPrefix); it.ValidForPrefix(replicaAckPrefix); it.Next() {
+			item := it.Item()
+			replica := string(item.Key()[len(replicaAckPrefix):])
+			err := item.Value(func(val []byte) error {
+				acks[replica] = binary.BigEndian.Uint64(val)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acks, nil
+}
+
+// TrimmedSeq returns the position up to which the replication log has been
+// trimmed. The log holds the entries after it.
+func (d *Database) TrimmedSeq() (uint64, error) {
+	var trimmed uint64
+	err := d.db.View(func(txn *badger.Txn) error {
+		var err error
+		trimmed, err = readSeq(txn, trimmedSeqKey)
+		return err
+	})
+	return trimmed, err
+}
+
+// trimBatch bounds the log entries TrimReplicationLog deletes per
+// transaction, keeping each below Badger's transaction size limit.
+const trimBatch = 1000
+
+// TrimReplicationLog deletes log entries up to and including upTo, in
+// transactions of at most trimBatch entries. Replicas asking for trimmed
+// entries get ErrLogTruncated.
+func (d *Database) TrimReplicationLog(upTo uint64) error {
+	for {
+		done, err := d.trimLogBatch(upTo)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// trimLogBatch deletes the first trimBatch log entries up to and including
+// upTo, and reports whether that trimmed the log up to upTo.
+func (d *Database) trimLogBatch(upTo uint64) (bool, error) {
+	var done bool
+	err := d.db.Update(func(txn *badger.Txn) error {
+		done = true
+		trimmed, err := readSeq(txn, trimmedSeqKey)
+		if err != nil {
+			return err
+		}
+		if upTo <= trimmed {
+			return nil
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		var keys [][]byte
+		for it.Seek(logKey(trimmed + 1)); it.ValidForPrefix(replogPrefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if bytes.Compare(key, logKey(upTo)) > 0 {
+				break
+			}
+			if len(keys) == trimBatch {
+				done = false
+				break
+			}
+			keys = append(keys, key)
+		}
+		it.Close()
+
+		for _, k := range keys {
+			if err := txn.Delete(k); err != nil {
+				return err
+			}
+		}
+		to := upTo
+		if !done {
+			to = binary.BigEndian.Uint64(keys[len(keys)-1][len(replogPrefix):])
+		}
+		return txn.Set(trimmedSeqKey, encodeSeq(to))
+	})
+	return done, err
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// KV is a key-value pair returned by range queries.
+type KV struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	// ExpiresAt is the key's expiry in Unix seconds, if it has one.
+	ExpiresAt uint64 `json:"expires_at,omitempty"`
+}
+
+// Scan returns up to limit pairs with start <= key < end, in key order.
+// An empty end means no upper bound. next is the first key that was not
+// returned; pass it as start to fetch the following page. It is empty once
+// the range is exhausted.
+func (d *Database) Scan(start, end string, limit int) (kvs []KV, next string, err error) {
+	endKey := []byte(end)
+	return d.scan([]byte(start), func(key []byte) bool {
+		return end == "" || bytes.Compare(key, endKey) < 0
+	}, limit)
+}
+
+// ListPrefix returns up to limit pairs whose key starts with prefix, in key
+// order, beginning at cursor (or at prefix if cursor is empty). next is the
+// cursor for the following page, or empty once all keys have been listed.
+func (d *Database) ListPrefix(prefix, cursor string, limit int) (kvs []KV, next string, err error) {
+	start := prefix
+	if cursor > start {
+		start = cursor
+	}
+	p := []byte(prefix)
+	return d.scan([]byte(start), func(key []byte) bool {
+		return bytes.HasPrefix(key, p)
+	}, limit)
+}
+
+// scan iterates from start while inRange holds, seeking past internal keys.
+func (d *Database) scan(start []byte, inRange func([]byte) bool, limit int) ([]KV, string, error) {
+	var (
+		kvs  []KV
+		next string
+	)
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(start); skipInternalKeys(it); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			if !inRange(key) {
+				break
+			}
+			if limit > 0 && len(kvs) >= limit {
+				next = string(key)
+				break
+			}
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			kvs = append(kvs, KV{Key: string(key), Value: val, ExpiresAt: item.ExpiresAt()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return kvs, next, nil
+}
@@ -0,0 +1,80 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func keysOf(kvs []db.KV) []string {
+	keys := make([]string, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	return keys
+}
+
+func TestDatabase_Scan(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	// "meta:" and "replog:" keys written by SetKey must stay hidden.
+	for _, k := range []string{"a", "b", "c", "d", "n", "z"} {
+		require.NoError(t, dbInstance.SetKey(k, []byte("val-"+k)))
+	}
+
+	kvs, next, err := dbInstance.Scan("b", "n", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "c", "d"}, keysOf(kvs))
+	require.Equal(t, []byte("val-b"), kvs[0].Value)
+	require.Empty(t, next)
+
+	kvs, next, err = dbInstance.Scan("", "", 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, keysOf(kvs))
+	require.Equal(t, "d", next)
+
+	kvs, next, err = dbInstance.Scan(next, "", 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"d", "n", "z"}, keysOf(kvs))
+	require.Empty(t, next)
+
+	// Client keys right around the internal namespaces are still found.
+	for _, k := range []string{"meta", "meta;", "replog", "replog;x"} {
+		require.NoError(t, dbInstance.SetKey(k, []byte("val-"+k)))
+	}
+	kvs, next, err = dbInstance.Scan("m", "s", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"meta", "meta;", "n", "replog", "replog;x"}, keysOf(kvs))
+	require.Empty(t, next)
+}
+
+func TestDatabase_ListPrefix(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	for _, k := range []string{"user:1", "user:2", "user:3", "users", "video:1"} {
+		require.NoError(t, dbInstance.SetKey(k, []byte("x")))
+	}
+
+	var all []string
+	cursor := ""
+	for {
+		kvs, next, err := dbInstance.ListPrefix("user:", cursor, 2)
+		require.NoError(t, err)
+		all = append(all, keysOf(kvs)...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	require.Equal(t, []string{"user:1", "user:2", "user:3"}, all)
+
+	// Internal prefixes are never listed, even when asked for explicitly.
+	kvs, _, err := dbInstance.ListPrefix("meta:", "", 0)
+	require.NoError(t, err)
+	require.Empty(t, kvs)
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// snapshotPendingWrites bounds the batches LoadSnapshot writes concurrently.
+const snapshotPendingWrites = 16
+
+// inSnapshot reports whether key belongs in a snapshot: client keys, their
+// versions and the log position the snapshot reflects. The replication log
+// and the leader's own bookkeeping stay behind.
+func inSnapshot(key []byte) bool {
+	return !isInternalKey(key) ||
+		bytes.HasPrefix(key, versionPrefix) ||
+		bytes.Equal(key, versionSeqKey) ||
+		bytes.Equal(key, lastSeqKey)
+}
+
+// Snapshot writes a consistent snapshot of the database to w, in Badger's
+// backup format, and returns the log position it reflects. Writes are not
+// blocked while it runs.
+func (d *Database) Snapshot(w io.Writer) (uint64, error) {
+	info, err := d.Backup(w, 0)
+	return info.Seq, err
+}
+
+// LoadSnapshot replaces the contents of a replica with a snapshot written by
+// Snapshot and returns the log position it reflects, which becomes the
+// replica's applied position so replication resumes right after it. Nothing
+// else may use the database while the snapshot loads.
+func (d *Database) LoadSnapshot(r io.Reader) (uint64, error) {
+	if err := d.db.DropAll(); err != nil {
+		return 0, fmt.Errorf("dropping existing data: %w", err)
+	}
+	if err := d.db.Load(r, snapshotPendingWrites); err != nil {
+		return 0, fmt.Errorf("loading snapshot: %w", err)
+	}
+
+	var seq uint64
+	err := d.db.Update(func(txn *badger.Txn) error {
+		var err error
+		if seq, err = readSeq(txn, lastSeqKey); err != nil {
+			return err
+		}
+		// The position is the leader's; a replica logs nothing of its own.
+		if err := txn.Delete(lastSeqKey); err != nil {
+			return err
+		}
+		if err := txn.Set(idKey, []byte(d.id)); err != nil {
+			return err
+		}
+		return txn.Set(appliedSeqKey, encodeSeq(seq))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	d.seq = 0
+	d.mu.Unlock()
+	return seq, nil
+}
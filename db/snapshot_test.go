@@ -0,0 +1,58 @@
+package db_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_SnapshotAndLoad(t *testing.T) {
+	leader, closeLeader, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeLeader()) })
+
+	require.NoError(t, leader.SetKey("a", []byte("1")))
+	require.NoError(t, leader.SetKey("b", []byte("2")))
+	require.NoError(t, leader.DeleteKey("b"))
+	version, err := leader.SetKeyIf("c", []byte("3"), db.Condition{})
+	require.NoError(t, err)
+
+	var snapshot bytes.Buffer
+	seq, err := leader.Snapshot(&snapshot)
+	require.NoError(t, err)
+	require.Equal(t, leader.LastSeq(), seq)
+
+	// Whatever the replica held before is replaced.
+	replica, closeReplica, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeReplica()) })
+	require.NoError(t, replica.ApplyLogEntry(db.LogEntry{Seq: 1, Op: db.OpSet, Key: "stale", Value: []byte("x")}))
+
+	loaded, err := replica.LoadSnapshot(&snapshot)
+	require.NoError(t, err)
+	require.Equal(t, seq, loaded)
+
+	applied, err := replica.AppliedSeq()
+	require.NoError(t, err)
+	require.Equal(t, seq, applied)
+
+	val, err := replica.GetKey("a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), val)
+	_, err = replica.GetKey("b")
+	require.ErrorIs(t, err, db.ErrNotFound)
+	_, err = replica.GetKey("stale")
+	require.ErrorIs(t, err, db.ErrNotFound)
+
+	e, err := replica.GetEntry("c")
+	require.NoError(t, err)
+	require.Equal(t, version, e.Version)
+
+	// The leader's replication log is not part of the snapshot.
+	entries, err := replica.ReplicationLog(0, 0)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.Zero(t, replica.LastSeq())
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Entry is a key's value with its metadata.
+type Entry struct {
+	Value   []byte
+	Version uint64
+	// ExpiresAt is when the key expires, in Unix seconds, or 0 if it never
+	// does.
+	ExpiresAt uint64
+}
+
+// TTL returns the time left before the entry expires, or 0 if it never
+// does.
+func (e Entry) TTL() time.Duration {
+	if e.ExpiresAt == 0 {
+		return 0
+	}
+	return max(time.Until(time.Unix(int64(e.ExpiresAt), 0)), 0)
+}
+
+// ExpiryAfter returns the expiry, in Unix seconds, of a key written now with
+// the given time-to-live. Badger expires keys with a one second resolution.
+func ExpiryAfter(ttl time.Duration) uint64 {
+	return uint64(time.Now().Add(ttl).Unix())
+}
+
+// setEntry writes key with an optional expiry in Unix seconds.
+func setEntry(txn *badger.Txn, key, value []byte, expiresAt uint64) error {
+	e := badger.NewEntry(key, value)
+	e.ExpiresAt = expiresAt
+	return txn.SetEntry(e)
+}
+
+// GetEntry returns a key's value, version and expiry.
+func (d *Database) GetEntry(key string) (Entry, error) {
+	var e Entry
+	err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if e.Value, err = item.ValueCopy(nil); err != nil {
+			return err
+		}
+		e.ExpiresAt = item.ExpiresAt()
+		e.Version, err = readSeq(txn, prefixed(versionPrefix, key))
+		return err
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
@@ -0,0 +1,53 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_TTL(t *testing.T) {
+	leader, closeLeader, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeLeader()) })
+	replica, closeReplica, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeReplica()) })
+
+	require.NoError(t, leader.SetKeyWithTTL("session", []byte("s"), time.Hour))
+	require.NoError(t, leader.SetKey("forever", []byte("f")))
+	// A key whose expiry has already passed is gone.
+	past := uint64(time.Now().Add(-time.Minute).Unix())
+	_, err = leader.SetKeyExpiring("expired", []byte("e"), past, db.Condition{})
+	require.NoError(t, err)
+
+	e, err := leader.GetEntry("session")
+	require.NoError(t, err)
+	require.InDelta(t, time.Hour.Seconds(), e.TTL().Seconds(), 2)
+	e, err = leader.GetEntry("forever")
+	require.NoError(t, err)
+	require.Zero(t, e.ExpiresAt)
+	require.Zero(t, e.TTL())
+	_, err = leader.GetEntry("expired")
+	require.ErrorIs(t, err, db.ErrNotFound)
+
+	// Replicas expire keys at the same moment as the leader.
+	entries, err := leader.ReplicationLog(0, 0)
+	require.NoError(t, err)
+	require.NoError(t, replica.ApplyLogEntries(entries))
+	want, err := leader.GetEntry("session")
+	require.NoError(t, err)
+	got, err := replica.GetEntry("session")
+	require.NoError(t, err)
+	require.Equal(t, want.ExpiresAt, got.ExpiresAt)
+	_, err = replica.GetEntry("expired")
+	require.ErrorIs(t, err, db.ErrNotFound)
+
+	// Expired keys stay out of scans; the expiry of the others is reported.
+	kvs, _, err := leader.Scan("", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"forever", "session"}, keysOf(kvs))
+	require.Equal(t, want.ExpiresAt, kvs[1].ExpiresAt)
+}
@@ -0,0 +1,279 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var (
+	intentPrefix    = []byte("meta:txn-intent:")
+	lockPrefix      = []byte("meta:txn-lock:")
+	txnRecordPrefix = []byte("meta:txn-coord:")
+)
+
+// ErrLocked is returned for writes to a key held by a prepared transaction.
+var ErrLocked = errors.New("key locked by a transaction")
+
+// Intent is the part of a cross-shard transaction prepared on this shard:
+// the writes it will apply if the transaction commits. While an intent
+// exists, its keys are locked against other writes.
+type Intent struct {
+	ID string `json:"id"`
+	// Coordinator is the address of the node deciding the outcome.
+	Coordinator string    `json:"coordinator"`
+	Ops         []BatchOp `json:"ops"`
+	Prepared    time.Time `json:"prepared"`
+}
+
+// Transaction states recorded by a coordinator.
+const (
+	TxnPending   = "pending"
+	TxnCommitted = "committed"
+	TxnAborted   = "aborted"
+)
+
+// TxnRecord is a coordinator's durable record of a transaction. Once its
+// State is TxnCommitted or TxnAborted the outcome is decided, and the record
+// is kept until every participant has applied it.
+type TxnRecord struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	// Participants are the addresses of the shard leaders holding intents.
+	Participants []string  `json:"participants"`
+	Started      time.Time `json:"started"`
+}
+
+func prefixed(prefix []byte, s string) []byte {
+	return append(append([]byte{}, prefix...), s...)
+}
+
+// checkUnlocked returns ErrLocked if a prepared transaction holds key.
+func checkUnlocked(txn *badger.Txn, key string) error {
+	_, err := txn.Get(prefixed(lockPrefix, key))
+	if err == nil {
+		return fmt.Errorf("%w: %q", ErrLocked, key)
+	}
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// PrepareIntent durably records in and locks its keys. It fails with
+// ErrLocked if another transaction holds any of them. Preparing the same
+// transaction again is a no-op.
+func (d *Database) PrepareIntent(in Intent) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(prefixed(intentPrefix, in.ID)); err == nil {
+			return nil
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		for _, op := range in.Ops {
+			lock := prefixed(lockPrefix, op.Key)
+			item, err := txn.Get(lock)
+			if err == nil {
+				var holder []byte
+				if holder, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+				if string(holder) != in.ID {
+					return fmt.Errorf("%w: %q is held by %s", ErrLocked, op.Key, holder)
+				}
+				continue
+			}
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			if err := txn.Set(lock, []byte(in.ID)); err != nil {
+				return err
+			}
+		}
+		return txn.Set(prefixed(intentPrefix, in.ID), data)
+	})
+}
+
+// CommitIntent applies the writes of the intent with the given ID, logging
+// them for replication, and releases its locks, in a single transaction.
+// Committing an unknown or already committed intent is a no-op.
+func (d *Database) CommitIntent(id string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seq := d.seq
+	err := d.db.Update(func(txn *badger.Txn) error {
+		seq = d.seq
+		in, err := readIntent(txn, id)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, op := range in.Ops {
+			var e LogEntry
+			switch op.Op {
+			case BatchPut:
+				err = txn.Set([]byte(op.Key), op.Value)
+				e = LogEntry{Op: OpSet, Key: op.Key, Value: op.Value}
+			case BatchDelete:
+				err = txn.Delete([]byte(op.Key))
+				e = LogEntry{Op: OpDelete, Key: op.Key}
+			default:
+				err = fmt.Errorf("unknown transaction operation %q", op.Op)
+			}
+			if err != nil {
+				return err
+			}
+			if seq, err = d.appendLog(txn, seq, e); err != nil {
+				return err
+			}
+		}
+		return deleteIntent(txn, in)
+	})
+	if err != nil {
+		return err
+	}
+	if seq != d.seq {
+		d.publishLocked(seq)
+	}
+	return nil
+}
+
+// AbortIntent discards the intent with the given ID and releases its locks.
+// Aborting an unknown intent is a no-op.
+func (d *Database) AbortIntent(id string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	return d.db.Update(func(txn *badger.Txn) error {
+		in, err := readIntent(txn, id)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return deleteIntent(txn, in)
+	})
+}
+
+func readIntent(txn *badger.Txn, id string) (Intent, error) {
+	var in Intent
+	item, err := txn.Get(prefixed(intentPrefix, id))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return in, ErrNotFound
+	}
+	if err != nil {
+		return in, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &in)
+	})
+	return in, err
+}
+
+func deleteIntent(txn *badger.Txn, in Intent) error {
+	for _, op := range in.Ops {
+		if err := txn.Delete(prefixed(lockPrefix, op.Key)); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(prefixed(intentPrefix, in.ID))
+}
+
+// Intents returns every prepared intent.
+func (d *Database) Intents() ([]Intent, error) {
+	var intents []Intent
+	err := d.iterateJSON(intentPrefix, func() any {
+		intents = append(intents, Intent{})
+		return &intents[len(intents)-1]
+	})
+	return intents, err
+}
+
+// PutTxnRecord stores a coordinator's transaction record.
+func (d *Database) PutTxnRecord(rec TxnRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(prefixed(txnRecordPrefix, rec.ID), data)
+	})
+}
+
+// GetTxnRecord returns the transaction record with the given ID, or
+// ErrNotFound.
+func (d *Database) GetTxnRecord(id string) (TxnRecord, error) {
+	var rec TxnRecord
+	err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(prefixed(txnRecordPrefix, id))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &rec)
+		})
+	})
+	return rec, err
+}
+
+// DeleteTxnRecord forgets a finished transaction.
+func (d *Database) DeleteTxnRecord(id string) error {
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(prefixed(txnRecordPrefix, id))
+	})
+}
+
+// TxnRecords returns every transaction record.
+func (d *Database) TxnRecords() ([]TxnRecord, error) {
+	var recs []TxnRecord
+	err := d.iterateJSON(txnRecordPrefix, func() any {
+		recs = append(recs, TxnRecord{})
+		return &recs[len(recs)-1]
+	})
+	return recs, err
+}
+
+// iterateJSON decodes every value under prefix into the value returned by
+// next.
+func (d *Database) iterateJSON(prefix []byte, next func() any) error {
+	return d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, next())
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
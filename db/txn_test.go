@@ -0,0 +1,97 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Intents(t *testing.T) {
+	dir := createTempDir(t)
+	dbInstance, closeFunc, err := db.NewDatabase(dir, false)
+	require.NoError(t, err)
+
+	require.NoError(t, dbInstance.SetKey("b", []byte("old")))
+	require.NoError(t, dbInstance.PrepareIntent(db.Intent{
+		ID:          "t1",
+		Coordinator: "127.0.0.1:1",
+		Ops: []db.BatchOp{
+			{Op: db.BatchPut, Key: "a", Value: []byte("1")},
+			{Op: db.BatchDelete, Key: "b"},
+		},
+	}))
+	// Preparing again is harmless; another transaction cannot take the keys.
+	require.NoError(t, dbInstance.PrepareIntent(db.Intent{ID: "t1", Ops: []db.BatchOp{{Op: db.BatchPut, Key: "a"}}}))
+	require.ErrorIs(t, dbInstance.PrepareIntent(db.Intent{ID: "t2", Ops: []db.BatchOp{{Op: db.BatchPut, Key: "a"}}}), db.ErrLocked)
+
+	// Locked keys reject other writes and still read their old value.
+	require.ErrorIs(t, dbInstance.SetKey("a", []byte("x")), db.ErrLocked)
+	require.ErrorIs(t, dbInstance.DeleteKey("b"), db.ErrLocked)
+	_, err = dbInstance.Batch([]db.BatchOp{{Op: db.BatchPut, Key: "b", Value: []byte("x")}})
+	require.ErrorIs(t, err, db.ErrLocked)
+	_, err = dbInstance.GetKey("a")
+	require.ErrorIs(t, err, db.ErrNotFound)
+
+	// Intents survive a restart and stay out of scans.
+	require.NoError(t, closeFunc())
+	dbInstance, closeFunc, err = db.NewDatabase(dir, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	intents, err := dbInstance.Intents()
+	require.NoError(t, err)
+	require.Len(t, intents, 1)
+	require.Equal(t, "t1", intents[0].ID)
+	require.Equal(t, "127.0.0.1:1", intents[0].Coordinator)
+	kvs, _, err := dbInstance.Scan("", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, keysOf(kvs))
+
+	require.NoError(t, dbInstance.CommitIntent("t1"))
+	require.NoError(t, dbInstance.CommitIntent("t1"))
+	val, err := dbInstance.GetKey("a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), val)
+	_, err = dbInstance.GetKey("b")
+	require.ErrorIs(t, err, db.ErrNotFound)
+	require.Equal(t, uint64(3), dbInstance.LastSeq(), "committed writes are replicated")
+
+	intents, err = dbInstance.Intents()
+	require.NoError(t, err)
+	require.Empty(t, intents)
+	require.NoError(t, dbInstance.SetKey("a", []byte("2")), "commit released the lock")
+
+	// Aborting releases the locks without applying anything.
+	require.NoError(t, dbInstance.PrepareIntent(db.Intent{ID: "t3", Ops: []db.BatchOp{{Op: db.BatchPut, Key: "c", Value: []byte("3")}}}))
+	require.NoError(t, dbInstance.AbortIntent("t3"))
+	_, err = dbInstance.GetKey("c")
+	require.ErrorIs(t, err, db.ErrNotFound)
+	require.NoError(t, dbInstance.SetKey("c", []byte("4")))
+}
+
+func TestDatabase_TxnRecords(t *testing.T) {
+	dbInstance, closeFunc, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	_, err = dbInstance.GetTxnRecord("t1")
+	require.ErrorIs(t, err, db.ErrNotFound)
+
+	rec := db.TxnRecord{ID: "t1", State: db.TxnPending, Participants: []string{"a", "b"}}
+	require.NoError(t, dbInstance.PutTxnRecord(rec))
+	rec.State = db.TxnCommitted
+	require.NoError(t, dbInstance.PutTxnRecord(rec))
+
+	got, err := dbInstance.GetTxnRecord("t1")
+	require.NoError(t, err)
+	require.Equal(t, db.TxnCommitted, got.State)
+	recs, err := dbInstance.TxnRecords()
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+
+	require.NoError(t, dbInstance.DeleteTxnRecord("t1"))
+	recs, err = dbInstance.TxnRecords()
+	require.NoError(t, err)
+	require.Empty(t, recs)
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var (
+	versionPrefix = []byte("meta:ver:")
+	// versionSeqKey numbers the writes made outside both the replication
+	// log and the Raft log.
+	versionSeqKey = []byte("meta:version-seq")
+)
+
+// ErrConditionFailed is returned by conditional writes whose Condition does
+// not hold.
+var ErrConditionFailed = errors.New("condition failed")
+
+// Condition is a precondition on the current state of a key, checked in the
+// same transaction as a conditional write. The zero Condition always holds.
+//
+// Every write gives a key a new version, greater than any version the
+// database handed out before, so a key that is deleted and created again
+// never returns to an old version. Keys written before versions were
+// recorded are at version 0 until their next write.
+type Condition struct {
+	// Exists requires the key to exist.
+	Exists bool `json:"exists,omitempty"`
+	// Absent requires the key not to exist.
+	Absent bool `json:"absent,omitempty"`
+	// Version, if non-zero, requires the key to be at this version.
+	Version uint64 `json:"version,omitempty"`
+	// Value, if non-nil, requires the key to hold exactly this value.
+	Value []byte `json:"value"`
+}
+
+// Unchanged returns a Condition that holds while a key is still the entry
+// e: it exists at e's version or, if e has no version, holds e's value.
+func Unchanged(e Entry) Condition {
+	if e.Version == 0 {
+		return Condition{Exists: true, Value: e.Value}
+	}
+	return Condition{Exists: true, Version: e.Version}
+}
+
+// check returns ErrConditionFailed unless c holds for key in txn.
+func (c Condition) check(txn *badger.Txn, key string) error {
+	item, err := txn.Get([]byte(key))
+	exists := err == nil
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+
+	switch {
+	case c.Exists && !exists:
+		return fmt.Errorf("%w: %q does not exist", ErrConditionFailed, key)
+	case c.Absent && exists:
+		return fmt.Errorf("%w: %q already exists", ErrConditionFailed, key)
+	}
+	if c.Version != 0 {
+		version, err := readSeq(txn, prefixed(versionPrefix, key))
+		if err != nil {
+			return err
+		}
+		if !exists || version != c.Version {
+			return fmt.Errorf("%w: %q is at version %d, not %d", ErrConditionFailed, key, version, c.Version)
+		}
+	}
+	if c.Value != nil {
+		if !exists {
+			return fmt.Errorf("%w: %q does not exist", ErrConditionFailed, key)
+		}
+		err := item.Value(func(val []byte) error {
+			if !bytes.Equal(val, c.Value) {
+				return fmt.Errorf("%w: %q holds a different value", ErrConditionFailed, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordVersion updates the version of the key written by e to e.Seq, or
+// forgets it if e is a delete. The version expires with the key.
+func recordVersion(txn *badger.Txn, e LogEntry) error {
+	key := prefixed(versionPrefix, e.Key)
+	if e.Op == OpDelete {
+		return txn.Delete(key)
+	}
+	return setEntry(txn, key, encodeSeq(e.Seq), e.ExpiresAt)
+}
+
+// recordUnloggedVersion versions a write that is in neither the
+// replication log nor the Raft log. The version is taken from a counter of
+// the node's own, so other nodes do not know it.
+func recordUnloggedVersion(txn *badger.Txn, e LogEntry) error {
+	seq, err := readSeq(txn, versionSeqKey)
+	if err != nil {
+		return err
+	}
+	e.Seq = seq + 1
+	if err := txn.Set(versionSeqKey, encodeSeq(e.Seq)); err != nil {
+		return err
+	}
+	return recordVersion(txn, e)
+}
@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_ConditionalWrites(t *testing.T) {
+	dir := createTempDir(t)
+	dbInstance, closeFunc, err := db.NewDatabase(dir, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	// Create-only.
+	v1, err := dbInstance.SetKeyIf("k", []byte("a"), db.Condition{Absent: true})
+	require.NoError(t, err)
+	_, err = dbInstance.SetKeyIf("k", []byte("b"), db.Condition{Absent: true})
+	require.ErrorIs(t, err, db.ErrConditionFailed)
+
+	e, err := dbInstance.GetEntry("k")
+	require.NoError(t, err)
+	require.Equal(t, "a", string(e.Value))
+	require.Equal(t, v1, e.Version)
+
+	// Compare-and-swap on the version: only one of two writers wins.
+	v2, err := dbInstance.SetKeyIf("k", []byte("b"), db.Condition{Version: v1})
+	require.NoError(t, err)
+	require.Greater(t, v2, v1)
+	_, err = dbInstance.SetKeyIf("k", []byte("c"), db.Condition{Version: v1})
+	require.ErrorIs(t, err, db.ErrConditionFailed)
+
+	// Compare-value.
+	_, err = dbInstance.SetKeyIf("k", []byte("c"), db.Condition{Value: []byte("a")})
+	require.ErrorIs(t, err, db.ErrConditionFailed)
+	_, err = dbInstance.SetKeyIf("k", []byte("c"), db.Condition{Value: []byte("b")})
+	require.NoError(t, err)
+
+	// A deleted and recreated key does not return to an old version.
+	require.ErrorIs(t, dbInstance.DeleteKeyIf("k", db.Condition{Version: v2}), db.ErrConditionFailed)
+	require.NoError(t, dbInstance.DeleteKeyIf("k", db.Condition{Exists: true}))
+	require.ErrorIs(t, dbInstance.DeleteKeyIf("k", db.Condition{Exists: true}), db.ErrConditionFailed)
+	v3, err := dbInstance.SetKeyIf("k", []byte("d"), db.Condition{Absent: true})
+	require.NoError(t, err)
+	require.Greater(t, v3, v2)
+
+	// Unchanged holds until the key is written again.
+	e, err = dbInstance.GetEntry("k")
+	require.NoError(t, err)
+	unchanged := db.Unchanged(e)
+	_, err = dbInstance.SetKeyIf("k", []byte("e"), unchanged)
+	require.NoError(t, err)
+	require.ErrorIs(t, dbInstance.DeleteKeyIf("k", unchanged), db.ErrConditionFailed)
+
+	// Version records stay out of scans.
+	kvs, _, err := dbInstance.Scan("", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"k"}, keysOf(kvs))
+}
+
+func TestDatabase_ReplicaVersionsMatchLeader(t *testing.T) {
+	leader, closeLeader, err := db.NewDatabase(createTempDir(t), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeLeader()) })
+	replica, closeReplica, err := db.NewDatabase(createTempDir(t), true)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeReplica()) })
+
+	require.NoError(t, leader.SetKey("a", []byte("1")))
+	require.NoError(t, leader.SetKey("b", []byte("2")))
+	require.NoError(t, leader.SetKey("a", []byte("3")))
+	entries, err := leader.ReplicationLog(0, 0)
+	require.NoError(t, err)
+	require.NoError(t, replica.ApplyLogEntries(entries))
+
+	for _, key := range []string{"a", "b"} {
+		want, err := leader.GetEntry(key)
+		require.NoError(t, err)
+		got, err := replica.GetEntry(key)
+		require.NoError(t, err)
+		require.Equal(t, want.Version, got.Version, key)
+	}
+}
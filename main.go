@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Sagor0078/distribKV/balancer"
 	"github.com/Sagor0078/distribKV/config"
 	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/raft"
 	"github.com/Sagor0078/distribKV/replication"
 	"github.com/Sagor0078/distribKV/web"
 )
 
 var (
-	dbLocation = flag.String("db-location", "", "The path to the bolt db database")
-	httpAddr   = flag.String("http-addr", "127.0.0.1:8080", "HTTP host and port")
-	configFile = flag.String("config-file", "sharding.toml", "Config file for static sharding")
-	shard      = flag.String("shard", "", "The name of the shard for the data")
-	replica    = flag.Bool("replica", false, "Whether or not run as a read-only replica")
+	dbLocation  = flag.String("db-location", "", "The path to the bolt db database")
+	httpAddr    = flag.String("http-addr", "127.0.0.1:8080", "HTTP host and port")
+	configFile  = flag.String("config-file", "sharding.toml", "Config file for static sharding")
+	shard       = flag.String("shard", "", "The name of the shard for the data")
+	replica     = flag.Bool("replica", false, "Whether or not run as a read-only replica")
+	antiEntropy = flag.Duration("anti-entropy-interval", time.Minute, "How often a replica compares its data with the leader and repairs differences, 0 to disable")
+	bootstrap   = flag.Bool("bootstrap", false, "Replace a replica's data with a snapshot from the shard leader before replicating")
+	useRaft     = flag.Bool("raft", false, "Replicate writes within the shard through Raft instead of leader polling")
+	redirect    = flag.Bool("redirect", false, "Answer requests for other shards with a 307 redirect instead of proxying them")
+	fwdTimeout  = flag.Duration("forward-timeout", 10*time.Second, "Timeout for requests forwarded to other nodes")
+	lbStrategy  = flag.String("lb-strategy", "", "Spread reads for other shards over their replicas: round-robin, least-outstanding or latency")
+	logRetain   = flag.Uint64("log-retention", 10000, "How many of the latest replication log entries a leader keeps for watches to resume from")
 )
 
 func parseFlags() {
@@ -34,45 +48,146 @@ func parseFlags() {
 func main() {
 	parseFlags()
 
-	// Parse the sharding config file
-	c, err := config.ParseFile(*configFile)
+	// Parse the sharding config file and the shards in it
+	shards, err := config.LoadShards(*configFile, *shard)
 	if err != nil {
 		log.Fatalf("Error parsing config %q: %v", *configFile, err)
 	}
 
-	// Parse shards from the config
-	shards, err := config.ParseShards(c.Shards, *shard)
-	if err != nil {
-		log.Fatalf("Error parsing shards config: %v", err)
-	}
-
-	log.Printf("Shard count is %d, current shard: %d", shards.Count, shards.CurIdx)
+	log.Printf("Shard count is %d, current shard: %d, topology epoch: %d", shards.Count, shards.CurIdx, shards.Epoch)
 
-	// Create the database (either leader or replica)
-	dbInstance, close, err := db.NewDatabase(*dbLocation, *replica)
+	// Create the database (either leader or replica). Under Raft every
+	// member applies committed writes, so none of them is read-only.
+	dbInstance, close, err := db.NewDatabase(*dbLocation, *replica && !*useRaft)
 	if err != nil {
 		log.Fatalf("Error creating %q: %v", *dbLocation, err)
 	}
 	defer close()
 
-	// If running as a replica, start replication client loop
-	if *replica {
+	opts := []web.Option{
+		web.WithTopologyFile(*configFile),
+		web.WithForwardTimeout(*fwdTimeout),
+		web.WithLogRetention(*logRetain),
+	}
+	if *redirect {
+		opts = append(opts, web.WithRedirects())
+	}
+	if *lbStrategy != "" {
+		strategy, err := balancer.ParseStrategy(*lbStrategy)
+		if err != nil {
+			log.Fatalf("Error parsing lb-strategy: %v", err)
+		}
+		b := balancer.New(strategy, shards)
+		go b.Run(context.Background())
+		opts = append(opts, web.WithBalancer(b))
+	}
+
+	if *useRaft {
+		storage, closeStorage, err := raft.NewBadgerStorage(*dbLocation + ".raft")
+		if err != nil {
+			log.Fatalf("Error opening raft log: %v", err)
+		}
+		defer closeStorage()
+
+		applied, err := dbInstance.RaftApplied()
+		if err != nil {
+			log.Fatalf("Error reading applied raft index: %v", err)
+		}
+		peers := append([]string{shards.Addrs[shards.CurIdx]}, shards.GetReplicas(shards.CurIdx)...)
+		node, err := raft.NewNode(raft.Config{
+			ID:      *httpAddr,
+			Peers:   peers,
+			Storage: storage,
+			Apply:   replication.StateMachine(dbInstance),
+			Applied: applied,
+		})
+		if err != nil {
+			log.Fatalf("Error creating raft node: %v", err)
+		}
+		node.Start()
+		defer node.Stop()
+
+		http.HandleFunc("/raft/vote", node.VoteHandler)
+		http.HandleFunc("/raft/append", node.AppendHandler)
+		http.HandleFunc("/raft/status", node.StatusHandler)
+		opts = append(opts, web.WithRaft(node))
+	} else if *replica {
+		// If running as a replica, start replication client loop
 		leaderAddr, ok := shards.Addrs[shards.CurIdx]
 		if !ok {
 			log.Fatalf("Could not find address for leader for shard %d", shards.CurIdx)
 		}
-		go replication.ClientLoop(dbInstance, leaderAddr)
+		if *bootstrap {
+			seq, err := replication.Bootstrap(context.Background(), dbInstance, leaderAddr)
+			if err != nil {
+				log.Fatalf("Error bootstrapping from %s: %v", leaderAddr, err)
+			}
+			log.Printf("Bootstrapped from %s at log position %d", leaderAddr, seq)
+		}
+		client, err := replication.NewClient(dbInstance, leaderAddr, *httpAddr)
+		if err != nil {
+			log.Fatalf("Error starting replication: %v", err)
+		}
+		go client.Run(context.Background())
+		if *antiEntropy > 0 {
+			go client.RunAntiEntropy(context.Background(), *antiEntropy)
+		}
+		opts = append(opts, web.WithReplica(client))
 	}
 
 	// Initialize the server
-	srv := web.NewServer(dbInstance, shards)
+	srv := web.NewServer(dbInstance, shards, opts...)
+
+	// Reload the topology from the config file on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := srv.ReloadTopology(""); err != nil {
+				log.Printf("Error reloading config %q: %v", *configFile, err)
+			}
+		}
+	}()
+
+	// Finish transactions left in doubt by a crash, and keep the
+	// replication log bounded
+	if !*useRaft && !*replica {
+		go srv.RunTxnRecovery(context.Background())
+		go srv.RunLogTrim(context.Background())
+	}
 
 	// Register HTTP handlers
+	http.HandleFunc("/v1/keys/{key...}", srv.KeyHandler)
+	http.HandleFunc("/v1/scan", srv.ScanHandler)
+	http.HandleFunc("/v1/list", srv.ListHandler)
+	http.HandleFunc("/v1/batch", srv.BatchHandler)
+	http.HandleFunc("/v1/txn", srv.TxnHandler)
+	http.HandleFunc("/v1/incr/{key...}", srv.IncrementHandler)
+	http.HandleFunc("/v1/watch", srv.WatchHandler)
+
+	// Original query-string endpoints, kept for compatibility
 	http.HandleFunc("/get", srv.GetHandler)
 	http.HandleFunc("/set", srv.SetHandler)
+	http.HandleFunc("DELETE /key", srv.DeleteHandler)
+	http.HandleFunc("/delete", srv.DeleteHandler)
 	http.HandleFunc("/purge", srv.DeleteExtraKeysHandler)
-	http.HandleFunc("/next-replication-key", srv.GetNextKeyForReplication)
-	http.HandleFunc("/delete-replication-key", srv.DeleteReplicationKey)
+
+	// Cluster administration and node-to-node endpoints
+	http.HandleFunc("/v1/admin/topology", srv.TopologyHandler)
+	http.HandleFunc("/v1/admin/reshard", srv.ReshardHandler)
+	http.HandleFunc("/v1/admin/replication", srv.ReplicationStatusHandler)
+	http.HandleFunc("/v1/cluster/status", srv.ClusterStatusHandler)
+	http.HandleFunc("/v1/admin/backup", srv.BackupHandler)
+	http.HandleFunc("/v1/admin/anti-entropy", srv.AntiEntropyHandler)
+	http.HandleFunc("/v1/internal/migrate", srv.MigrateHandler)
+	http.HandleFunc("/v1/internal/snapshot", srv.SnapshotHandler)
+	http.HandleFunc("/v1/internal/merkle", srv.MerkleHandler)
+	http.HandleFunc("/v1/internal/merkle/entries", srv.MerkleEntriesHandler)
+	http.HandleFunc("/v1/internal/txn/prepare", srv.TxnPrepareHandler)
+	http.HandleFunc("/v1/internal/txn/decide", srv.TxnDecideHandler)
+	http.HandleFunc("/v1/internal/txn/status", srv.TxnStatusHandler)
+	http.HandleFunc("/replication-stream", srv.ReplicationStreamHandler)
+	http.HandleFunc("/replication-ack", srv.ReplicationAckHandler)
 
 	// Add health check endpoint
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
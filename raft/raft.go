@@ -0,0 +1,929 @@
+// Package raft implements leader election and log replication for a shard group.
+//
+// Every member of a shard (the leader address and its replicas in sharding.toml)
+// runs a Node. Commands proposed on the leader are appended to the Raft log,
+// replicated to the other members and handed to the Apply callback once a
+// majority has stored them. If the leader stops responding, the remaining
+// members elect a new one.
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// State is the role a node currently plays in its group.
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+var (
+	// ErrStopped is returned when the node has been stopped.
+	ErrStopped = errors.New("raft: node stopped")
+	// ErrLostLeadership is returned when a proposal was overwritten by a new leader.
+	ErrLostLeadership = errors.New("raft: leadership lost before entry was committed")
+	// ErrNoQuorum is returned by ReadIndex when a majority of the group did
+	// not confirm this node as the leader in time.
+	ErrNoQuorum = errors.New("raft: leadership not confirmed by a majority")
+)
+
+// NotLeaderError is returned by Propose on nodes that are not the leader.
+type NotLeaderError struct {
+	// Leader is the address of the known leader, or empty if unknown.
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "raft: not the leader, leader unknown"
+	}
+	return fmt.Sprintf("raft: not the leader, leader is %s", e.Leader)
+}
+
+// RejectedError wraps an error Apply returns for an entry the state machine
+// applied without effect, as every member of the group does alike.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Reject marks err, returned by Apply, as the entry's rejection rather than
+// a failure to apply it.
+func Reject(err error) error {
+	return &RejectedError{Err: err}
+}
+
+// Entry is a single record in the Raft log.
+type Entry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	// Data is the proposed command. Empty for the no-op a new leader appends.
+	Data []byte `json:"data,omitempty"`
+}
+
+// Config configures a Node.
+type Config struct {
+	// ID is the address this node is reachable at. It must appear in Peers.
+	ID string
+	// Peers lists the addresses of every member of the group, including ID.
+	Peers []string
+	// Storage persists the term, vote and log.
+	Storage Storage
+	// Transport delivers RPCs. Defaults to an HTTPTransport.
+	Transport Transport
+	// Apply is called, in log order, with the index and data of every
+	// committed entry. The state machine should store the index together
+	// with the entry's effects and pass it back as Applied after a restart.
+	// On the leader, its result is returned by the Propose call that
+	// proposed the entry. An entry rejected with an error made by Reject
+	// counts as applied, its error being the result; any other error means
+	// the entry could not be applied, and it is retried until it is.
+	Apply func(index uint64, data []byte) (any, error)
+	// Applied is the index of the last entry the state machine applied
+	// before the node was started. Entries up to it are not applied again.
+	Applied uint64
+	// ElectionTimeout is the minimum time without a heartbeat before a
+	// follower starts an election. The actual timeout is randomized up to 2x.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is how often the leader contacts its followers.
+	HeartbeatInterval time.Duration
+}
+
+const maxAppendEntries = 256
+
+// applyRetryInterval is how long to wait before applying an entry again
+// after Apply failed.
+const applyRetryInterval = 100 * time.Millisecond
+
+type waiter struct {
+	term uint64
+	done chan applied
+}
+
+// applied is the outcome of applying a proposed entry.
+type applied struct {
+	result any
+	err    error
+}
+
+// Node is a single member of a Raft group.
+type Node struct {
+	cfg Config
+
+	mu          sync.Mutex
+	state       State
+	term        uint64
+	votedFor    string
+	leader      string
+	commitIndex uint64
+	lastApplied uint64
+	// termStart is the index of the no-op the leader appended when it was
+	// elected; nothing it reads is current before that is committed.
+	termStart uint64
+	// advanced is closed and replaced whenever lastApplied advances.
+	advanced chan struct{}
+	deadline    time.Time
+	lastBeat    time.Time
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	inflight    map[string]bool
+	waiters     map[uint64]waiter
+	stopped     bool
+
+	applyCh chan struct{}
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewNode creates a node from cfg and restores its persisted state.
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("raft: missing node ID")
+	}
+	if cfg.Storage == nil {
+		return nil, errors.New("raft: missing storage")
+	}
+	if cfg.Apply == nil {
+		return nil, errors.New("raft: missing apply function")
+	}
+
+	found := false
+	for _, p := range cfg.Peers {
+		if p == cfg.ID {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("raft: node %q is not in peers %v", cfg.ID, cfg.Peers)
+	}
+
+	if cfg.Transport == nil {
+		cfg.Transport = NewHTTPTransport(time.Second)
+	}
+	if cfg.ElectionTimeout == 0 {
+		cfg.ElectionTimeout = 500 * time.Millisecond
+	}
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = cfg.ElectionTimeout / 5
+	}
+
+	term, votedFor, err := cfg.Storage.HardState()
+	if err != nil {
+		return nil, fmt.Errorf("raft: reading hard state: %w", err)
+	}
+	last, err := cfg.Storage.LastIndex()
+	if err != nil {
+		return nil, fmt.Errorf("raft: reading log: %w", err)
+	}
+	if cfg.Applied > last {
+		return nil, fmt.Errorf("raft: applied index %d is beyond the last log entry %d", cfg.Applied, last)
+	}
+
+	n := &Node{
+		cfg:      cfg,
+		state:    Follower,
+		term:     term,
+		votedFor: votedFor,
+		// Applied entries were committed before the restart.
+		commitIndex: cfg.Applied,
+		lastApplied: cfg.Applied,
+		inflight:    make(map[string]bool),
+		waiters:     make(map[uint64]waiter),
+		advanced:    make(chan struct{}),
+		applyCh:     make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	n.resetDeadline()
+	return n, nil
+}
+
+// Start launches the election timer, heartbeats and the apply loop.
+func (n *Node) Start() {
+	n.wg.Add(2)
+	go n.tickLoop()
+	go n.applyLoop()
+}
+
+// Stop halts the node. Pending proposals fail with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	n.state = Follower
+	for idx, w := range n.waiters {
+		w.done <- applied{err: ErrStopped}
+		delete(n.waiters, idx)
+	}
+	n.mu.Unlock()
+
+	close(n.stopCh)
+	n.wg.Wait()
+}
+
+// Status is a snapshot of the node's view of the group.
+type Status struct {
+	ID          string `json:"id"`
+	State       string `json:"state"`
+	Term        uint64 `json:"term"`
+	Leader      string `json:"leader"`
+	CommitIndex uint64 `json:"commit_index"`
+	LastApplied uint64 `json:"last_applied"`
+	LastIndex   uint64 `json:"last_index"`
+}
+
+// Status returns the current role, term and log positions.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	last, _ := n.cfg.Storage.LastIndex()
+	return Status{
+		ID:          n.cfg.ID,
+		State:       n.state.String(),
+		Term:        n.term,
+		Leader:      n.leader,
+		CommitIndex: n.commitIndex,
+		LastApplied: n.lastApplied,
+		LastIndex:   last,
+	}
+}
+
+// IsLeader reports whether this node currently believes it is the leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == Leader
+}
+
+// Leader returns the address of the known leader, or empty if unknown.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Propose appends data to the log and blocks until it has been committed by
+// a majority and applied locally, or until ctx is done. It returns what
+// Apply returned for the entry.
+func (n *Node) Propose(ctx context.Context, data []byte) (any, error) {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.state != Leader {
+		leader := n.leader
+		n.mu.Unlock()
+		return nil, &NotLeaderError{Leader: leader}
+	}
+
+	index, err := n.appendLocked(data)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	done := make(chan applied, 1)
+	n.waiters[index] = waiter{term: n.term, done: done}
+	n.advanceCommitLocked()
+	n.mu.Unlock()
+
+	n.broadcast()
+
+	select {
+	case a := <-done:
+		return a.result, a.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// ReadIndex makes sure a read served by this node observes every write
+// committed before ReadIndex was called. It confirms that this node is still
+// the leader by having a majority of the group acknowledge a heartbeat in
+// its term, then waits until the state machine has applied every entry
+// committed when it was called. A node that is not, or no longer, the
+// leader returns a NotLeaderError.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return ErrStopped
+	}
+	if n.state != Leader {
+		leader := n.leader
+		n.mu.Unlock()
+		return &NotLeaderError{Leader: leader}
+	}
+	term := n.term
+	index := max(n.commitIndex, n.termStart)
+
+	// The heartbeats carry no entries, so they leave replication alone.
+	reqs := make(map[string]*AppendRequest)
+	for _, p := range n.cfg.Peers {
+		if p == n.cfg.ID {
+			continue
+		}
+		match := n.matchIndex[p]
+		prevTerm, err := n.termAtLocked(match)
+		if err != nil {
+			n.mu.Unlock()
+			return err
+		}
+		reqs[p] = &AppendRequest{
+			Term:         term,
+			LeaderID:     n.cfg.ID,
+			PrevLogIndex: match,
+			PrevLogTerm:  prevTerm,
+			LeaderCommit: min(n.commitIndex, match),
+		}
+	}
+	n.mu.Unlock()
+
+	if err := n.confirmLeadership(ctx, term, reqs); err != nil {
+		return err
+	}
+	return n.waitApplied(ctx, index)
+}
+
+// confirmLeadership sends reqs, heartbeats in term, and returns once a
+// majority of the group, this node included, has accepted it as the leader
+// of term.
+func (n *Node) confirmLeadership(ctx context.Context, term uint64, reqs map[string]*AppendRequest) error {
+	acks := 1
+	if acks >= n.quorum() {
+		return nil
+	}
+
+	beatCtx, cancel := context.WithTimeout(ctx, n.cfg.ElectionTimeout)
+	defer cancel()
+	results := make(chan bool, len(reqs))
+	for peer, req := range reqs {
+		go func() {
+			resp, err := n.cfg.Transport.AppendEntries(beatCtx, peer, req)
+			if err != nil {
+				results <- false
+				return
+			}
+			if resp.Term > term {
+				n.mu.Lock()
+				if resp.Term > n.term {
+					n.becomeFollowerLocked(resp.Term, "")
+				}
+				n.mu.Unlock()
+			}
+			results <- resp.Term == term
+		}()
+	}
+
+	for range reqs {
+		if <-results {
+			acks++
+		}
+		if acks >= n.quorum() {
+			return nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != Leader {
+		return &NotLeaderError{Leader: n.leader}
+	}
+	return ErrNoQuorum
+}
+
+// waitApplied blocks until the entry at index has been applied.
+func (n *Node) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+			return ErrStopped
+		}
+		if n.lastApplied >= index {
+			n.mu.Unlock()
+			return nil
+		}
+		advanced := n.advanced
+		n.mu.Unlock()
+
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.stopCh:
+			return ErrStopped
+		}
+	}
+}
+
+// appendLocked appends a new entry in the current term and returns its index.
+func (n *Node) appendLocked(data []byte) (uint64, error) {
+	last, err := n.cfg.Storage.LastIndex()
+	if err != nil {
+		return 0, err
+	}
+	e := Entry{Index: last + 1, Term: n.term, Data: data}
+	if err := n.cfg.Storage.Append([]Entry{e}); err != nil {
+		return 0, err
+	}
+	n.matchIndex[n.cfg.ID] = e.Index
+	return e.Index, nil
+}
+
+func (n *Node) quorum() int {
+	return len(n.cfg.Peers)/2 + 1
+}
+
+func (n *Node) resetDeadline() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+func (n *Node) tickLoop() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case now := <-ticker.C:
+			n.mu.Lock()
+			state := n.state
+			elect := state != Leader && now.After(n.deadline)
+			beat := state == Leader && now.Sub(n.lastBeat) >= n.cfg.HeartbeatInterval
+			n.mu.Unlock()
+
+			if elect {
+				n.startElection()
+			} else if beat {
+				n.broadcast()
+			}
+		}
+	}
+}
+
+// persistLocked saves the term and vote before they are acted upon.
+func (n *Node) persistLocked() {
+	if err := n.cfg.Storage.SetHardState(n.term, n.votedFor); err != nil {
+		log.Printf("raft %s: failed to persist hard state: %v", n.cfg.ID, err)
+	}
+}
+
+// becomeFollowerLocked steps down into the given term.
+func (n *Node) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.persistLocked()
+	}
+	if n.state != Follower {
+		log.Printf("raft %s: stepping down to follower in term %d", n.cfg.ID, n.term)
+	}
+	n.state = Follower
+	n.leader = leader
+}
+
+func (n *Node) lastLogLocked() (uint64, uint64) {
+	last, err := n.cfg.Storage.LastIndex()
+	if err != nil || last == 0 {
+		return 0, 0
+	}
+	e, err := n.cfg.Storage.Entry(last)
+	if err != nil {
+		return 0, 0
+	}
+	return e.Index, e.Term
+}
+
+func (n *Node) termAtLocked(index uint64) (uint64, error) {
+	if index == 0 {
+		return 0, nil
+	}
+	e, err := n.cfg.Storage.Entry(index)
+	if err != nil {
+		return 0, err
+	}
+	return e.Term, nil
+}
+
+func (n *Node) startElection() {
+	n.mu.Lock()
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.cfg.ID
+	n.leader = ""
+	n.persistLocked()
+	n.resetDeadline()
+
+	term := n.term
+	lastIndex, lastTerm := n.lastLogLocked()
+	log.Printf("raft %s: starting election for term %d", n.cfg.ID, term)
+	n.mu.Unlock()
+
+	req := &VoteRequest{
+		Term:         term,
+		CandidateID:  n.cfg.ID,
+		LastLogIndex: lastIndex,
+		LastLogTerm:  lastTerm,
+	}
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.mu.Lock()
+		n.becomeLeaderLocked()
+		n.mu.Unlock()
+		n.broadcast()
+		return
+	}
+
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.ID {
+			continue
+		}
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			defer cancel()
+
+			resp, err := n.cfg.Transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			if resp.Term > n.term {
+				n.becomeFollowerLocked(resp.Term, "")
+				n.mu.Unlock()
+				return
+			}
+			if n.state != Candidate || n.term != term || !resp.VoteGranted {
+				n.mu.Unlock()
+				return
+			}
+
+			votes++
+			if votes != n.quorum() {
+				n.mu.Unlock()
+				return
+			}
+			n.becomeLeaderLocked()
+			n.mu.Unlock()
+			n.broadcast()
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeaderLocked() {
+	log.Printf("raft %s: elected leader for term %d", n.cfg.ID, n.term)
+	n.state = Leader
+	n.leader = n.cfg.ID
+
+	last, _ := n.cfg.Storage.LastIndex()
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	for _, p := range n.cfg.Peers {
+		n.nextIndex[p] = last + 1
+		n.matchIndex[p] = 0
+	}
+
+	// A no-op in the new term lets entries from earlier terms commit.
+	n.termStart = last + 1
+	if _, err := n.appendLocked(nil); err != nil {
+		log.Printf("raft %s: failed to append no-op: %v", n.cfg.ID, err)
+	}
+	n.advanceCommitLocked()
+}
+
+// broadcast sends AppendEntries to every follower without a request in flight.
+func (n *Node) broadcast() {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return
+	}
+	n.lastBeat = time.Now()
+	n.mu.Unlock()
+
+	for _, peer := range n.cfg.Peers {
+		if peer != n.cfg.ID {
+			go n.replicate(peer)
+		}
+	}
+}
+
+func (n *Node) replicate(peer string) {
+	n.mu.Lock()
+	if n.state != Leader || n.inflight[peer] {
+		n.mu.Unlock()
+		return
+	}
+
+	req, err := n.appendRequestLocked(peer)
+	if err != nil {
+		n.mu.Unlock()
+		log.Printf("raft %s: failed to build append for %s: %v", n.cfg.ID, peer, err)
+		return
+	}
+	n.inflight[peer] = true
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	resp, err := n.cfg.Transport.AppendEntries(ctx, peer, req)
+	cancel()
+
+	n.mu.Lock()
+	n.inflight[peer] = false
+	if err != nil || n.state != Leader || n.term != req.Term {
+		n.mu.Unlock()
+		return
+	}
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term, "")
+		n.mu.Unlock()
+		return
+	}
+
+	more := false
+	if resp.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = match + 1
+		n.advanceCommitLocked()
+
+		last, _ := n.cfg.Storage.LastIndex()
+		more = n.nextIndex[peer] <= last
+	} else {
+		next := resp.ConflictIndex
+		if next == 0 || next >= n.nextIndex[peer] {
+			next = n.nextIndex[peer] - 1
+		}
+		n.nextIndex[peer] = max(next, 1)
+		more = true
+	}
+	n.mu.Unlock()
+
+	if more {
+		n.replicate(peer)
+	}
+}
+
+func (n *Node) appendRequestLocked(peer string) (*AppendRequest, error) {
+	next := n.nextIndex[peer]
+	prevTerm, err := n.termAtLocked(next - 1)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := n.cfg.Storage.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for i := next; i <= last && len(entries) < maxAppendEntries; i++ {
+		e, err := n.cfg.Storage.Entry(i)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return &AppendRequest{
+		Term:         n.term,
+		LeaderID:     n.cfg.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  prevTerm,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}, nil
+}
+
+// advanceCommitLocked commits the highest index stored on a majority,
+// as long as that entry belongs to the current term.
+func (n *Node) advanceCommitLocked() {
+	last, err := n.cfg.Storage.LastIndex()
+	if err != nil {
+		return
+	}
+
+	for idx := last; idx > n.commitIndex; idx-- {
+		term, err := n.termAtLocked(idx)
+		if err != nil || term != n.term {
+			if term < n.term {
+				return
+			}
+			continue
+		}
+
+		count := 0
+		for _, p := range n.cfg.Peers {
+			if n.matchIndex[p] >= idx {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = idx
+			n.signalApply()
+			return
+		}
+	}
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) handleVote(req *VoteRequest) (*VoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if req.Term > n.term {
+		n.becomeFollowerLocked(req.Term, "")
+	}
+
+	resp := &VoteResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp, nil
+	}
+
+	lastIndex, lastTerm := n.lastLogLocked()
+	upToDate := req.LastLogTerm > lastTerm ||
+		(req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		n.persistLocked()
+		n.resetDeadline()
+		resp.VoteGranted = true
+	}
+	return resp, nil
+}
+
+func (n *Node) handleAppend(req *AppendRequest) (*AppendResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if req.Term < n.term {
+		return &AppendResponse{Term: n.term}, nil
+	}
+
+	n.becomeFollowerLocked(req.Term, req.LeaderID)
+	n.resetDeadline()
+	resp := &AppendResponse{Term: n.term}
+
+	last, err := n.cfg.Storage.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+	if req.PrevLogIndex > last {
+		resp.ConflictIndex = last + 1
+		return resp, nil
+	}
+	prevTerm, err := n.termAtLocked(req.PrevLogIndex)
+	if err != nil {
+		return nil, err
+	}
+	if prevTerm != req.PrevLogTerm {
+		resp.ConflictIndex = req.PrevLogIndex
+		return resp, nil
+	}
+
+	// Skip entries we already have; truncate and append from the first conflict.
+	for i, e := range req.Entries {
+		if e.Index <= last {
+			term, err := n.termAtLocked(e.Index)
+			if err != nil {
+				return nil, err
+			}
+			if term == e.Term {
+				continue
+			}
+			if e.Index <= n.commitIndex {
+				return nil, fmt.Errorf("raft: leader %s conflicts with committed entry %d", req.LeaderID, e.Index)
+			}
+		}
+		if err := n.cfg.Storage.Append(req.Entries[i:]); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	// Entries past the ones this request covers may not match the leader's
+	// yet, and a delayed request must not take back a later commit.
+	lastNew := req.PrevLogIndex + uint64(len(req.Entries))
+	if c := min(req.LeaderCommit, lastNew); c > n.commitIndex {
+		n.commitIndex = c
+		n.signalApply()
+	}
+
+	resp.Success = true
+	return resp, nil
+}
+
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.applyCh:
+		}
+
+		for {
+			n.mu.Lock()
+			if n.lastApplied >= n.commitIndex {
+				n.mu.Unlock()
+				break
+			}
+			index := n.lastApplied + 1
+			e, err := n.cfg.Storage.Entry(index)
+			n.mu.Unlock()
+			if err != nil {
+				log.Printf("raft %s: failed to read entry %d: %v", n.cfg.ID, index, err)
+				break
+			}
+
+			var a applied
+			if len(e.Data) > 0 {
+				a.result, a.err = n.cfg.Apply(index, e.Data)
+				var rejected *RejectedError
+				if a.err != nil && !errors.As(a.err, &rejected) {
+					// Skipping the entry would leave this node behind its
+					// peers for good.
+					log.Printf("raft %s: failed to apply entry %d, retrying: %v", n.cfg.ID, index, a.err)
+					select {
+					case <-n.stopCh:
+						return
+					case <-time.After(applyRetryInterval):
+					}
+					continue
+				}
+			}
+
+			n.mu.Lock()
+			n.lastApplied = index
+			close(n.advanced)
+			n.advanced = make(chan struct{})
+			if w, ok := n.waiters[index]; ok {
+				delete(n.waiters, index)
+				if w.term != e.Term {
+					a = applied{err: ErrLostLeadership}
+				}
+				w.done <- a
+			}
+			n.mu.Unlock()
+		}
+	}
+}
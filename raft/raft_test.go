@@ -0,0 +1,380 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/raft"
+	"github.com/stretchr/testify/require"
+)
+
+// member is one node of an in-process cluster together with its state machine.
+type member struct {
+	node *raft.Node
+	ts   *httptest.Server
+
+	mu      sync.Mutex
+	applied []string
+}
+
+func (m *member) values() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.applied...)
+}
+
+// newCluster starts size nodes, each listening on its own local port.
+func newCluster(t *testing.T, size int) []*member {
+	t.Helper()
+
+	members := make([]*member, size)
+	peers := make([]string, size)
+	for i := range members {
+		m := &member{}
+		mux := http.NewServeMux()
+		m.ts = httptest.NewUnstartedServer(mux)
+		peers[i] = m.ts.Listener.Addr().String()
+		members[i] = m
+
+		mux.HandleFunc("/raft/vote", func(w http.ResponseWriter, r *http.Request) { m.node.VoteHandler(w, r) })
+		mux.HandleFunc("/raft/append", func(w http.ResponseWriter, r *http.Request) { m.node.AppendHandler(w, r) })
+	}
+
+	for i, m := range members {
+		node, err := raft.NewNode(raft.Config{
+			ID:                peers[i],
+			Peers:             peers,
+			Storage:           raft.NewMemoryStorage(),
+			Transport:         raft.NewHTTPTransport(200 * time.Millisecond),
+			ElectionTimeout:   150 * time.Millisecond,
+			HeartbeatInterval: 30 * time.Millisecond,
+			Apply: func(index uint64, data []byte) (any, error) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				m.applied = append(m.applied, string(data))
+				return len(m.applied), nil
+			},
+		})
+		require.NoError(t, err)
+		m.node = node
+	}
+
+	for _, m := range members {
+		m.ts.Start()
+		m.node.Start()
+	}
+
+	t.Cleanup(func() {
+		for _, m := range members {
+			m.node.Stop()
+			m.ts.Close()
+		}
+	})
+	return members
+}
+
+// waitForLeader returns the single live member that considers itself leader.
+func waitForLeader(t *testing.T, members []*member) *member {
+	t.Helper()
+
+	var leader *member
+	require.Eventually(t, func() bool {
+		leader = nil
+		for _, m := range members {
+			if m.node.IsLeader() {
+				if leader != nil {
+					return false
+				}
+				leader = m
+			}
+		}
+		return leader != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func propose(t *testing.T, m *member, value string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := m.node.Propose(ctx, []byte(value))
+	require.NoError(t, err)
+}
+
+func TestElectsSingleLeader(t *testing.T) {
+	members := newCluster(t, 3)
+	leader := waitForLeader(t, members)
+
+	require.Eventually(t, func() bool {
+		for _, m := range members {
+			if m.node.Leader() != leader.node.Status().ID {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProposeReplicatesToAllMembers(t *testing.T) {
+	members := newCluster(t, 3)
+	leader := waitForLeader(t, members)
+
+	for _, v := range []string{"a", "b", "c"} {
+		propose(t, leader, v)
+	}
+	// Propose returns what Apply returned on the leader.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := leader.node.Propose(ctx, []byte("d"))
+	require.NoError(t, err)
+	require.Equal(t, 4, result)
+
+	// Committed means a majority has it; the leader applies before returning.
+	require.Equal(t, []string{"a", "b", "c", "d"}, leader.values())
+	for _, m := range members {
+		require.Eventually(t, func() bool {
+			return strings.Join(m.values(), ",") == "a,b,c,d"
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestProposeOnFollowerReturnsLeader(t *testing.T) {
+	members := newCluster(t, 3)
+	leader := waitForLeader(t, members)
+
+	var follower *member
+	for _, m := range members {
+		if m != leader {
+			follower = m
+		}
+	}
+	require.Eventually(t, func() bool {
+		return follower.node.Leader() != ""
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := follower.node.Propose(context.Background(), []byte("x"))
+	var notLeader *raft.NotLeaderError
+	require.True(t, errors.As(err, &notLeader))
+	require.Equal(t, leader.node.Status().ID, notLeader.Leader)
+}
+
+func TestFailoverElectsNewLeader(t *testing.T) {
+	members := newCluster(t, 3)
+	leader := waitForLeader(t, members)
+	propose(t, leader, "before")
+
+	// Kill the leader: stop the node and close its listener.
+	leader.node.Stop()
+	leader.ts.Close()
+
+	var survivors []*member
+	for _, m := range members {
+		if m != leader {
+			survivors = append(survivors, m)
+		}
+	}
+
+	next := waitForLeader(t, survivors)
+	require.NotEqual(t, leader.node.Status().ID, next.node.Status().ID)
+	propose(t, next, "after")
+
+	for _, m := range survivors {
+		require.Eventually(t, func() bool {
+			return strings.Join(m.values(), ",") == "before,after"
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestMinorityCannotCommit(t *testing.T) {
+	members := newCluster(t, 3)
+	leader := waitForLeader(t, members)
+
+	for _, m := range members {
+		if m != leader {
+			m.node.Stop()
+			m.ts.Close()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err := leader.node.Propose(ctx, []byte("lonely"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Empty(t, leader.values())
+}
+
+func TestReadIndexNeedsQuorum(t *testing.T) {
+	members := newCluster(t, 3)
+	leader := waitForLeader(t, members)
+	propose(t, leader, "a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, leader.node.ReadIndex(ctx))
+
+	var notLeader *raft.NotLeaderError
+	for _, m := range members {
+		if m != leader {
+			require.ErrorAs(t, m.node.ReadIndex(ctx), &notLeader)
+		}
+	}
+
+	// Cut off from the others, the leader still believes it leads but can
+	// no longer confirm it.
+	for _, m := range members {
+		if m != leader {
+			m.node.Stop()
+			m.ts.Close()
+		}
+	}
+	require.True(t, leader.node.IsLeader())
+	require.ErrorIs(t, leader.node.ReadIndex(ctx), raft.ErrNoQuorum)
+}
+
+func TestBadgerStoragePersistsLog(t *testing.T) {
+	dir := t.TempDir()
+
+	s, closeFunc, err := raft.NewBadgerStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.SetHardState(3, "node-a"))
+	require.NoError(t, s.Append([]raft.Entry{
+		{Index: 1, Term: 1, Data: []byte("a")},
+		{Index: 2, Term: 1, Data: []byte("b")},
+		{Index: 3, Term: 2, Data: []byte("c")},
+	}))
+	// Overwrite the tail from index 2.
+	require.NoError(t, s.Append([]raft.Entry{{Index: 2, Term: 3, Data: []byte("d")}}))
+	require.NoError(t, closeFunc())
+
+	s, closeFunc, err = raft.NewBadgerStorage(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, closeFunc()) })
+
+	term, vote, err := s.HardState()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), term)
+	require.Equal(t, "node-a", vote)
+
+	last, err := s.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), last)
+
+	e, err := s.Entry(2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("d"), e.Data)
+
+	_, err = s.Entry(3)
+	require.ErrorIs(t, err, raft.ErrCompacted)
+}
+
+func TestRestartResumesAfterApplied(t *testing.T) {
+	storage := raft.NewMemoryStorage()
+	var mu sync.Mutex
+	var applied []uint64
+	start := func(from uint64) *raft.Node {
+		node, err := raft.NewNode(raft.Config{
+			ID:              "solo",
+			Peers:           []string{"solo"},
+			Storage:         storage,
+			Applied:         from,
+			ElectionTimeout: 50 * time.Millisecond,
+			Apply: func(index uint64, data []byte) (any, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				applied = append(applied, index)
+				return nil, nil
+			},
+		})
+		require.NoError(t, err)
+		node.Start()
+		require.Eventually(t, node.IsLeader, 5*time.Second, 10*time.Millisecond)
+		return node
+	}
+
+	node := start(0)
+	for _, v := range []string{"a", "b"} {
+		_, err := node.Propose(context.Background(), []byte(v))
+		require.NoError(t, err)
+	}
+	node.Stop()
+
+	mu.Lock()
+	first := applied[0]
+	applied = nil
+	mu.Unlock()
+
+	// The state machine stored the first entry but not the second.
+	node = start(first)
+	defer node.Stop()
+	_, err := node.Propose(context.Background(), []byte("c"))
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, applied, 2)
+	require.Greater(t, applied[0], first)
+	require.Greater(t, applied[1], applied[0])
+}
+
+func TestNewNodeRejectsAppliedBeyondLog(t *testing.T) {
+	_, err := raft.NewNode(raft.Config{
+		ID:      "solo",
+		Peers:   []string{"solo"},
+		Storage: raft.NewMemoryStorage(),
+		Applied: 1,
+		Apply:   func(uint64, []byte) (any, error) { return nil, nil },
+	})
+	require.Error(t, err)
+}
+
+func TestApplyRetriesFailedEntries(t *testing.T) {
+	errRefused := errors.New("refused")
+	var mu sync.Mutex
+	var applied []string
+	failures := 2
+	node, err := raft.NewNode(raft.Config{
+		ID:              "solo",
+		Peers:           []string{"solo"},
+		Storage:         raft.NewMemoryStorage(),
+		ElectionTimeout: 50 * time.Millisecond,
+		Apply: func(index uint64, data []byte) (any, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if string(data) == "refused" {
+				return nil, raft.Reject(errRefused)
+			}
+			if failures > 0 {
+				failures--
+				return nil, errors.New("disk failure")
+			}
+			applied = append(applied, string(data))
+			return len(applied), nil
+		},
+	})
+	require.NoError(t, err)
+	node.Start()
+	defer node.Stop()
+	require.Eventually(t, node.IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	// A failed apply is retried rather than skipped.
+	result, err := node.Propose(context.Background(), []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, 1, result)
+
+	// A rejected entry is applied without effect, its error the result.
+	_, err = node.Propose(context.Background(), []byte("refused"))
+	require.ErrorIs(t, err, errRefused)
+	result, err = node.Propose(context.Background(), []byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, 2, result)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"a", "b"}, applied)
+}
@@ -0,0 +1,240 @@
+package raft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// ErrCompacted is returned when an entry that is not in the log is requested.
+var ErrCompacted = errors.New("raft: entry not available")
+
+// Storage persists the Raft hard state and log.
+type Storage interface {
+	// HardState returns the persisted term and vote.
+	HardState() (term uint64, votedFor string, err error)
+	// SetHardState persists the term and vote.
+	SetHardState(term uint64, votedFor string) error
+	// LastIndex returns the index of the last entry in the log (0 if empty).
+	LastIndex() (uint64, error)
+	// Entry returns the entry at the given index.
+	Entry(index uint64) (Entry, error)
+	// Append writes entries to the log, discarding any existing entries
+	// at or after the index of the first entry.
+	Append(entries []Entry) error
+}
+
+// MemoryStorage keeps the Raft state in memory. It is meant for tests.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	term     uint64
+	votedFor string
+	entries  []Entry
+}
+
+// NewMemoryStorage creates an empty in-memory storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// HardState returns the current term and vote.
+func (m *MemoryStorage) HardState() (uint64, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.term, m.votedFor, nil
+}
+
+// SetHardState stores the current term and vote.
+func (m *MemoryStorage) SetHardState(term uint64, votedFor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.term, m.votedFor = term, votedFor
+	return nil
+}
+
+// LastIndex returns the index of the last entry.
+func (m *MemoryStorage) LastIndex() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return uint64(len(m.entries)), nil
+}
+
+// Entry returns the entry at index.
+func (m *MemoryStorage) Entry(index uint64) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if index == 0 || index > uint64(len(m.entries)) {
+		return Entry{}, ErrCompacted
+	}
+	return m.entries[index-1], nil
+}
+
+// Append truncates the log at the first entry's index and appends entries.
+func (m *MemoryStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	first := entries[0].Index
+	if first == 0 || first > uint64(len(m.entries))+1 {
+		return fmt.Errorf("raft: append at %d leaves a gap after %d", first, len(m.entries))
+	}
+	m.entries = append(m.entries[:first-1], entries...)
+	return nil
+}
+
+var (
+	hardStateKey = []byte("hardstate")
+	entryPrefix  = []byte("entry:")
+)
+
+// BadgerStorage persists the Raft state in a dedicated Badger database.
+type BadgerStorage struct {
+	db   *badger.DB
+	mu   sync.Mutex
+	last uint64
+}
+
+// NewBadgerStorage opens (or creates) a Raft log at path.
+func NewBadgerStorage(path string) (*BadgerStorage, func() error, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create raft directory %q: %w", path, err)
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(path).WithLogger(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &BadgerStorage{db: db}
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		// Seek to the largest possible entry key to find the tail of the log.
+		it.Seek(entryKey(^uint64(0)))
+		if it.ValidForPrefix(entryPrefix) {
+			s.last = binary.BigEndian.Uint64(it.Item().Key()[len(entryPrefix):])
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return s, db.Close, nil
+}
+
+func entryKey(index uint64) []byte {
+	k := make([]byte, len(entryPrefix)+8)
+	copy(k, entryPrefix)
+	binary.BigEndian.PutUint64(k[len(entryPrefix):], index)
+	return k
+}
+
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// HardState returns the persisted term and vote.
+func (s *BadgerStorage) HardState() (uint64, string, error) {
+	var hs hardState
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(hardStateKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &hs)
+		})
+	})
+	return hs.Term, hs.VotedFor, err
+}
+
+// SetHardState persists the term and vote.
+func (s *BadgerStorage) SetHardState(term uint64, votedFor string) error {
+	data, err := json.Marshal(hardState{Term: term, VotedFor: votedFor})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(hardStateKey, data)
+	})
+}
+
+// LastIndex returns the index of the last entry.
+func (s *BadgerStorage) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, nil
+}
+
+// Entry returns the entry at index.
+func (s *BadgerStorage) Entry(index uint64) (Entry, error) {
+	var e Entry
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(entryKey(index))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrCompacted
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &e)
+		})
+	})
+	return e, err
+}
+
+// Append truncates the log at the first entry's index and appends entries.
+func (s *BadgerStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := entries[0].Index
+	if first == 0 || first > s.last+1 {
+		return fmt.Errorf("raft: append at %d leaves a gap after %d", first, s.last)
+	}
+
+	last := entries[len(entries)-1].Index
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	// Entries up to last are overwritten below; only the stale tail is deleted.
+	for i := last + 1; i <= s.last; i++ {
+		if err := wb.Delete(entryKey(i)); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := wb.Set(entryKey(e.Index), data); err != nil {
+			return err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	s.last = last
+	return nil
+}
@@ -0,0 +1,142 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// VoteRequest is sent by candidates to gather votes.
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+// VoteResponse is the reply to a VoteRequest.
+type VoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+// AppendRequest is sent by the leader to replicate entries and as a heartbeat.
+type AppendRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+// AppendResponse is the reply to an AppendRequest.
+type AppendResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// ConflictIndex hints where the leader should resume when Success is false.
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// Transport delivers RPCs to other members of the group.
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req *AppendRequest) (*AppendResponse, error)
+}
+
+// HTTPTransport sends RPCs as JSON over HTTP to the handlers served by Node.
+type HTTPTransport struct {
+	client *http.Client
+}
+
+// NewHTTPTransport creates a transport whose requests time out after timeout.
+func NewHTTPTransport(timeout time.Duration) *HTTPTransport {
+	return &HTTPTransport{client: &http.Client{Timeout: timeout}}
+}
+
+// RequestVote sends a vote request to peer.
+func (t *HTTPTransport) RequestVote(ctx context.Context, peer string, req *VoteRequest) (*VoteResponse, error) {
+	var resp VoteResponse
+	if err := t.post(ctx, peer, "/raft/vote", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AppendEntries sends an append request to peer.
+func (t *HTTPTransport) AppendEntries(ctx context.Context, peer string, req *AppendRequest) (*AppendResponse, error) {
+	var resp AppendResponse
+	if err := t.post(ctx, peer, "/raft/append", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (t *HTTPTransport) post(ctx context.Context, peer, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+peer+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("raft: %s%s returned %d: %s", peer, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// VoteHandler serves RequestVote RPCs.
+func (n *Node) VoteHandler(w http.ResponseWriter, r *http.Request) {
+	var req VoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid vote request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := n.handleVote(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// AppendHandler serves AppendEntries RPCs.
+func (n *Node) AppendHandler(w http.ResponseWriter, r *http.Request) {
+	var req AppendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid append request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := n.handleAppend(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// StatusHandler reports the node's view of the group as JSON.
+func (n *Node) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n.Status())
+}
@@ -0,0 +1,151 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sagor0078/distribKV/db"
+)
+
+// RepairReport describes the anti-entropy rounds a replica has run.
+type RepairReport struct {
+	Rounds  int       `json:"rounds"`
+	LastRun time.Time `json:"last_run,omitzero"`
+	// LastRepair is when a round last found differences.
+	LastRepair time.Time `json:"last_repair,omitzero"`
+	// Ranges and Keys are the differing buckets found and the keys repaired
+	// by the last round; the totals add up every round.
+	Ranges      int `json:"ranges"`
+	Keys        int `json:"keys"`
+	TotalRanges int `json:"total_ranges"`
+	TotalKeys   int `json:"total_keys"`
+	// LastError is why the last round failed, if it did.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// RunAntiEntropy compares the replica with the leader every interval and
+// repairs what differs, until ctx is cancelled. Replication alone never
+// notices a write the replica lost; this does.
+func (c *Client) RunAntiEntropy(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		ranges, keys, err := c.AntiEntropy(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Anti-entropy round failed: %v", err)
+		} else if ranges > 0 {
+			log.Printf("Anti-entropy repaired %d keys in %d differing ranges", keys, ranges)
+		}
+	}
+}
+
+// AntiEntropy runs one anti-entropy round: it fetches the leader's Merkle
+// tree, waits until the replica has applied the writes the tree reflects,
+// and compares it with the replica's own. Only the key ranges (buckets)
+// under differing nodes are fetched from the leader and repaired. It
+// returns how many ranges differed and how many keys were repaired.
+func (c *Client) AntiEntropy(ctx context.Context) (int, int, error) {
+	ranges, keys, err := c.antiEntropy(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := &c.repairs
+	r.Rounds++
+	r.LastRun = time.Now()
+	r.Ranges, r.Keys, r.LastError = ranges, keys, ""
+	r.TotalRanges += ranges
+	r.TotalKeys += keys
+	if ranges > 0 {
+		r.LastRepair = r.LastRun
+	}
+	if err != nil {
+		r.LastError = err.Error()
+	}
+	return ranges, keys, err
+}
+
+func (c *Client) antiEntropy(ctx context.Context) (int, int, error) {
+	var leader db.MerkleTree
+	if err := c.getJSON(ctx, "/v1/internal/merkle", &leader); err != nil {
+		return 0, 0, fmt.Errorf("fetching leader tree: %w", err)
+	}
+	// Writes the replica has not applied yet are not differences.
+	if err := c.waitApplied(ctx, leader.Seq); err != nil {
+		return 0, 0, err
+	}
+	local, err := c.db.MerkleTree()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	buckets := leader.Diff(local)
+	if len(buckets) == 0 {
+		return 0, 0, nil
+	}
+	ids := make([]string, len(buckets))
+	for i, b := range buckets {
+		ids[i] = strconv.Itoa(b)
+	}
+	var entries []db.LogEntry
+	path := "/v1/internal/merkle/entries?" + url.Values{"buckets": {strings.Join(ids, ",")}}.Encode()
+	if err := c.getJSON(ctx, path, &entries); err != nil {
+		return len(buckets), 0, fmt.Errorf("fetching leader entries: %w", err)
+	}
+	keys, err := c.db.RepairBuckets(buckets, entries)
+	return len(buckets), keys, err
+}
+
+// waitApplied waits until the replica has applied the log up to seq.
+func (c *Client) waitApplied(ctx context.Context, seq uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, pollWait)
+	defer cancel()
+	for c.Applied() < seq {
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return fmt.Errorf("replica did not reach position %d: %w", seq, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// RepairReport returns the outcome of the anti-entropy rounds so far.
+func (c *Client) RepairReport() RepairReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.repairs
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+c.leaderAddr+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("leader returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from leader: %w", err)
+	}
+	return nil
+}
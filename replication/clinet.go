@@ -2,76 +2,230 @@ package replication
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/Sagor0078/distribKV/client"
 	"github.com/Sagor0078/distribKV/db"
 )
 
-type NextKeyValue struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
-	Err   error  `json:"-"`
+const (
+	// batchSize is the maximum number of log entries requested per round trip.
+	batchSize = 1000
+	// pollWait is how long the leader may hold a request open waiting for writes.
+	pollWait = 10 * time.Second
+	// pollGrace is how late an answer to a long poll may be before the
+	// replica stops assuming it is in sync.
+	pollGrace = 2 * time.Second
+)
+
+// Batch is a contiguous chunk of the leader's replication log.
+type Batch struct {
+	Entries []db.LogEntry `json:"entries"`
+	// LastSeq is the leader's most recent sequence number when the batch was cut.
+	LastSeq uint64 `json:"last_seq"`
+}
+
+// Client pulls the leader's replication log and applies it to a replica.
+type Client struct {
+	db          *db.Database
+	leaderAddr  string
+	replicaAddr string
+	http        *http.Client
+
+	mu sync.Mutex
+	// applied is the last log position applied here, and leaderSeq the
+	// leader's last position when it cut the most recent batch.
+	applied   uint64
+	leaderSeq uint64
+	// syncedAt is when the leader last reported no entries beyond applied,
+	// and inSync whether that was the outcome of the last round trip.
+	syncedAt time.Time
+	inSync   bool
+	// pollStart is when the outstanding poll was sent, if it was sent while
+	// the replica was in sync.
+	pollStart time.Time
+	// pulls counts the round trips to the leader.
+	pulls PullStats
+	// repairs reports the anti-entropy rounds run so far.
+	repairs RepairReport
+}
+
+// PullStats describes a replica's round trips to the leader.
+type PullStats = client.PullStats
+
+// NewClient creates a replication client for a replica. The applied position
+// is stored in the replica's own database, so the client resumes where it
+// left off after a restart. replicaAddr identifies this replica when
+// acknowledging progress to the leader.
+func NewClient(db *db.Database, leaderAddr, replicaAddr string) (*Client, error) {
+	applied, err := db.AppliedSeq()
+	if err != nil {
+		return nil, fmt.Errorf("reading applied replication position: %w", err)
+	}
+	return &Client{
+		db:          db,
+		leaderAddr:  leaderAddr,
+		replicaAddr: replicaAddr,
+		applied:     applied,
+		http:        &http.Client{Timeout: pollWait + 10*time.Second},
+	}, nil
 }
 
-type client struct {
-	db         *db.Database
-	leaderAddr string
+// ClientLoop streams the leader's replication log in batches and applies it
+// locally until the process exits.
+func ClientLoop(db *db.Database, leaderAddr, replicaAddr string) {
+	c, err := NewClient(db, leaderAddr, replicaAddr)
+	if err != nil {
+		log.Fatalf("Failed to start replication: %v", err)
+	}
+	c.Run(context.Background())
 }
 
-func ClientLoop(db *db.Database, leaderAddr string) {
-	c := &client{db: db, leaderAddr: leaderAddr}
-	for {
-		ok, err := c.loop()
-		if err != nil {
+// Run pulls and applies batches until ctx is cancelled.
+func (c *Client) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if _, err := c.loop(ctx); err != nil && ctx.Err() == nil {
+			c.markFailed(err)
 			log.Printf("Replication loop error: %v", err)
-			time.Sleep(time.Second)
-			continue
-		}
-		if !ok {
-			time.Sleep(time.Millisecond * 100)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
 		}
 	}
 }
 
-func (c *client) loop() (bool, error) {
-	resp, err := http.Get("http://" + c.leaderAddr + "/next-replication-key")
+// LeaderAddr returns the address of the leader this replica follows.
+func (c *Client) LeaderAddr() string {
+	return c.leaderAddr
+}
+
+// Applied returns the last leader log position applied on this replica.
+func (c *Client) Applied() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.applied
+}
+
+// Lag returns how many log entries the replica was behind the leader when
+// it last heard from it, or the maximum if it never has. Like Staleness,
+// it is zero while an in-sync replica waits on a long poll.
+func (c *Client) Lag() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.syncedAt.IsZero() && c.leaderSeq == 0 {
+		return math.MaxUint64 // never heard from the leader
+	}
+	if c.inSync || c.leaderSeq <= c.applied {
+		return 0
+	}
+	return c.leaderSeq - c.applied
+}
+
+// Staleness returns how far behind the leader the replica may be. While an
+// in-sync replica waits on a long poll, the leader answers as soon as it has
+// a write, so the replica is current until that poll is overdue.
+func (c *Client) Staleness() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.syncedAt.IsZero() {
+		return time.Duration(math.MaxInt64)
+	}
+	if !c.pollStart.IsZero() && time.Since(c.pollStart) < pollWait+pollGrace {
+		return 0
+	}
+	return time.Since(c.syncedAt)
+}
+
+// PullStats returns the outcome of the round trips to the leader so far.
+func (c *Client) PullStats() PullStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pulls
+}
+
+// loop fetches and applies one batch. It reports whether any entries were applied.
+func (c *Client) loop(ctx context.Context) (bool, error) {
+	u := url.Values{}
+	u.Set("from", strconv.FormatUint(c.Applied(), 10))
+	u.Set("limit", strconv.Itoa(batchSize))
+	u.Set("wait", pollWait.String())
+
+	c.mu.Lock()
+	if c.inSync {
+		c.pollStart = time.Now()
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.pollStart = time.Time{}
+		c.mu.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+c.leaderAddr+"/replication-stream?"+u.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.http.Do(req)
 	if err != nil {
+		c.markOutOfSync()
 		return false, err
 	}
 	defer resp.Body.Close()
 
-	var res NextKeyValue
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		c.markOutOfSync()
+		msg, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusGone {
+			return false, fmt.Errorf("leader no longer has the entries after %d, restart the replica with -bootstrap: %s", c.Applied(), bytes.TrimSpace(msg))
+		}
+		return false, fmt.Errorf("leader returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	var batch Batch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		c.markOutOfSync()
 		return false, err
 	}
 
-	if res.Key == "" {
+	if len(batch.Entries) == 0 {
+		c.markSynced(c.Applied(), batch.LastSeq)
 		return false, nil
 	}
 
-	if err := c.db.SetKeyOnReplica(res.Key, []byte(res.Value)); err != nil {
+	if err := c.db.ApplyLogEntries(batch.Entries); err != nil {
+		c.markOutOfSync()
 		return false, err
 	}
+	c.markSynced(batch.Entries[len(batch.Entries)-1].Seq, batch.LastSeq)
 
-	if err := c.deleteFromReplicationQueue(res.Key, res.Value); err != nil {
-		log.Printf("Failed to delete replication key: %v", err)
+	if err := c.ack(); err != nil {
+		log.Printf("Failed to acknowledge replication position %d: %v", c.Applied(), err)
 	}
 
 	return true, nil
 }
 
-func (c *client) deleteFromReplicationQueue(key, value string) error {
+// ack tells the leader which position this replica has applied.
+func (c *Client) ack() error {
 	u := url.Values{}
-	u.Set("key", key)
-	u.Set("value", value)
+	u.Set("replica", c.replicaAddr)
+	u.Set("seq", strconv.FormatUint(c.Applied(), 10))
 
-	resp, err := http.Get("http://" + c.leaderAddr + "/delete-replication-key?" + u.Encode())
+	resp, err := c.http.PostForm("http://"+c.leaderAddr+"/replication-ack", u)
 	if err != nil {
 		return err
 	}
@@ -86,3 +240,39 @@ func (c *client) deleteFromReplicationQueue(key, value string) error {
 	}
 	return nil
 }
+
+// markSynced records the position applied from a batch the leader cut at
+// lastSeq, and whether the replica has caught up with it.
+func (c *Client) markSynced(applied, lastSeq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.applied = applied
+	c.leaderSeq = lastSeq
+	c.inSync = applied >= lastSeq
+	if c.inSync {
+		c.syncedAt = now
+	}
+	c.pulls.LastPull = now
+	c.pulls.ConsecutiveErrors = 0
+}
+
+// markOutOfSync stops the replica from assuming it is in sync after a
+// failed round trip.
+func (c *Client) markOutOfSync() {
+	c.mu.Lock()
+	c.inSync = false
+	c.mu.Unlock()
+}
+
+// markFailed counts a failed round trip.
+func (c *Client) markFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pulls.Errors++
+	c.pulls.ConsecutiveErrors++
+	c.pulls.LastError = err.Error()
+	c.pulls.LastErrorAt = time.Now()
+}
@@ -0,0 +1,88 @@
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/raft"
+)
+
+// Operations carried in a Command.
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+	OpBatch  = "batch"
+	// OpSetIfAbsent copies keys to their new owner during a reshard.
+	OpSetIfAbsent = "set-if-absent"
+)
+
+// Command is a write proposed to the Raft log of a shard group.
+type Command struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	// ExpiresAt is the expiry of an OpSet key in Unix seconds, if it has one.
+	// It is absolute so every member expires the key at the same moment.
+	ExpiresAt uint64 `json:"expires_at,omitempty"`
+	// Ops are the operations of an OpBatch command, run atomically.
+	Ops []db.BatchOp `json:"ops,omitempty"`
+	// KVs are the pairs of an OpSetIfAbsent command, each written unless
+	// its key exists.
+	KVs []db.KV `json:"kvs,omitempty"`
+	// Cond, if set, makes an OpSet or OpDelete conditional. Every member
+	// evaluates it against the same state, so they agree on the outcome.
+	Cond *db.Condition `json:"cond,omitempty"`
+}
+
+// Encode serializes the command for raft.Node.Propose.
+func (c Command) Encode() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// StateMachine returns the raft apply function that executes committed
+// commands against the local database. Each command is stored together
+// with its log index, see db.Database.RaftApplied. An OpSet command results
+// in the version it gave the key, its index, an OpBatch command in its
+// []db.BatchResult and an OpSetIfAbsent command in the number of keys
+// written. Commands the database rejects, and ones that cannot be
+// decoded, are marked with raft.Reject.
+func StateMachine(database *db.Database) func(uint64, []byte) (any, error) {
+	return func(index uint64, data []byte) (any, error) {
+		result, err := apply(database, index, data)
+		if db.IsRejected(err) {
+			err = raft.Reject(err)
+		}
+		return result, err
+	}
+}
+
+// apply applies the command encoded in data as the Raft log entry at index.
+func apply(database *db.Database, index uint64, data []byte) (any, error) {
+	var c Command
+	if err := json.Unmarshal(data, &c); err != nil {
+		// Every member fails to decode it alike.
+		return nil, raft.Reject(fmt.Errorf("decoding command: %w", err))
+	}
+
+	var cond db.Condition
+	if c.Cond != nil {
+		cond = *c.Cond
+	}
+
+	switch c.Op {
+	case OpSet:
+		if err := database.ApplyRaftSet(index, c.Key, c.Value, c.ExpiresAt, cond); err != nil {
+			return nil, err
+		}
+		return index, nil
+	case OpDelete:
+		return nil, database.ApplyRaftDelete(index, c.Key, cond)
+	case OpBatch:
+		return database.ApplyRaftBatch(index, c.Ops)
+	case OpSetIfAbsent:
+		return database.ApplyRaftSetIfAbsent(index, c.KVs)
+	default:
+		return nil, raft.Reject(fmt.Errorf("unknown command %q", c.Op))
+	}
+}
@@ -0,0 +1,39 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Sagor0078/distribKV/db"
+)
+
+// Bootstrap replaces the contents of a replica with a snapshot downloaded
+// from the leader and returns the log position it reflects. A client
+// created afterwards resumes replication right after that position. The
+// replica must not be serving while it bootstraps.
+func Bootstrap(ctx context.Context, database *db.Database, leaderAddr string) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+leaderAddr+"/v1/internal/snapshot", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("leader returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	// An interrupted download fails with an unexpected EOF, since the body
+	// is chunked, and leaves the replica to be bootstrapped again.
+	seq, err := database.LoadSnapshot(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("loading snapshot from %s: %w", leaderAddr, err)
+	}
+	return seq, nil
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sagor0078/distribKV/db"
+)
+
+// MerkleHandler serves GET /v1/internal/merkle, the db.MerkleTree of this
+// node, which replicas compare with their own in anti-entropy rounds.
+func (s *Server) MerkleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tree, err := s.db.MerkleTree()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to build Merkle tree: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+// MerkleEntriesHandler serves GET /v1/internal/merkle/entries?buckets=,
+// the keys of this node in a comma-separated list of Merkle buckets, for a
+// replica to repair the buckets that differ.
+func (s *Server) MerkleEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var buckets []int
+	for _, v := range strings.Split(r.URL.Query().Get("buckets"), ",") {
+		b, err := strconv.Atoi(v)
+		if err != nil || b < 0 || b >= db.MerkleBuckets {
+			http.Error(w, fmt.Sprintf("Invalid bucket %q", v), http.StatusBadRequest)
+			return
+		}
+		buckets = append(buckets, b)
+	}
+
+	entries, err := s.db.BucketEntries(buckets)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read buckets: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []db.LogEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// AntiEntropyHandler serves GET /v1/admin/anti-entropy, the
+// replication.RepairReport of a replica: when it last compared itself with
+// the leader and repaired differences, and how many it found.
+func (s *Server) AntiEntropyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.replica == nil {
+		http.Error(w, "Anti-entropy runs on replicas", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.replica.RepairReport())
+}
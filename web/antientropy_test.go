@@ -0,0 +1,64 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/replication"
+)
+
+func TestAntiEntropyRepairsReplica(t *testing.T) {
+	p := newReplicaPair(t)
+	stop := p.run()
+	defer stop()
+
+	for _, key := range []string{"a", "b"} {
+		if err := p.leaderDB.SetKey(key, []byte("v-"+key)); err != nil {
+			t.Fatalf("SetKey(%q) failed: %v", key, err)
+		}
+	}
+	p.waitFor(t, "b")
+
+	// Writes that bypass the replication log never reach the replica.
+	if err := p.leaderDB.SetKeyOnReplica("lost", []byte("v-lost")); err != nil {
+		t.Fatalf("SetKeyOnReplica failed: %v", err)
+	}
+	if err := p.leaderDB.DeleteExtraKeys(func(key string) bool { return key == "a" }); err != nil {
+		t.Fatalf("DeleteExtraKeys failed: %v", err)
+	}
+
+	ranges, keys, err := p.client.AntiEntropy(context.Background())
+	if err != nil {
+		t.Fatalf("AntiEntropy failed: %v", err)
+	}
+	if ranges == 0 || keys != 2 {
+		t.Errorf("AntiEntropy found %d ranges and repaired %d keys, want 2 keys", ranges, keys)
+	}
+	if val, err := p.replicaDB.GetKey("lost"); err != nil || string(val) != "v-lost" {
+		t.Errorf("replica has lost = %q, %v", val, err)
+	}
+	if _, err := p.replicaDB.GetKey("a"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("replica still has a: %v", err)
+	}
+
+	if ranges, keys, err := p.client.AntiEntropy(context.Background()); err != nil || ranges != 0 || keys != 0 {
+		t.Errorf("second AntiEntropy returned %d, %d, %v; want nothing to repair", ranges, keys, err)
+	}
+
+	resp, err := http.Get(p.replica.URL + "/v1/admin/anti-entropy")
+	if err != nil {
+		t.Fatalf("GET anti-entropy report failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var report replication.RepairReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if report.Rounds != 2 || report.TotalKeys != 2 || report.Keys != 0 || report.LastRepair.IsZero() || !report.LastRepair.Before(report.LastRun) {
+		t.Errorf("report = %+v", report)
+	}
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Sagor0078/distribKV/client"
+)
+
+// Trailers of GET /v1/admin/backup, sent once the backup is complete. A
+// response without them was cut short.
+const (
+	// BackupSeqTrailer is the log position the backup reflects, or 0 for
+	// an incremental backup if no write was logged since the previous one.
+	BackupSeqTrailer = client.BackupSeqTrailer
+	// BackupNextTrailer is the since to ask for the next incremental backup.
+	BackupNextTrailer = client.BackupNextTrailer
+)
+
+// BackupDBHeader holds the ID of the database a backup is taken from.
+const BackupDBHeader = client.BackupDBHeader
+
+// BackupHandler serves GET /v1/admin/backup?since=&db=, a backup of the
+// shard leader's data in Badger's backup format: a full backup without
+// since, or the changes after an earlier backup whose BackupNextTrailer is
+// given as since. If db is given, the incremental backup is refused with
+// 412 Precondition Failed unless the earlier backup's BackupDBHeader was db,
+// as since means nothing to another database. Writes continue while the
+// backup is taken. Backups are positioned
+// in the replication log, which Raft groups do not keep, so nodes started
+// with -raft answer 501 Not Implemented.
+func (s *Server) BackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.raft != nil {
+		http.Error(w, "Backups are not supported with Raft", http.StatusNotImplemented)
+		return
+	}
+	if s.replica != nil {
+		http.Error(w, "Backups are taken from shard leaders", http.StatusMisdirectedRequest)
+		return
+	}
+	r.ParseForm()
+	since, err := parseUintParam(r, "since")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id := r.Form.Get("db"); since > 0 && id != "" && id != s.db.ID() {
+		http.Error(w, fmt.Sprintf("Backup since %d was taken from another database", since), http.StatusPreconditionFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(BackupDBHeader, s.db.ID())
+	w.Header().Set("Trailer", BackupSeqTrailer+", "+BackupNextTrailer)
+	info, err := s.db.Backup(w, since)
+	if err != nil {
+		// The status is already sent; leaving out the trailers marks the
+		// backup as incomplete.
+		log.Printf("Failed to send backup: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set(BackupSeqTrailer, strconv.FormatUint(info.Seq, 10))
+	w.Header().Set(BackupNextTrailer, strconv.FormatUint(info.Next, 10))
+}
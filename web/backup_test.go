@@ -0,0 +1,92 @@
+package web_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// fetchBackup downloads a backup and returns it with its trailers.
+func fetchBackup(t *testing.T, url string) ([]byte, uint64, uint64) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %d", url, resp.StatusCode)
+	}
+	if resp.Header.Get(web.BackupDBHeader) == "" {
+		t.Errorf("backup has no %s header", web.BackupDBHeader)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+	seq, err := strconv.ParseUint(resp.Trailer.Get(web.BackupSeqTrailer), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid %s trailer: %v", web.BackupSeqTrailer, err)
+	}
+	next, err := strconv.ParseUint(resp.Trailer.Get(web.BackupNextTrailer), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid %s trailer: %v", web.BackupNextTrailer, err)
+	}
+	return data, seq, next
+}
+
+func TestBackupHandler(t *testing.T) {
+	database, server := createTestServer(t, 0, map[int]string{0: "placeholder"})
+	ts := httptest.NewServer(http.HandlerFunc(server.BackupHandler))
+	defer ts.Close()
+
+	if err := database.SetKey("a", []byte("1")); err != nil {
+		t.Fatalf("SetKey failed: %v", err)
+	}
+	full, seq, next := fetchBackup(t, ts.URL)
+	if seq != database.LastSeq() {
+		t.Errorf("full backup is at position %d, want %d", seq, database.LastSeq())
+	}
+
+	if err := database.SetKey("b", []byte("2")); err != nil {
+		t.Fatalf("SetKey failed: %v", err)
+	}
+	incr, seq, _ := fetchBackup(t, ts.URL+"?since="+strconv.FormatUint(next, 10)+"&db="+database.ID())
+	if seq != database.LastSeq() {
+		t.Errorf("incremental backup is at position %d, want %d", seq, database.LastSeq())
+	}
+
+	restored := createTempDB(t, 1)
+	if _, err := restored.Restore(bytes.NewReader(full), bytes.NewReader(incr)); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		if val, err := restored.GetKey(key); err != nil || string(val) != want {
+			t.Errorf("restored %q = %q, %v; want %q", key, val, err, want)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "?since=" + strconv.FormatUint(next, 10) + "&db=" + restored.ID())
+	if err != nil {
+		t.Fatalf("GET backup failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("backup after one of another database returned %d, want 412", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "?since=x")
+	if err != nil {
+		t.Fatalf("GET backup failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("backup with an invalid since returned %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,270 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/raft"
+	"github.com/Sagor0078/distribKV/replication"
+)
+
+const (
+	maxBatchOps  = 1000
+	maxBatchSize = 64 << 20
+)
+
+// BatchRequest is the body of POST /v1/batch.
+type BatchRequest struct {
+	Ops []db.BatchOp `json:"ops"`
+}
+
+// BatchResult is the outcome of one operation of a batch. Status is what
+// the single-key endpoint would have answered: 200 or 404 for a get, 204
+// for a write, or an error status with Error set.
+type BatchResult struct {
+	Key    string `json:"key"`
+	Status int    `json:"status"`
+	Value  []byte `json:"value,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchResponse is the response body of POST /v1/batch, with one result
+// per operation in request order.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchHandler serves POST /v1/batch. The operations are split by owning
+// shard. Each shard runs its part in order in a single transaction, and
+// the parts owned by other shards are forwarded to them in parallel. A
+// batch spanning several shards is not atomic: one shard's part can fail
+// while another's is applied.
+func (s *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Invalid batch: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Ops) == 0 || len(req.Ops) > maxBatchOps {
+		http.Error(w, fmt.Sprintf("Invalid batch: must have 1 to %d operations, got %d", maxBatchOps, len(req.Ops)), http.StatusBadRequest)
+		return
+	}
+	for i, op := range req.Ops {
+		switch {
+		case op.Op != db.BatchPut && op.Op != db.BatchDelete && op.Op != db.BatchGet:
+			http.Error(w, fmt.Sprintf("Invalid operation %d: unknown op %q", i, op.Op), http.StatusBadRequest)
+			return
+		case op.Key == "":
+			http.Error(w, fmt.Sprintf("Invalid operation %d: missing key", i), http.StatusBadRequest)
+			return
+		case db.IsReservedKey(op.Key):
+			http.Error(w, fmt.Sprintf("Invalid operation %d: %v", i, db.ErrReservedKey), http.StatusBadRequest)
+			return
+		}
+	}
+	hops, err := requestHops(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.checkEpoch(w, r) {
+		return
+	}
+
+	shards := s.topology()
+	groups := make(map[int][]int)
+	for i, op := range req.Ops {
+		idx := shards.Index(op.Key)
+		groups[idx] = append(groups[idx], i)
+	}
+
+	results := make([]BatchResult, len(req.Ops))
+	var wg sync.WaitGroup
+	for idx, positions := range groups {
+		ops := make([]db.BatchOp, len(positions))
+		for j, p := range positions {
+			ops[j] = req.Ops[p]
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var res []BatchResult
+			if idx == shards.CurIdx {
+				res = s.localBatch(r.Context(), ops, hops)
+			} else {
+				res = s.sendBatch(r.Context(), shards.Addrs[idx], ops, hops)
+			}
+			for j, p := range positions {
+				results[p] = res[j]
+			}
+		}()
+	}
+	wg.Wait()
+
+	w.Header().Set(EpochHeader, strconv.FormatUint(shards.Epoch, 10))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchResponse{Results: results})
+}
+
+// localBatch runs the part of a batch owned by this shard. Writes on a
+// replica or Raft follower are sent to the shard leader.
+func (s *Server) localBatch(ctx context.Context, ops []db.BatchOp, hops int) []BatchResult {
+	writes := make([]db.BatchOp, 0, len(ops))
+	for _, op := range ops {
+		if op.IsWrite() {
+			writes = append(writes, op)
+		}
+	}
+
+	var res []db.BatchResult
+	var err error
+	switch {
+	case len(writes) > 0 && s.replica != nil:
+		return s.sendBatch(ctx, s.replica.LeaderAddr(), ops, hops)
+	case len(writes) > 0 && s.raft != nil:
+		// Gets are proposed with the writes, so they run in the same
+		// transaction as on a node without Raft.
+		var data []byte
+		data, err = replication.Command{Op: replication.OpBatch, Ops: ops}.Encode()
+		if err != nil {
+			return failBatch(ops, http.StatusInternalServerError, fmt.Sprintf("Failed to encode command: %v", err))
+		}
+		var result any
+		result, err = s.raft.Propose(ctx, data)
+		var notLeader *raft.NotLeaderError
+		if errors.As(err, &notLeader) {
+			if notLeader.Leader == "" {
+				return failBatch(ops, http.StatusServiceUnavailable, "No leader elected for this shard")
+			}
+			return s.sendBatch(ctx, notLeader.Leader, ops, hops)
+		}
+		if err != nil {
+			return failBatch(ops, writeStatus(err), fmt.Sprintf("Failed to commit batch: %v", err))
+		}
+		var ok bool
+		if res, ok = result.([]db.BatchResult); !ok || len(res) != len(ops) {
+			return failBatch(ops, http.StatusInternalServerError, "Batch applied without results")
+		}
+	default:
+		res, err = s.db.Batch(ops)
+	}
+	if err != nil {
+		return failBatch(ops, writeStatus(err), fmt.Sprintf("Failed to run batch: %v", err))
+	}
+
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = BatchResult{Key: op.Key, Status: http.StatusNoContent}
+		switch op.Op {
+		case db.BatchGet:
+			s.batchGetResult(ctx, &results[i], res[i])
+		case db.BatchDelete:
+			if err := s.deletePrevious(ctx, op.Key); err != nil {
+				results[i].Status = http.StatusBadGateway
+				results[i].Error = fmt.Sprintf("Failed to delete key from previous owner: %v", err)
+			}
+		}
+	}
+	return results
+}
+
+// batchGetResult fills in the result of a get. During a reshard, a key that
+// has not been copied here yet is read from its previous owner.
+func (s *Server) batchGetResult(ctx context.Context, result *BatchResult, res db.BatchResult) {
+	val, found := res.Value, res.Found
+	if !found {
+		if addr, ok := s.previousOwner(result.Key); ok {
+			var err error
+			val, err = s.migrationRequest(ctx, http.MethodGet, addr, result.Key)
+			switch {
+			case err == nil:
+				found = true
+			case !errors.Is(err, db.ErrNotFound):
+				result.Status = http.StatusBadGateway
+				result.Error = fmt.Sprintf("Failed to get key from previous owner: %v", err)
+				return
+			}
+		}
+	}
+
+	if !found {
+		result.Status = http.StatusNotFound
+		result.Error = "Key not found"
+		return
+	}
+	result.Status = http.StatusOK
+	result.Value = val
+}
+
+// sendBatch forwards part of a batch to the node at addr.
+func (s *Server) sendBatch(ctx context.Context, addr string, ops []db.BatchOp, hops int) []BatchResult {
+	if hops >= maxForwardHops {
+		return failBatch(ops, http.StatusLoopDetected, fmt.Sprintf("Forwarding loop: request already forwarded %d times", hops))
+	}
+
+	body, err := json.Marshal(BatchRequest{Ops: ops})
+	if err != nil {
+		return failBatch(ops, http.StatusInternalServerError, fmt.Sprintf("Failed to encode batch: %v", err))
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.forwardTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+"/v1/batch", bytes.NewReader(body))
+	if err != nil {
+		return failBatch(ops, http.StatusInternalServerError, fmt.Sprintf("Error building batch request: %v", err))
+	}
+	epoch := s.topology().Epoch
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EpochHeader, strconv.FormatUint(epoch, 10))
+	req.Header.Set(HopsHeader, strconv.Itoa(hops+1))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		status := http.StatusBadGateway
+		if ctx.Err() == context.DeadlineExceeded {
+			status = http.StatusGatewayTimeout
+		}
+		return failBatch(ops, status, fmt.Sprintf("Error forwarding batch to %s: %v", addr, err))
+	}
+	defer resp.Body.Close()
+
+	if theirs, err := strconv.ParseUint(resp.Header.Get(EpochHeader), 10, 64); err == nil && theirs > epoch {
+		go s.tryReload()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return failBatch(ops, resp.StatusCode, fmt.Sprintf("%s answered %d", addr, resp.StatusCode))
+	}
+	var res BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || len(res.Results) != len(ops) {
+		return failBatch(ops, http.StatusBadGateway, fmt.Sprintf("Invalid batch response from %s", addr))
+	}
+	return res.Results
+}
+
+// failBatch returns the same failure for every operation.
+func failBatch(ops []db.BatchOp, status int, msg string) []BatchResult {
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = BatchResult{Key: op.Key, Status: status, Error: msg}
+	}
+	return results
+}
@@ -0,0 +1,136 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+func runBatch(t *testing.T, base string, ops []db.BatchOp) []web.BatchResult {
+	t.Helper()
+
+	body, _ := json.Marshal(web.BatchRequest{Ops: ops})
+	resp, data := doRequest(t, http.MethodPost, base+"/v1/batch", body, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("batch returned %d: %s", resp.StatusCode, data)
+	}
+	var res web.BatchResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("invalid batch response %q: %v", data, err)
+	}
+	if len(res.Results) != len(ops) {
+		t.Fatalf("batch returned %d results for %d ops", len(res.Results), len(ops))
+	}
+	return res.Results
+}
+
+func TestBatchAcrossShards(t *testing.T) {
+	urls := newRESTCluster(t, 3)
+	k0, k1, k2 := keyForShard(3, 0), keyForShard(3, 1), keyForShard(3, 2)
+	doRequest(t, http.MethodPut, urls[2]+"/v1/keys/"+k2, []byte("old"), nil)
+
+	results := runBatch(t, urls[0], []db.BatchOp{
+		{Op: db.BatchPut, Key: k0, Value: []byte("v0")},
+		{Op: db.BatchPut, Key: k1, Value: []byte("v1")},
+		{Op: db.BatchGet, Key: k1},
+		{Op: db.BatchGet, Key: k2},
+		{Op: db.BatchDelete, Key: k2},
+		{Op: db.BatchGet, Key: k2},
+		{Op: db.BatchGet, Key: "missing"},
+	})
+	want := []struct {
+		status int
+		value  string
+	}{
+		{http.StatusNoContent, ""},
+		{http.StatusNoContent, ""},
+		{http.StatusOK, "v1"},
+		{http.StatusOK, "old"},
+		{http.StatusNoContent, ""},
+		{http.StatusNotFound, ""},
+		{http.StatusNotFound, ""},
+	}
+	for i, w := range want {
+		if results[i].Status != w.status || string(results[i].Value) != w.value {
+			t.Errorf("result %d is %d %q (%s), want %d %q", i, results[i].Status, results[i].Value, results[i].Error, w.status, w.value)
+		}
+	}
+
+	for key, want := range map[string]int{k0: http.StatusOK, k1: http.StatusOK, k2: http.StatusNotFound} {
+		if resp, _ := doRequest(t, http.MethodGet, urls[1]+"/v1/keys/"+key, nil, nil); resp.StatusCode != want {
+			t.Errorf("GET %s after batch returned %d, want %d", key, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestBatchRejectsInvalidRequests(t *testing.T) {
+	urls := newRESTCluster(t, 1)
+
+	for _, body := range []string{
+		`not json`,
+		`{"ops": []}`,
+		`{"ops": [{"op": "increment", "key": "a"}]}`,
+		`{"ops": [{"op": "put", "value": "dg=="}]}`,
+	} {
+		if resp, data := doRequest(t, http.MethodPost, urls[0]+"/v1/batch", []byte(body), nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("batch %s returned %d: %s", body, resp.StatusCode, data)
+		}
+	}
+	if resp, _ := doRequest(t, http.MethodGet, urls[0]+"/v1/batch", nil, nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /v1/batch returned %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestBatchOnReplicaWritesToLeader(t *testing.T) {
+	p := newReplicaPair(t)
+
+	results := runBatch(t, p.replica.URL, []db.BatchOp{
+		{Op: db.BatchPut, Key: "a", Value: []byte("1")},
+		{Op: db.BatchGet, Key: "a"},
+	})
+	if results[0].Status != http.StatusNoContent || results[1].Status != http.StatusOK || string(results[1].Value) != "1" {
+		t.Errorf("batch on replica returned %+v", results)
+	}
+	if _, err := p.leaderDB.GetKey("a"); err != nil {
+		t.Errorf("batch write did not reach the leader: %v", err)
+	}
+
+	// Reads alone are served by the replica, which has not replicated yet.
+	results = runBatch(t, p.replica.URL, []db.BatchOp{{Op: db.BatchGet, Key: "a"}})
+	if results[0].Status != http.StatusNotFound {
+		t.Errorf("read-only batch on replica returned %+v", results)
+	}
+}
+
+func TestBatchWithRaft(t *testing.T) {
+	g := newRaftGroup(t, 3)
+	follower := g.urls[(g.leader()+1)%3]
+	doRequest(t, http.MethodPut, follower+"/v1/keys/k", []byte("old"), nil)
+
+	// Gets see the writes made earlier in the batch, and only those.
+	results := runBatch(t, follower, []db.BatchOp{
+		{Op: db.BatchGet, Key: "k"},
+		{Op: db.BatchPut, Key: "k", Value: []byte("new")},
+		{Op: db.BatchGet, Key: "k"},
+		{Op: db.BatchDelete, Key: "k"},
+		{Op: db.BatchGet, Key: "k"},
+	})
+	want := []struct {
+		status int
+		value  string
+	}{
+		{http.StatusOK, "old"},
+		{http.StatusNoContent, ""},
+		{http.StatusOK, "new"},
+		{http.StatusNoContent, ""},
+		{http.StatusNotFound, ""},
+	}
+	for i, w := range want {
+		if results[i].Status != w.status || string(results[i].Value) != w.value {
+			t.Errorf("result %d is %d %q (%s), want %d %q", i, results[i].Status, results[i].Value, results[i].Error, w.status, w.value)
+		}
+	}
+}
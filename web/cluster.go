@@ -0,0 +1,133 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ClusterStatus is the replication status of every node in the topology,
+// by shard.
+type ClusterStatus struct {
+	Epoch  uint64        `json:"epoch"`
+	Shards []ShardStatus `json:"shards"`
+}
+
+// ShardStatus is the replication status of the nodes of one shard.
+type ShardStatus struct {
+	Shard int    `json:"shard"`
+	Name  string `json:"name,omitempty"`
+	// Healthy is set when every node answered and every replica has synced
+	// with the leader and is not failing to reach it.
+	Healthy bool `json:"healthy"`
+	// MaxLag is the largest lag reported by a replica of the shard.
+	MaxLag uint64       `json:"max_lag"`
+	Nodes  []NodeStatus `json:"nodes"`
+}
+
+// NodeStatus is the ReplicationStatus of one node, or why it could not be
+// read.
+type NodeStatus struct {
+	Addr string `json:"addr"`
+	// Leader is set for the shard leader in the topology, as opposed to its
+	// replicas.
+	Leader bool               `json:"leader"`
+	Status *ReplicationStatus `json:"status,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// ClusterStatusHandler serves GET /v1/cluster/status, the ClusterStatus of
+// every leader and replica in the topology. Any node can serve it; nodes
+// that do not answer are reported with an error rather than failing the
+// request.
+func (s *Server) ClusterStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	shards := s.topology()
+	status := ClusterStatus{Epoch: shards.Epoch, Shards: make([]ShardStatus, shards.Count)}
+	for idx := range status.Shards {
+		sh := &status.Shards[idx]
+		sh.Shard = idx
+		sh.Nodes = append(sh.Nodes, NodeStatus{Addr: shards.Addrs[idx], Leader: true})
+		for _, addr := range shards.GetReplicas(idx) {
+			sh.Nodes = append(sh.Nodes, NodeStatus{Addr: addr})
+		}
+	}
+	for _, l := range shards.Layout {
+		if l.Idx >= 0 && l.Idx < len(status.Shards) {
+			status.Shards[l.Idx].Name = l.Name
+		}
+	}
+
+	var wg sync.WaitGroup
+	for idx := range status.Shards {
+		for i := range status.Shards[idx].Nodes {
+			n := &status.Shards[idx].Nodes[i]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				st, err := s.fetchReplicationStatus(r.Context(), n.Addr)
+				if err != nil {
+					n.Error = err.Error()
+					return
+				}
+				n.Status = st
+			}()
+		}
+	}
+	wg.Wait()
+
+	for idx := range status.Shards {
+		sh := &status.Shards[idx]
+		sh.Healthy = true
+		for _, n := range sh.Nodes {
+			st := n.Status
+			switch {
+			case st == nil:
+				sh.Healthy = false
+			case st.Role == RoleReplica:
+				if !st.Synced || (st.Pulls != nil && st.Pulls.ConsecutiveErrors > 0) {
+					sh.Healthy = false
+				}
+				sh.MaxLag = max(sh.MaxLag, st.Lag)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// fetchReplicationStatus reads the ReplicationStatus of the node at addr.
+func (s *Server) fetchReplicationStatus(ctx context.Context, addr string) (*ReplicationStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.forwardTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/v1/admin/replication", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s returned %d: %s", addr, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var st ReplicationStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, fmt.Errorf("invalid status from %s: %w", addr, err)
+	}
+	return &st, nil
+}
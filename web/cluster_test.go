@@ -0,0 +1,81 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+func TestClusterStatus(t *testing.T) {
+	p := newReplicaPair(t)
+	leaderAddr := strings.TrimPrefix(p.leader.URL, "http://")
+	replicaAddr := strings.TrimPrefix(p.replica.URL, "http://")
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downAddr := strings.TrimPrefix(down.URL, "http://")
+	down.Close()
+
+	server := web.NewServer(createTempDB(t, 0), &config.Shards{
+		Count:    2,
+		CurIdx:   0,
+		Addrs:    map[int]string{0: leaderAddr, 1: downAddr},
+		Replicas: map[int][]string{0: {replicaAddr}},
+		Layout:   []config.Shard{{Name: "alpha", Idx: 0}, {Name: "beta", Idx: 1}},
+	})
+	ts := httptest.NewServer(http.HandlerFunc(server.ClusterStatusHandler))
+	defer ts.Close()
+
+	clusterStatus := func() web.ClusterStatus {
+		t.Helper()
+		resp, body := doRequest(t, http.MethodGet, ts.URL, nil, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("cluster status returned %d: %s", resp.StatusCode, body)
+		}
+		var st web.ClusterStatus
+		if err := json.Unmarshal(body, &st); err != nil {
+			t.Fatalf("invalid cluster status %q: %v", body, err)
+		}
+		if len(st.Shards) != 2 {
+			t.Fatalf("cluster status has %d shards, want 2: %s", len(st.Shards), body)
+		}
+		return st
+	}
+
+	// The replica has not synced yet.
+	st := clusterStatus()
+	if st.Shards[0].Healthy {
+		t.Errorf("shard with an unsynced replica reported healthy: %+v", st.Shards[0])
+	}
+
+	doRequest(t, http.MethodPut, p.leader.URL+"/v1/keys/cs", []byte("v"), nil)
+	cancel := p.run()
+	defer cancel()
+	p.waitFor(t, "cs")
+
+	st = clusterStatus()
+	alpha := st.Shards[0]
+	if !alpha.Healthy || alpha.Name != "alpha" || alpha.MaxLag != 0 || len(alpha.Nodes) != 2 {
+		t.Errorf("shard alpha status: %+v", alpha)
+	}
+	if n := alpha.Nodes[0]; !n.Leader || n.Addr != leaderAddr || n.Status == nil || n.Status.Role != web.RoleLeader || n.Status.Position != 1 {
+		t.Errorf("shard alpha leader status: %+v", n)
+	}
+	if n := alpha.Nodes[1]; n.Leader || n.Addr != replicaAddr || n.Status == nil || n.Status.Role != web.RoleReplica || n.Status.Position != 1 {
+		t.Errorf("shard alpha replica status: %+v", n)
+	}
+
+	beta := st.Shards[1]
+	if beta.Healthy || beta.Name != "beta" || len(beta.Nodes) != 1 || beta.Nodes[0].Status != nil || beta.Nodes[0].Error == "" {
+		t.Errorf("unreachable shard beta status: %+v", beta)
+	}
+
+	resp, _ := doRequest(t, http.MethodPost, ts.URL, nil, nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned %d, want 405", resp.StatusCode)
+	}
+}
@@ -0,0 +1,192 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sagor0078/distribKV/raft"
+)
+
+// WriteTokenHeader carries a session token on responses to writes. Passing
+// it back as "token" with consistency=session guarantees that a read
+// observes that write (read-your-writes), even when served by a replica.
+const WriteTokenHeader = "X-Write-Token"
+
+// Read consistency levels, chosen with the "consistency" query parameter.
+const (
+	// ConsistencyEventual reads whatever the node has. This is the default.
+	ConsistencyEventual = "eventual"
+	// ConsistencyBounded reads from a replica only if it is within
+	// "max-staleness" (a duration) and "max-lag" (log entries) of the leader.
+	ConsistencyBounded = "bounded"
+	// ConsistencySession reads from a replica only if it has applied the
+	// write identified by "token".
+	ConsistencySession = "session"
+	// ConsistencyStrong always reads from the shard leader. A Raft leader
+	// first confirms with a majority of its group that it still leads.
+	ConsistencyStrong = "strong"
+)
+
+// readConsistency is the consistency a read asked for.
+type readConsistency struct {
+	level        string
+	maxStaleness time.Duration
+	maxLag       uint64
+	hasStaleness bool
+	hasLag       bool
+	// tokenShard and tokenPos identify the write a session read must see.
+	tokenShard int
+	tokenPos   uint64
+	hasToken   bool
+}
+
+func parseConsistency(r *http.Request) (readConsistency, error) {
+	q := r.URL.Query()
+	c := readConsistency{level: q.Get("consistency")}
+
+	if v := q.Get("max-staleness"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return c, fmt.Errorf("Invalid max-staleness %q", v)
+		}
+		c.maxStaleness, c.hasStaleness = d, true
+	}
+	if v := q.Get("max-lag"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("Invalid max-lag %q", v)
+		}
+		c.maxLag, c.hasLag = n, true
+	}
+
+	switch c.level {
+	case "":
+		// A bound on its own asks for bounded staleness.
+		c.level = ConsistencyEventual
+		if c.hasStaleness || c.hasLag {
+			c.level = ConsistencyBounded
+		}
+	case ConsistencyEventual, ConsistencyStrong:
+	case ConsistencyBounded:
+		if !c.hasStaleness && !c.hasLag {
+			return c, fmt.Errorf("Bounded consistency needs max-staleness or max-lag")
+		}
+	case ConsistencySession:
+		if v := q.Get("token"); v != "" {
+			shard, pos, ok := strings.Cut(v, ":")
+			var err1, err2 error
+			c.tokenShard, err1 = strconv.Atoi(shard)
+			c.tokenPos, err2 = strconv.ParseUint(pos, 10, 64)
+			if !ok || err1 != nil || err2 != nil {
+				return c, fmt.Errorf("Invalid token %q", v)
+			}
+			c.hasToken = true
+		}
+	default:
+		return c, fmt.Errorf("Unknown consistency %q", c.level)
+	}
+	return c, nil
+}
+
+// readable reports whether a read may be served from this node at the
+// consistency it asks for. Otherwise the request has been sent to the shard
+// leader, or refused when there is none, and the caller must return.
+func (s *Server) readable(w http.ResponseWriter, r *http.Request) bool {
+	c, err := parseConsistency(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	leader, isLeader := s.shardLeader()
+	if isLeader && s.raft != nil && c.level == ConsistencyStrong {
+		return s.confirmLeader(w, r)
+	}
+	if isLeader || s.satisfies(c) {
+		return true
+	}
+	if leader == "" {
+		http.Error(w, "No leader elected for this shard", http.StatusServiceUnavailable)
+		return false
+	}
+	s.forward(leader, w, r)
+	return false
+}
+
+// confirmLeader reports whether this Raft leader is still the leader of its
+// group and has applied every write committed so far, so that a strong read
+// may be served from it. A leader cut off from the group cannot confirm it;
+// the read is then sent to the new leader, or refused.
+func (s *Server) confirmLeader(w http.ResponseWriter, r *http.Request) bool {
+	err := s.raft.ReadIndex(r.Context())
+	var notLeader *raft.NotLeaderError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &notLeader) && notLeader.Leader != "":
+		s.forward(notLeader.Leader, w, r)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to confirm leadership: %v", err), http.StatusServiceUnavailable)
+	}
+	return false
+}
+
+// shardLeader returns the leader of this node's shard group, and whether
+// that is this node.
+func (s *Server) shardLeader() (string, bool) {
+	switch {
+	case s.replica != nil:
+		return s.replica.LeaderAddr(), false
+	case s.raft != nil && !s.raft.IsLeader():
+		return s.raft.Leader(), false
+	default:
+		return "", true
+	}
+}
+
+// satisfies reports whether this follower can serve a read at c locally.
+func (s *Server) satisfies(c readConsistency) bool {
+	switch c.level {
+	case ConsistencyEventual:
+		return true
+	case ConsistencySession:
+		if !c.hasToken {
+			return true
+		}
+		return c.tokenShard == s.topology().CurIdx && s.appliedPosition() >= c.tokenPos
+	case ConsistencyBounded:
+		// Raft followers don't know how far behind the leader they are.
+		if s.replica == nil {
+			return false
+		}
+		if c.hasStaleness && s.replica.Staleness() > c.maxStaleness {
+			return false
+		}
+		return !c.hasLag || s.replica.Lag() <= c.maxLag
+	default:
+		return false
+	}
+}
+
+// appliedPosition returns the position of the shard's log applied on this
+// node: the Raft index under Raft, the replication log sequence otherwise.
+func (s *Server) appliedPosition() uint64 {
+	switch {
+	case s.raft != nil:
+		return s.raft.Status().LastApplied
+	case s.replica != nil:
+		return s.replica.Applied()
+	default:
+		return s.db.LastSeq()
+	}
+}
+
+// setWriteToken sets the session token for a write that has just been
+// applied on this node.
+func (s *Server) setWriteToken(w http.ResponseWriter) {
+	w.Header().Set(WriteTokenHeader, fmt.Sprintf("%d:%d", s.topology().CurIdx, s.appliedPosition()))
+}
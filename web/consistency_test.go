@@ -0,0 +1,129 @@
+package web_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/web"
+)
+
+func TestReadConsistencyLevels(t *testing.T) {
+	p := newReplicaPair(t)
+
+	resp, _ := doRequest(t, http.MethodPut, p.leader.URL+"/v1/keys/ck", []byte("v1"), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT returned %d", resp.StatusCode)
+	}
+	token := resp.Header.Get(web.WriteTokenHeader)
+	if token != "0:1" {
+		t.Fatalf("write token is %q, want 0:1", token)
+	}
+
+	get := func(query url.Values) (int, string) {
+		resp, body := doRequest(t, http.MethodGet, p.replica.URL+"/v1/keys/ck?"+query.Encode(), nil, nil)
+		return resp.StatusCode, string(body)
+	}
+
+	// The replica has not replicated anything yet: only eventual reads are
+	// served from it, everything else goes to the leader.
+	for _, tc := range []struct {
+		query url.Values
+		code  int
+	}{
+		{url.Values{}, http.StatusNotFound},
+		{url.Values{"consistency": {"eventual"}}, http.StatusNotFound},
+		{url.Values{"consistency": {"session"}}, http.StatusNotFound},
+		{url.Values{"consistency": {"strong"}}, http.StatusOK},
+		{url.Values{"consistency": {"session"}, "token": {token}}, http.StatusOK},
+		{url.Values{"consistency": {"bounded"}, "max-lag": {"1000"}}, http.StatusOK},
+		{url.Values{"max-staleness": {"1h"}}, http.StatusOK},
+	} {
+		if code, body := get(tc.query); code != tc.code {
+			t.Errorf("GET ?%s before replication returned %d %q, want %d", tc.query.Encode(), code, body, tc.code)
+		}
+	}
+
+	cancel := p.run()
+	defer cancel()
+	p.waitFor(t, "ck")
+
+	// Caught up: with the leader gone, reads the replica can vouch for are
+	// still served, strong reads fail.
+	cancel()
+	p.leader.Close()
+
+	for _, tc := range []struct {
+		query url.Values
+		code  int
+	}{
+		{url.Values{"consistency": {"session"}, "token": {token}}, http.StatusOK},
+		{url.Values{"consistency": {"bounded"}, "max-lag": {"0"}}, http.StatusOK},
+		{url.Values{"consistency": {"strong"}}, http.StatusBadGateway},
+		{url.Values{"consistency": {"session"}, "token": {"0:99"}}, http.StatusBadGateway},
+		{url.Values{"consistency": {"session"}, "token": {"3:1"}}, http.StatusBadGateway},
+	} {
+		if code, body := get(tc.query); code != tc.code {
+			t.Errorf("GET ?%s after replication returned %d %q, want %d", tc.query.Encode(), code, body, tc.code)
+		}
+	}
+
+	for _, query := range []url.Values{
+		{"consistency": {"linearizable"}},
+		{"consistency": {"bounded"}},
+		{"consistency": {"session"}, "token": {"nope"}},
+		{"max-lag": {"-1"}},
+	} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("GET ?%s returned %d, want 400", query.Encode(), code)
+		}
+	}
+}
+
+func TestSetHandlerReturnsWriteToken(t *testing.T) {
+	p := newReplicaPair(t)
+
+	for i, want := range []string{"0:1", "0:2"} {
+		resp, body := doRequest(t, http.MethodPost, p.replica.URL+"/set?key=tk&value=v", nil, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("/set %d returned %d: %s", i, resp.StatusCode, body)
+		}
+		if got := resp.Header.Get(web.WriteTokenHeader); got != want {
+			t.Errorf("/set %d returned token %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestStrongReadWithRaft(t *testing.T) {
+	g := newRaftGroup(t, 3)
+	leader := g.leader()
+	if resp, body := doRequest(t, http.MethodPut, g.urls[leader]+"/v1/keys/sk", []byte("v"), nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT returned %d: %s", resp.StatusCode, body)
+	}
+
+	for i, base := range g.urls {
+		resp, body := doRequest(t, http.MethodGet, base+"/v1/keys/sk?consistency=strong", nil, nil)
+		if resp.StatusCode != http.StatusOK || string(body) != "v" {
+			t.Errorf("strong read via member %d returned %d %q", i, resp.StatusCode, body)
+		}
+	}
+
+	// Cut off from the rest of the group, the leader cannot confirm that it
+	// still leads and must not serve strong reads.
+	for i := range g.urls {
+		if i != leader {
+			g.mu.Lock()
+			g.nodes[i].Stop()
+			g.mu.Unlock()
+			g.serve(i, nil)
+		}
+	}
+	resp, body := doRequest(t, http.MethodGet, g.urls[leader]+"/v1/keys/sk?consistency=strong", nil, nil)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("strong read from a cut off leader returned %d %q, want 503", resp.StatusCode, body)
+	}
+	resp, body = doRequest(t, http.MethodGet, g.urls[leader]+"/v1/keys/sk", nil, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "v" {
+		t.Errorf("eventual read from a cut off leader returned %d %q", resp.StatusCode, body)
+	}
+}
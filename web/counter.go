@@ -0,0 +1,128 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/raft"
+	"github.com/Sagor0078/distribKV/replication"
+)
+
+// maxIncrementRetries bounds how often an increment through Raft is retried
+// after a concurrent write to the counter.
+const maxIncrementRetries = 10
+
+// CounterResponse is the response body of POST /v1/incr/{key}.
+type CounterResponse struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+// IncrementHandler serves POST /v1/incr/{key}?delta=, atomically adding
+// delta (1 by default, negative to decrement) to the integer stored under
+// key and returning the new value. A missing key counts as 0. The request
+// is routed to the key's owner and the write is replicated like any other.
+// Incrementing a value that is not an integer is refused with 409 Conflict.
+func (s *Server) IncrementHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.PathValue("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	if db.IsReservedKey(key) {
+		http.Error(w, fmt.Sprintf("Invalid key: %v", db.ErrReservedKey), http.StatusBadRequest)
+		return
+	}
+	delta := int64(1)
+	if d := r.URL.Query().Get("delta"); d != "" {
+		var err error
+		if delta, err = strconv.ParseInt(d, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid delta %q", d), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !s.route(key, w, r) || !s.writable(w, r) {
+		return
+	}
+
+	var n int64
+	if s.raft != nil {
+		var ok bool
+		if n, ok = s.raftIncrement(w, r, key, delta); !ok {
+			return
+		}
+	} else {
+		var err error
+		if n, err = s.db.Increment(key, delta); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to increment key: %v", err), writeStatus(err))
+			return
+		}
+	}
+
+	s.setWriteToken(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CounterResponse{Key: key, Value: n})
+}
+
+// raftIncrement adds delta to the counter under key through Raft and
+// returns the new value. The leader computes the value and proposes it as a
+// write conditioned on the version it read, so every member stores the same
+// value however often it applies the entry. Increments on the leader take
+// turns; another write to the key in between fails the condition and the
+// increment is retried. It reports whether the
+// increment was committed here; otherwise the response has been written.
+func (s *Server) raftIncrement(w http.ResponseWriter, r *http.Request, key string, delta int64) (int64, bool) {
+	// Only the leader has the latest value to add to.
+	if !s.raft.IsLeader() {
+		return 0, s.proposed(w, r, "increment", &raft.NotLeaderError{Leader: s.raft.Leader()})
+	}
+
+	s.incrMu.Lock()
+	defer s.incrMu.Unlock()
+	for range maxIncrementRetries {
+		e, err := s.db.GetEntry(key)
+		cond := db.Condition{Absent: true}
+		switch {
+		case err == nil:
+			cond = db.Unchanged(e)
+		case !errors.Is(err, db.ErrNotFound):
+			http.Error(w, fmt.Sprintf("Failed to read counter: %v", err), http.StatusInternalServerError)
+			return 0, false
+		}
+		n, err := db.AddToCounter(key, e.Value, delta)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to increment key: %v", err), writeStatus(err))
+			return 0, false
+		}
+
+		cmd := replication.Command{Op: replication.OpSet, Key: key, Value: []byte(strconv.FormatInt(n, 10)), ExpiresAt: e.ExpiresAt, Cond: &cond}
+		data, err := cmd.Encode()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to encode command: %v", err), http.StatusInternalServerError)
+			return 0, false
+		}
+		_, err = s.raft.Propose(r.Context(), data)
+		if errors.Is(err, db.ErrConditionFailed) {
+			continue
+		}
+		return n, s.proposed(w, r, "increment", err)
+	}
+	http.Error(w, "Counter changed too often concurrently, try again", http.StatusConflict)
+	return 0, false
+}
+
+// isCounterError reports whether err is an increment of a value that is not
+// a counter, or that would overflow.
+func isCounterError(err error) bool {
+	return errors.Is(err, db.ErrNotInteger) || errors.Is(err, db.ErrOverflow)
+}
@@ -0,0 +1,104 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/web"
+)
+
+func increment(t *testing.T, url string) (int, web.CounterResponse) {
+	t.Helper()
+
+	resp, data := doRequest(t, http.MethodPost, url, nil, nil)
+	var res web.CounterResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatalf("invalid counter response %q: %v", data, err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func TestIncrementHandler(t *testing.T) {
+	urls := newRESTCluster(t, 2)
+	key := keyForShard(2, 1)
+
+	// Concurrent increments through both nodes all reach the owner.
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, _ := increment(t, urls[i%2]+"/v1/incr/"+key); status != http.StatusOK {
+				t.Errorf("increment returned %d", status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	status, res := increment(t, urls[0]+"/v1/incr/"+key+"?delta=-5")
+	if status != http.StatusOK || res.Value != 15 || res.Key != key {
+		t.Errorf("decrement returned %d %+v, want 15", status, res)
+	}
+	resp, body := doRequest(t, http.MethodGet, urls[0]+"/v1/keys/"+key, nil, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "15" {
+		t.Errorf("GET counter returned %d %q", resp.StatusCode, body)
+	}
+
+	doRequest(t, http.MethodPut, urls[0]+"/v1/keys/text", []byte("abc"), nil)
+	if status, _ := increment(t, urls[0]+"/v1/incr/text"); status != http.StatusConflict {
+		t.Errorf("increment of a non-integer returned %d, want 409", status)
+	}
+	if status, _ := increment(t, urls[0]+"/v1/incr/"+key+"?delta=x"); status != http.StatusBadRequest {
+		t.Errorf("increment with an invalid delta returned %d, want 400", status)
+	}
+	if resp, _ := doRequest(t, http.MethodGet, urls[0]+"/v1/incr/"+key, nil, nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /v1/incr returned %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestIncrementHandlerWithRaft(t *testing.T) {
+	g := newRaftGroup(t, 3)
+
+	waitForCounter := func(want string) {
+		t.Helper()
+		for i, database := range g.databases {
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				val, err := database.GetKey("hits")
+				if err == nil && string(val) == want {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("member %d has counter %q (%v), want %s", i, val, err, want)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	// Concurrent increments through every member are all counted once.
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, _ := increment(t, g.urls[i%3]+"/v1/incr/hits"); status != http.StatusOK {
+				t.Errorf("increment returned %d", status)
+			}
+		}()
+	}
+	wg.Wait()
+	waitForCounter("20")
+
+	// A restarted member does not add the increments again.
+	g.restart(t, (g.leader()+1)%3)
+	if status, res := increment(t, g.urls[g.leader()]+"/v1/incr/hits"); status != http.StatusOK || res.Value != 21 {
+		t.Fatalf("increment after restart returned %d: %+v", status, res)
+	}
+	waitForCounter("21")
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sagor0078/distribKV/balancer"
+)
+
+const (
+	// HopsHeader counts how many times a request has been forwarded
+	// between nodes.
+	HopsHeader = "X-Forward-Hops"
+
+	// maxForwardHops bounds forwarding chains. A request needs at most a
+	// hop to the owning shard and one to its Raft leader, plus one more
+	// while topologies are being reloaded.
+	maxForwardHops = 4
+
+	// defaultForwardTimeout bounds a forwarded request when no
+	// WithForwardTimeout option is given.
+	defaultForwardTimeout = 10 * time.Second
+)
+
+// hopHeaders are connection-specific and must not be passed on by a proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// WithForwardTimeout bounds each request forwarded to another node.
+func WithForwardTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.forwardTimeout = d
+	}
+}
+
+// WithRedirects makes the server answer requests for keys owned by another
+// node with 307 Temporary Redirect instead of proxying them.
+func WithRedirects() Option {
+	return func(s *Server) {
+		s.redirects = true
+	}
+}
+
+// newHTTPClient returns the pooled client used for node-to-node requests.
+// Timeouts are set per request through contexts.
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          256,
+			MaxIdleConnsPerHost:   64,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		// Redirects are answered by the caller of forward, not followed.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// forward proxies a request to the node at addr, or redirects the client
+// there when the server was created WithRedirects. The method, body and
+// end-to-end headers are preserved, and the request is tagged with this
+// node's topology epoch and hop count.
+func (s *Server) forward(addr string, w http.ResponseWriter, r *http.Request) {
+	target := "http://" + addr + r.RequestURI
+	epoch := s.topology().Epoch
+
+	if s.redirects {
+		w.Header().Set("Location", target)
+		w.Header().Set(EpochHeader, strconv.FormatUint(epoch, 10))
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	hops, err := requestHops(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hops >= maxForwardHops {
+		http.Error(w, fmt.Sprintf("Forwarding loop: request already forwarded %d times", hops), http.StatusLoopDetected)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.forwardTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, target, r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error building redirect request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	req.ContentLength = r.ContentLength
+	req.Header = r.Header.Clone()
+	removeHopHeaders(req.Header)
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+	req.Header.Set(EpochHeader, strconv.FormatUint(epoch, 10))
+	req.Header.Set(HopsHeader, strconv.Itoa(hops+1))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		status := http.StatusBadGateway
+		if ctx.Err() == context.DeadlineExceeded {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, fmt.Sprintf("Error redirecting request: %v", err), status)
+		return
+	}
+	defer resp.Body.Close()
+
+	// The owner runs a newer topology: pick it up for later requests.
+	if theirs, err := strconv.ParseUint(resp.Header.Get(EpochHeader), 10, 64); err == nil && theirs > epoch {
+		go s.tryReload()
+	}
+
+	removeHopHeaders(resp.Header)
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// requestHops returns how many times r has already been forwarded.
+func requestHops(r *http.Request) (int, error) {
+	v := r.Header.Get(HopsHeader)
+	if v == "" {
+		return 0, nil
+	}
+	hops, err := strconv.Atoi(v)
+	if err != nil || hops < 0 {
+		return 0, fmt.Errorf("Invalid %s: %q", HopsHeader, v)
+	}
+	return hops, nil
+}
+
+// WithBalancer spreads reads for keys owned by other shards over the
+// shards' leaders and replicas using b. The server keeps b's topology up to
+// date; running its health checks is up to the caller.
+func WithBalancer(b *balancer.Balancer) Option {
+	return func(s *Server) {
+		s.balancer = b
+	}
+}
+
+// forwardTo forwards a request to a balanced backend, recording its
+// latency and whether the backend failed to answer.
+func (s *Server) forwardTo(be *balancer.Backend, w http.ResponseWriter, r *http.Request) {
+	done := be.Start()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.forward(be.Addr, rec, r)
+
+	var err error
+	switch rec.status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		err = fmt.Errorf("%s answered %d", be.Addr, rec.status)
+	}
+	done(err)
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// removeHopHeaders deletes hop-by-hop headers, including any named in the
+// Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
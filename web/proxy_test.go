@@ -0,0 +1,237 @@
+package web_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/balancer"
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// keyForShard returns a key that the modulo partitioner assigns to shard.
+func keyForShard(count, shard int) string {
+	routing := &config.Shards{Count: count}
+	for i := 0; ; i++ {
+		if key := fmt.Sprintf("key-%d", i); routing.Index(key) == shard {
+			return key
+		}
+	}
+}
+
+func TestForwardPreservesRequest(t *testing.T) {
+	type seen struct {
+		method string
+		body   string
+		header http.Header
+	}
+	got := make(chan seen, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- seen{r.Method, string(body), r.Header.Clone()}
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	server := web.NewServer(createTempDB(t, 0), &config.Shards{
+		Count:  2,
+		CurIdx: 0,
+		Addrs:  map[int]string{0: "127.0.0.1:1", 1: strings.TrimPrefix(upstream.URL, "http://")},
+	})
+	ts := httptest.NewServer(http.HandlerFunc(server.SetHandler))
+	defer ts.Close()
+
+	body := fmt.Sprintf(`{"key": %q, "value": "v"}`, keyForShard(2, 1))
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/set", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("Connection", "X-Private")
+	req.Header.Set("X-Private", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Upstream") != "yes" {
+		t.Errorf("forwarded response is %d with headers %v", resp.StatusCode, resp.Header)
+	}
+
+	s := <-got
+	if s.method != http.MethodPost || s.body != body {
+		t.Errorf("upstream got %s %q, want POST %q", s.method, s.body, body)
+	}
+	if s.header.Get("X-Request-Id") != "abc" || s.header.Get("Content-Type") != "application/json" {
+		t.Errorf("end-to-end headers were not forwarded: %v", s.header)
+	}
+	if s.header.Get("X-Private") != "" {
+		t.Errorf("hop-by-hop header was forwarded: %v", s.header)
+	}
+	if s.header.Get(web.HopsHeader) != "1" || s.header.Get("X-Forwarded-For") == "" {
+		t.Errorf("forwarding headers missing: %v", s.header)
+	}
+}
+
+func TestSetHandlerJSONBody(t *testing.T) {
+	urls := newCluster(t, 2, func(server *web.Server, mux *http.ServeMux) {
+		mux.HandleFunc("/set", server.SetHandler)
+		mux.HandleFunc("/get", server.GetHandler)
+	})
+
+	// Posted, as populate.sh does, to the shard that does not own the key.
+	key := keyForShard(2, 1)
+	resp, body := doRequest(t, http.MethodPost, urls[0]+"/set",
+		[]byte(fmt.Sprintf(`{"key": %q, "value": "from-json"}`, key)),
+		http.Header{"Content-Type": {"application/json"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("JSON /set returned %d: %s", resp.StatusCode, body)
+	}
+
+	// Form bodies survive forwarding too.
+	resp, body = doRequest(t, http.MethodPost, urls[0]+"/set",
+		[]byte("key=form-"+key+"&value=from-form"),
+		http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("form /set returned %d: %s", resp.StatusCode, body)
+	}
+
+	for k, want := range map[string]string{key: "from-json", "form-" + key: "from-form"} {
+		resp, body = doRequest(t, http.MethodGet, urls[1]+"/get?key="+k, nil, nil)
+		if string(body) != "Value: "+want {
+			t.Errorf("GET %s returned %d %q, want %q", k, resp.StatusCode, body, want)
+		}
+	}
+}
+
+func TestForwardLoopDetected(t *testing.T) {
+	n := startNodes(t, 2)
+	addrs := n.addrs
+
+	// Each node believes the other one owns shard 1.
+	for i := range addrs {
+		server := web.NewServer(createTempDB(t, i), &config.Shards{
+			Count:  2,
+			CurIdx: 0,
+			Addrs:  map[int]string{0: addrs[i], 1: addrs[1-i]},
+		})
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+		n.serve(i, mux)
+	}
+
+	resp, body := doRequest(t, http.MethodGet, n.urls[0]+"/v1/keys/"+keyForShard(2, 1), nil, nil)
+	if resp.StatusCode != http.StatusLoopDetected {
+		t.Errorf("looping request returned %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestForwardTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	server := web.NewServer(createTempDB(t, 0), &config.Shards{
+		Count:  2,
+		CurIdx: 0,
+		Addrs:  map[int]string{0: "127.0.0.1:1", 1: strings.TrimPrefix(upstream.URL, "http://")},
+	}, web.WithForwardTimeout(20*time.Millisecond))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/keys/"+keyForShard(2, 1), nil)
+	req.SetPathValue("key", keyForShard(2, 1))
+	w := httptest.NewRecorder()
+	server.KeyHandler(w, req)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("slow upstream returned %d, want 504", w.Code)
+	}
+}
+
+func TestRedirectMode(t *testing.T) {
+	urls := newRESTCluster(t, 2)
+	key := keyForShard(2, 1)
+	resp, _ := doRequest(t, http.MethodPut, urls[1]+"/v1/keys/"+key, []byte("redirected"), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT returned %d", resp.StatusCode)
+	}
+
+	server := web.NewServer(createTempDB(t, 0), &config.Shards{
+		Count:  2,
+		CurIdx: 0,
+		Addrs: map[int]string{
+			0: "127.0.0.1:1",
+			1: strings.TrimPrefix(urls[1], "http://"),
+		},
+	}, web.WithRedirects())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noFollow.Get(ts.URL + "/v1/keys/" + key)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("GET returned %d, want 307", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != urls[1]+"/v1/keys/"+key {
+		t.Errorf("Location is %q", loc)
+	}
+
+	// Clients that follow redirects end up at the owner.
+	resp, body := doRequest(t, http.MethodGet, ts.URL+"/v1/keys/"+key, nil, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "redirected" {
+		t.Errorf("followed GET returned %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestBalancedReads(t *testing.T) {
+	hits := make(chan string, 16)
+	node := func(name string) string {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits <- name + " " + r.Method
+		}))
+		t.Cleanup(ts.Close)
+		return strings.TrimPrefix(ts.URL, "http://")
+	}
+	shards := &config.Shards{
+		Count:    2,
+		CurIdx:   0,
+		Addrs:    map[int]string{0: "127.0.0.1:1", 1: node("leader")},
+		Replicas: map[int][]string{1: {node("replica")}},
+	}
+	server := web.NewServer(createTempDB(t, 0), shards,
+		web.WithBalancer(balancer.New(balancer.NewRoundRobin(), shards)))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	key := ts.URL + "/v1/keys/" + keyForShard(2, 1)
+	for _, tc := range []struct {
+		method, url, want string
+	}{
+		{http.MethodGet, key, "leader GET"},
+		{http.MethodGet, key, "replica GET"},
+		{http.MethodPut, key, "leader PUT"},
+		{http.MethodDelete, key, "leader DELETE"},
+		{http.MethodGet, key + "?consistency=strong", "leader GET"},
+		{http.MethodGet, key, "leader GET"},
+		{http.MethodGet, key, "replica GET"},
+	} {
+		doRequest(t, tc.method, tc.url, nil, nil)
+		if got := <-hits; got != tc.want {
+			t.Errorf("%s %s reached %q, want %q", tc.method, tc.url, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/Sagor0078/distribKV/client"
+	"github.com/Sagor0078/distribKV/replication"
+)
+
+// WithReplica makes the server a read-only replica that follows the leader
+// through c. Client writes are forwarded (or redirected) to the leader;
+// the replica's data only changes through the replication stream. Reads
+// are served locally unless they ask for a stronger consistency level than
+// the replica can provide, see readable.
+func WithReplica(c *replication.Client) Option {
+	return func(s *Server) {
+		s.replica = c
+	}
+}
+
+// writable reports whether this node accepts writes. On a replica the
+// request has been sent to the shard leader instead and the caller must
+// return.
+func (s *Server) writable(w http.ResponseWriter, r *http.Request) bool {
+	if s.replica == nil {
+		return true
+	}
+	log.Printf("Forwarding write on replica to leader %s", s.replica.LeaderAddr())
+	s.forward(s.replica.LeaderAddr(), w, r)
+	return false
+}
+
+// Replication roles reported in ReplicationStatus.
+const (
+	RoleLeader       = client.RoleLeader
+	RoleReplica      = client.RoleReplica
+	RoleRaftLeader   = client.RoleRaftLeader
+	RoleRaftFollower = client.RoleRaftFollower
+)
+
+// ReplicationStatus is a node's view of replication within its shard. It is
+// defined in the client package, which decodes it.
+type ReplicationStatus = client.ReplicationStatus
+
+// ReplicationStatusHandler serves GET /v1/admin/replication, this node's
+// ReplicationStatus.
+func (s *Server) ReplicationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st, err := s.replicationStatus()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read replication status: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// replicationStatus returns this node's ReplicationStatus.
+func (s *Server) replicationStatus() (ReplicationStatus, error) {
+	shards := s.topology()
+	st := ReplicationStatus{
+		Shard:    shards.CurIdx,
+		Epoch:    shards.Epoch,
+		Position: s.appliedPosition(),
+	}
+	switch {
+	case s.raft != nil:
+		st.Role = RoleRaftFollower
+		if s.raft.IsLeader() {
+			st.Role = RoleRaftLeader
+		} else {
+			st.Leader = s.raft.Leader()
+		}
+	case s.replica != nil:
+		st.Role = RoleReplica
+		st.Leader = s.replica.LeaderAddr()
+		if lag := s.replica.Lag(); lag != math.MaxUint64 {
+			st.Synced, st.Lag, st.Staleness = true, lag, s.replica.Staleness()
+		}
+		pulls := s.replica.PullStats()
+		st.Pulls = &pulls
+	default:
+		st.Role = RoleLeader
+		acks, err := s.db.ReplicationAcks()
+		if err != nil {
+			return st, fmt.Errorf("failed to read replication acks: %w", err)
+		}
+		trimmed, err := s.db.TrimmedSeq()
+		if err != nil {
+			return st, fmt.Errorf("failed to read replication log position: %w", err)
+		}
+		st.Acks = acks
+		st.Pending = make(map[string]uint64)
+		for _, replica := range shards.GetReplicas(shards.CurIdx) {
+			st.Pending[replica] = st.Position
+		}
+		for replica, acked := range acks {
+			st.Pending[replica] = 0
+			if acked < st.Position {
+				st.Pending[replica] = st.Position - acked
+			}
+		}
+		if trimmed < st.Position {
+			st.Backlog = st.Position - trimmed
+		}
+	}
+	return st, nil
+}
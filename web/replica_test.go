@@ -0,0 +1,311 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/replication"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+func createReplicaDB(t *testing.T) *db.Database {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "replica")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	database, closer, err := db.NewDatabase(dir, true)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := closer(); err != nil {
+			t.Errorf("Cleanup error: %v", err)
+		}
+	})
+	return database
+}
+
+// replicaPair is a single-shard leader with one replica following it.
+type replicaPair struct {
+	leaderDB  *db.Database
+	leader    *httptest.Server
+	replicaDB *db.Database
+	client    *replication.Client
+	replica   *httptest.Server
+}
+
+// newReplicaPair starts a leader and a replica. Replication is not started;
+// call run to have the replica follow the leader.
+func newReplicaPair(t *testing.T) *replicaPair {
+	t.Helper()
+
+	p := &replicaPair{}
+	var leaderServer *web.Server
+	p.leaderDB, leaderServer = createTestServer(t, 0, map[int]string{0: "placeholder"})
+	leaderMux := http.NewServeMux()
+	leaderMux.HandleFunc("/v1/keys/{key...}", leaderServer.KeyHandler)
+	leaderMux.HandleFunc("/set", leaderServer.SetHandler)
+	leaderMux.HandleFunc("/v1/batch", leaderServer.BatchHandler)
+	leaderMux.HandleFunc("/replication-stream", leaderServer.ReplicationStreamHandler)
+	leaderMux.HandleFunc("/replication-ack", leaderServer.ReplicationAckHandler)
+	leaderMux.HandleFunc("/v1/admin/replication", leaderServer.ReplicationStatusHandler)
+	leaderMux.HandleFunc("/v1/internal/snapshot", leaderServer.SnapshotHandler)
+	leaderMux.HandleFunc("/v1/internal/merkle", leaderServer.MerkleHandler)
+	leaderMux.HandleFunc("/v1/internal/merkle/entries", leaderServer.MerkleEntriesHandler)
+	p.leader = httptest.NewServer(leaderMux)
+	t.Cleanup(p.leader.Close)
+	leaderAddr := strings.TrimPrefix(p.leader.URL, "http://")
+
+	p.replicaDB = createReplicaDB(t)
+	var err error
+	p.client, err = replication.NewClient(p.replicaDB, leaderAddr, "replica-1")
+	if err != nil {
+		t.Fatalf("failed to create replication client: %v", err)
+	}
+
+	replicaServer := web.NewServer(p.replicaDB, &config.Shards{
+		Count:  1,
+		CurIdx: 0,
+		Addrs:  map[int]string{0: leaderAddr},
+	}, web.WithReplica(p.client))
+	replicaMux := http.NewServeMux()
+	replicaMux.HandleFunc("/v1/keys/{key...}", replicaServer.KeyHandler)
+	replicaMux.HandleFunc("/set", replicaServer.SetHandler)
+	replicaMux.HandleFunc("/get", replicaServer.GetHandler)
+	replicaMux.HandleFunc("/v1/batch", replicaServer.BatchHandler)
+	replicaMux.HandleFunc("/v1/admin/replication", replicaServer.ReplicationStatusHandler)
+	replicaMux.HandleFunc("/v1/admin/anti-entropy", replicaServer.AntiEntropyHandler)
+	p.replica = httptest.NewServer(replicaMux)
+	t.Cleanup(p.replica.Close)
+	return p
+}
+
+// run starts replication and returns a function that stops it.
+func (p *replicaPair) run() context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	go p.client.Run(ctx)
+	return cancel
+}
+
+// waitFor waits until the replica has applied the key.
+func (p *replicaPair) waitFor(t *testing.T, key string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := p.replicaDB.GetKey(key); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica did not catch up with %s", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplicaForwardsWritesAndServesReads(t *testing.T) {
+	p := newReplicaPair(t)
+	leaderDB, replicaDB, leader, replica := p.leaderDB, p.replicaDB, p.leader, p.replica
+	cancel := p.run()
+	defer cancel()
+
+	// Writes sent to the replica end up on the leader.
+	resp, _ := doRequest(t, http.MethodPut, replica.URL+"/v1/keys/rk", []byte("v1"), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT on replica returned %d", resp.StatusCode)
+	}
+	resp, body := doRequest(t, http.MethodPost, replica.URL+"/set?key=sk&value=v2", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/set on replica returned %d: %s", resp.StatusCode, body)
+	}
+	for _, key := range []string{"rk", "sk"} {
+		if _, err := leaderDB.GetKey(key); err != nil {
+			t.Errorf("write of %s did not reach the leader: %v", key, err)
+		}
+	}
+
+	// The replica's own store rejects client writes.
+	if err := replicaDB.SetKey("direct", []byte("x")); err != db.ErrReadOnly {
+		t.Errorf("SetKey on replica returned %v, want ErrReadOnly", err)
+	}
+
+	p.waitFor(t, "sk")
+
+	// Once the leader is gone, reads are still served locally, except those
+	// that need fresher data than the replica can vouch for.
+	cancel()
+	leader.Close()
+
+	resp, body = doRequest(t, http.MethodGet, replica.URL+"/v1/keys/rk?max-staleness=1h", nil, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "v1" {
+		t.Errorf("local read returned %d %q", resp.StatusCode, body)
+	}
+	resp, body = doRequest(t, http.MethodGet, replica.URL+"/get?key=sk", nil, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "Value: v2" {
+		t.Errorf("local /get returned %d %q", resp.StatusCode, body)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	resp, _ = doRequest(t, http.MethodGet, replica.URL+"/v1/keys/rk?max-staleness=1ms", nil, nil)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("read with a tight staleness bound returned %d, want 502 from the dead leader", resp.StatusCode)
+	}
+
+	resp, _ = doRequest(t, http.MethodGet, replica.URL+"/v1/keys/rk?max-staleness=soon", nil, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid max-staleness returned %d, want 400", resp.StatusCode)
+	}
+}
+
+func replicationStatus(t *testing.T, base string) web.ReplicationStatus {
+	t.Helper()
+
+	resp, body := doRequest(t, http.MethodGet, base+"/v1/admin/replication", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("replication status returned %d: %s", resp.StatusCode, body)
+	}
+	var st web.ReplicationStatus
+	if err := json.Unmarshal(body, &st); err != nil {
+		t.Fatalf("invalid replication status %q: %v", body, err)
+	}
+	return st
+}
+
+func TestReplicationStatus(t *testing.T) {
+	p := newReplicaPair(t)
+
+	if st := replicationStatus(t, p.replica.URL); st.Role != web.RoleReplica || st.Synced {
+		t.Errorf("replica status before replication: %+v", st)
+	}
+
+	doRequest(t, http.MethodPut, p.leader.URL+"/v1/keys/st", []byte("v"), nil)
+	cancel := p.run()
+	defer cancel()
+	p.waitFor(t, "st")
+
+	st := replicationStatus(t, p.replica.URL)
+	if st.Role != web.RoleReplica || !st.Synced || st.Position != 1 || st.Leader != strings.TrimPrefix(p.leader.URL, "http://") {
+		t.Errorf("replica status after replication: %+v", st)
+	}
+	if st.Pulls == nil || st.Pulls.LastPull.IsZero() || st.Pulls.ConsecutiveErrors != 0 {
+		t.Errorf("replica pull stats after replication: %+v", st.Pulls)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st = replicationStatus(t, p.leader.URL)
+		if st.Acks["replica-1"] == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("leader status never showed the replica's ack: %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.Role != web.RoleLeader || st.Position != 1 || st.Pending["replica-1"] != 0 || st.Backlog != 1 {
+		t.Errorf("leader status: %+v", st)
+	}
+	cancel()
+}
+
+func TestReplicationStatusReportsPullErrors(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	downAddr := strings.TrimPrefix(down.URL, "http://")
+	down.Close()
+
+	client, err := replication.NewClient(createReplicaDB(t), downAddr, "replica-1")
+	if err != nil {
+		t.Fatalf("failed to create replication client: %v", err)
+	}
+	server := web.NewServer(createReplicaDB(t), &config.Shards{
+		Count:  1,
+		CurIdx: 0,
+		Addrs:  map[int]string{0: downAddr},
+	}, web.WithReplica(client))
+	ts := httptest.NewServer(http.HandlerFunc(server.ReplicationStatusHandler))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, body := doRequest(t, http.MethodGet, ts.URL, nil, nil)
+		var st web.ReplicationStatus
+		if err := json.Unmarshal(body, &st); err != nil {
+			t.Fatalf("invalid replication status %d %q: %v", resp.StatusCode, body, err)
+		}
+		if p := st.Pulls; p != nil && p.Errors > 0 {
+			if p.ConsecutiveErrors == 0 || p.LastError == "" || p.LastErrorAt.IsZero() || !p.LastPull.IsZero() || st.Synced {
+				t.Errorf("replica status with an unreachable leader: %+v, pulls %+v", st, p)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica never reported a pull error: %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplicaBootstrapsFromSnapshot(t *testing.T) {
+	p := newReplicaPair(t)
+	leaderAddr := strings.TrimPrefix(p.leader.URL, "http://")
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := p.leaderDB.SetKey(key, []byte("v-"+key)); err != nil {
+			t.Fatalf("SetKey(%q) failed: %v", key, err)
+		}
+	}
+	// A replica starting from scratch can no longer replay the log.
+	if err := p.leaderDB.TrimReplicationLog(p.leaderDB.LastSeq()); err != nil {
+		t.Fatalf("TrimReplicationLog failed: %v", err)
+	}
+
+	replicaDB := createReplicaDB(t)
+	seq, err := replication.Bootstrap(context.Background(), replicaDB, leaderAddr)
+	if err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	if seq != p.leaderDB.LastSeq() {
+		t.Errorf("Bootstrap returned position %d, want %d", seq, p.leaderDB.LastSeq())
+	}
+
+	client, err := replication.NewClient(replicaDB, leaderAddr, "replica-2")
+	if err != nil {
+		t.Fatalf("failed to create replication client: %v", err)
+	}
+	if client.Applied() != seq {
+		t.Errorf("client resumes after %d, want %d", client.Applied(), seq)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := p.leaderDB.SetKey("d", []byte("v-d")); err != nil {
+		t.Fatalf("SetKey failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Applied() <= seq {
+		if time.Now().After(deadline) {
+			t.Fatal("replica did not resume replication after the snapshot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if val, err := replicaDB.GetKey(key); err != nil || string(val) != "v-"+key {
+			t.Errorf("replica has %q = %q, %v", key, val, err)
+		}
+	}
+}
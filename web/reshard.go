@@ -0,0 +1,499 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/raft"
+	"github.com/Sagor0078/distribKV/replication"
+)
+
+// migrateBatch is the number of keys copied to a new owner per request.
+const migrateBatch = 500
+
+// migration tracks an online reshard. While it is active, requests are
+// routed by the new topology and keys missing on their new owner are read
+// from the owner under the previous topology.
+type migration struct {
+	previous *config.Shards
+
+	mu     sync.Mutex
+	status MigrationStatus
+
+	// dropped holds the keys deleted here during the reshard. Copies of
+	// them from their previous owner, read before the delete, are not
+	// stored.
+	copyMu  sync.Mutex
+	dropped map[string]bool
+}
+
+// MigrationStatus reports the progress of a reshard on this node.
+type MigrationStatus struct {
+	// State is "copying", "copied" or "failed".
+	State    string    `json:"state"`
+	Moved    int       `json:"moved"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (m *migration) snapshot() MigrationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+func (m *migration) update(fn func(*MigrationStatus)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.status)
+}
+
+// previousOwner returns the address that owned key before the reshard, if
+// a reshard is in progress and that owner is another shard.
+func (s *Server) previousOwner(key string) (string, bool) {
+	s.topoMu.RLock()
+	m, cur := s.migration, s.shards
+	s.topoMu.RUnlock()
+	if m == nil {
+		return "", false
+	}
+
+	addr := m.previous.Addrs[m.previous.Index(key)]
+	if addr == cur.Addrs[cur.CurIdx] {
+		return "", false
+	}
+	return addr, true
+}
+
+// readKey reads a key owned by this shard. During a reshard, a key that has
+// not been copied here yet is read from its previous owner, without its
+// version or expiry.
+func (s *Server) readKey(ctx context.Context, key string) (db.Entry, error) {
+	e, err := s.db.GetEntry(key)
+	if !errors.Is(err, db.ErrNotFound) {
+		return e, err
+	}
+
+	addr, ok := s.previousOwner(key)
+	if !ok {
+		return e, err
+	}
+	e.Value, err = s.migrationRequest(ctx, http.MethodGet, addr, key)
+	return e, err
+}
+
+// deletePrevious removes key, just deleted here, from its previous owner
+// during a reshard, so the background copy cannot bring it back.
+func (s *Server) deletePrevious(ctx context.Context, key string) error {
+	addr, ok := s.previousOwner(key)
+	if !ok {
+		return nil
+	}
+	if err := s.dropCopies(ctx, key); err != nil {
+		return err
+	}
+	_, err := s.migrationRequest(ctx, http.MethodDelete, addr, key)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// dropCopies refuses copies of key from its previous owner for the rest of
+// the reshard, and deletes a copy stored since key was deleted here.
+func (s *Server) dropCopies(ctx context.Context, key string) error {
+	s.topoMu.RLock()
+	m := s.migration
+	s.topoMu.RUnlock()
+	if m == nil {
+		return nil
+	}
+
+	m.copyMu.Lock()
+	defer m.copyMu.Unlock()
+	if m.dropped[key] {
+		return nil
+	}
+	m.dropped[key] = true
+	return s.deleteLocal(ctx, key, db.Condition{})
+}
+
+// storeCopies stores keys copied from their previous owner, skipping keys
+// that exist or were deleted here during the reshard, through Raft when it
+// is enabled. It returns how many keys were written.
+func (s *Server) storeCopies(ctx context.Context, kvs []db.KV) (int, error) {
+	s.topoMu.RLock()
+	m := s.migration
+	s.topoMu.RUnlock()
+	if m != nil {
+		m.copyMu.Lock()
+		defer m.copyMu.Unlock()
+		kept := kvs[:0]
+		for _, kv := range kvs {
+			if !m.dropped[kv.Key] {
+				kept = append(kept, kv)
+			}
+		}
+		kvs = kept
+	}
+
+	if s.raft == nil {
+		return s.db.SetKeysIfAbsent(kvs)
+	}
+	data, err := replication.Command{Op: replication.OpSetIfAbsent, KVs: kvs}.Encode()
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.raft.Propose(ctx, data)
+	if err != nil {
+		return 0, err
+	}
+	written, ok := result.(int)
+	if !ok {
+		return 0, errors.New("copy applied without a count")
+	}
+	return written, nil
+}
+
+// deleteLocal deletes key from this shard if cond holds, through Raft when
+// it is enabled.
+func (s *Server) deleteLocal(ctx context.Context, key string, cond db.Condition) error {
+	if s.raft == nil {
+		return s.db.DeleteKeyIf(key, cond)
+	}
+	data, err := replication.Command{Op: replication.OpDelete, Key: key, Cond: conditional(cond)}.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = s.raft.Propose(ctx, data)
+	return err
+}
+
+// migrationRequest reads or deletes key directly in the store of the node
+// at addr, bypassing its routing.
+func (s *Server) migrationRequest(ctx context.Context, method, addr, key string) ([]byte, error) {
+	u := "http://" + addr + "/v1/internal/migrate?" + url.Values{"key": {key}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return data, nil
+	case http.StatusNotFound:
+		return nil, db.ErrNotFound
+	default:
+		return nil, fmt.Errorf("%s returned %d: %s", addr, resp.StatusCode, bytes.TrimSpace(data))
+	}
+}
+
+// MigrateHandler is the node-to-node endpoint used while resharding. It
+// accesses the local store without routing:
+//
+//	GET    ?key=  returns the local value (404 if missing)
+//	DELETE ?key=  deletes the local value
+//	POST          stores a JSON list of db.KV, skipping keys that exist
+//
+// Under Raft, deletes and copies are committed through the shard group's
+// log; followers forward them to the leader.
+func (s *Server) MigrateHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	if (r.Method == http.MethodDelete || r.Method == http.MethodPost) && s.raft != nil && !s.raft.IsLeader() {
+		s.proposed(w, r, "migration", &raft.NotLeaderError{Leader: s.raft.Leader()})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		val, err := s.db.GetKey(key)
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(val)
+
+	case http.MethodDelete:
+		if err := s.deleteLocal(r.Context(), key, db.Condition{}); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete key: %v", err), writeStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		var kvs []db.KV
+		if err := json.NewDecoder(r.Body).Decode(&kvs); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid batch: %v", err), http.StatusBadRequest)
+			return
+		}
+		written, err := s.storeCopies(r.Context(), kvs)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to store batch: %v", err), writeStatus(err))
+			return
+		}
+		fmt.Fprintf(w, "%d", written)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ReshardHandler drives online resharding on this node.
+//
+//	POST ?config-file=new.toml[&previous-config-file=old.toml]
+//	     switches routing to the new topology and starts copying keys this
+//	     node no longer owns to their new owners, deleting each batch here
+//	     once the new owner has stored it. The previous topology defaults to
+//	     the one the node is running; nodes that were started with the new
+//	     config must be given the old one explicitly. Both files are named
+//	     relative to the directory of the node's config file.
+//	GET  reports the progress of the copy.
+//	DELETE ends the reshard, disabling reads from previous owners. Call it
+//	     once every node reports "copied".
+func (s *Server) ReshardHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.topoMu.RLock()
+		m := s.migration
+		s.topoMu.RUnlock()
+		if m == nil {
+			http.Error(w, "No reshard in progress", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.snapshot())
+
+	case http.MethodPost:
+		s.startReshard(w, r)
+
+	case http.MethodDelete:
+		s.topoMu.Lock()
+		m := s.migration
+		if m != nil && m.snapshot().State == "copying" {
+			s.topoMu.Unlock()
+			http.Error(w, "Copy still in progress", http.StatusConflict)
+			return
+		}
+		s.migration = nil
+		s.topoMu.Unlock()
+		fmt.Fprint(w, "Reshard finished")
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) startReshard(w http.ResponseWriter, r *http.Request) {
+	cur := s.topology()
+
+	file, err := s.configFile(r.URL.Query().Get("config-file"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := loadTopology(file, cur.CurName)
+	if err != nil {
+		log.Printf("Failed to load reshard topology: %v", err)
+		http.Error(w, "Failed to load the config file", http.StatusBadRequest)
+		return
+	}
+
+	if err := checkReplace(cur, target); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	previous := cur
+	if name := r.URL.Query().Get("previous-config-file"); name != "" {
+		if file, err = s.configFile(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if previous, err = loadTopology(file, ""); err != nil {
+			log.Printf("Failed to load previous topology: %v", err)
+			http.Error(w, "Failed to load the previous config file", http.StatusBadRequest)
+			return
+		}
+	}
+
+	m := &migration{
+		previous: previous,
+		status:   MigrationStatus{State: "copying", Started: time.Now()},
+		dropped:  make(map[string]bool),
+	}
+
+	s.topoMu.Lock()
+	if s.migration != nil && s.migration.snapshot().State == "copying" {
+		s.topoMu.Unlock()
+		http.Error(w, "Reshard already in progress", http.StatusConflict)
+		return
+	}
+	s.setTopologyLocked(target)
+	s.migration = m
+	s.topoMu.Unlock()
+
+	log.Printf("Resharding: %d → %d shards, this node is shard %d", previous.Count, target.Count, target.CurIdx)
+	go s.copyMovedKeys(target, m)
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Reshard started: %d shards, this node is shard %d", target.Count, target.CurIdx)
+}
+
+// loadTopology parses a sharding config. With an empty name the topology is
+// only used for routing and has no current shard.
+func loadTopology(file, name string) (*config.Shards, error) {
+	if file == "" {
+		return nil, errors.New("missing config-file")
+	}
+	shards, err := config.LoadShards(file, name)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config %q: %v", file, err)
+	}
+	return shards, nil
+}
+
+// copyMovedKeys sends every local key owned by another shard under target
+// to that shard, then deletes it locally unless it changed in between.
+// Under Raft only the leader copies; the followers apply its deletes.
+func (s *Server) copyMovedKeys(target *config.Shards, m *migration) {
+	var err error
+	if s.raft == nil || s.raft.IsLeader() {
+		err = s.copyMovedKeysErr(target, m)
+	}
+	m.update(func(st *MigrationStatus) {
+		st.Finished = time.Now()
+		if err != nil {
+			st.State = "failed"
+			st.Error = err.Error()
+		} else {
+			st.State = "copied"
+		}
+	})
+	if err != nil {
+		log.Printf("Resharding failed: %v", err)
+		return
+	}
+	log.Printf("Resharding: finished copying keys to their new owners")
+}
+
+func (s *Server) copyMovedKeysErr(target *config.Shards, m *migration) error {
+	start := ""
+	for {
+		kvs, next, err := s.db.Scan(start, "", migrateBatch)
+		if err != nil {
+			return err
+		}
+
+		batches := make(map[int][]db.KV)
+		for _, kv := range kvs {
+			if idx := target.Index(kv.Key); idx != target.CurIdx {
+				batches[idx] = append(batches[idx], kv)
+			}
+		}
+
+		for idx, batch := range batches {
+			// Each key is copied as it is now and deleted here only if it
+			// is still at the version that was copied.
+			copies := make([]db.KV, 0, len(batch))
+			conds := make([]db.Condition, 0, len(batch))
+			for _, kv := range batch {
+				e, err := s.db.GetEntry(kv.Key)
+				if errors.Is(err, db.ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("reading moved key %q: %w", kv.Key, err)
+				}
+				copies = append(copies, db.KV{Key: kv.Key, Value: e.Value, ExpiresAt: e.ExpiresAt})
+				conds = append(conds, db.Unchanged(e))
+			}
+			if len(copies) == 0 {
+				continue
+			}
+
+			if err := s.sendMigrationBatch(target.Addrs[idx], copies); err != nil {
+				return fmt.Errorf("copying to shard %d: %w", idx, err)
+			}
+			for i, kv := range copies {
+				err := s.deleteMoved(kv.Key, conds[i])
+				// The new owner deleted the key since it was read, and
+				// refuses the copy.
+				if errors.Is(err, db.ErrConditionFailed) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("deleting moved key %q: %w", kv.Key, err)
+				}
+			}
+			m.update(func(st *MigrationStatus) { st.Moved += len(copies) })
+		}
+
+		if next == "" {
+			return nil
+		}
+		start = next
+	}
+}
+
+// deleteMoved deletes a key copied to its new owner if cond holds.
+func (s *Server) deleteMoved(key string, cond db.Condition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.forwardTimeout)
+	defer cancel()
+	return s.deleteLocal(ctx, key, cond)
+}
+
+func (s *Server) sendMigrationBatch(addr string, kvs []db.KV) error {
+	body, err := json.Marshal(kvs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.forwardTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+"/v1/internal/migrate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned %d: %s", addr, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
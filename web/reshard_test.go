@@ -0,0 +1,222 @@
+package web_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// writeTopologyTOML writes a config with one shard named shard-i per address.
+func writeTopologyTOML(t *testing.T, path string, epoch uint64, addrs []string) {
+	t.Helper()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "epoch = %d\n\n", epoch)
+	for i, addr := range addrs {
+		fmt.Fprintf(&b, "[[shards]]\nname = \"shard-%d\"\nidx = %d\naddress = %q\n\n", i, i, addr)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+func reshard(t *testing.T, base, method string, params url.Values) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, base+"/v1/admin/reshard?"+params.Encode(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s reshard failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestOnlineReshard(t *testing.T) {
+	const nodes = 3
+	n := startNodes(t, nodes)
+	urls, addrs := n.urls, n.addrs
+
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.toml")
+	writeTopologyTOML(t, oldFile, 1, addrs[:2])
+	newFile := filepath.Join(dir, "new.toml")
+	writeTopologyTOML(t, newFile, 2, addrs)
+
+	databases := make([]*db.Database, nodes)
+	for i := 0; i < nodes; i++ {
+		file := oldFile
+		if i == 2 {
+			file = newFile // the new shard starts with the new layout
+		}
+		c, err := config.ParseFile(file)
+		if err != nil {
+			t.Fatalf("failed to parse config: %v", err)
+		}
+		shards, err := config.ParseShards(c.Shards, fmt.Sprintf("shard-%d", i))
+		if err != nil {
+			t.Fatalf("failed to parse shards: %v", err)
+		}
+
+		databases[i] = createTempDB(t, i)
+		server := web.NewServer(databases[i], shards, web.WithTopologyFile(file))
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+		mux.HandleFunc("/v1/admin/reshard", server.ReshardHandler)
+		mux.HandleFunc("/v1/internal/migrate", server.MigrateHandler)
+		n.serve(i, mux)
+	}
+
+	var keys []string
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		resp, _ := doRequest(t, http.MethodPut, urls[0]+"/v1/keys/"+key, []byte("v-"+key), nil)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("PUT %s returned %d", key, resp.StatusCode)
+		}
+	}
+
+	newShards, _ := config.ParseFile(newFile)
+	ring, _ := config.ParseTopology(newShards.Shards)
+	var movedToNew []string
+	for _, key := range keys {
+		if ring.Index(key) == 2 {
+			movedToNew = append(movedToNew, key)
+		}
+	}
+	if len(movedToNew) == 0 {
+		t.Fatal("expected some keys to move to the new shard")
+	}
+
+	// Config files are only read from the node's config directory.
+	for _, name := range []string{"/etc/passwd", "../new.toml", "missing.toml"} {
+		code, body := reshard(t, urls[2], http.MethodPost, url.Values{"config-file": {name}})
+		if code != http.StatusBadRequest || strings.Contains(body, dir) {
+			t.Errorf("reshard with config file %q returned %d: %s", name, code, body)
+		}
+	}
+
+	// The new shard learns the old layout and serves unmigrated keys from
+	// their previous owner.
+	code, body := reshard(t, urls[2], http.MethodPost, url.Values{
+		"config-file":          {"new.toml"},
+		"previous-config-file": {"old.toml"},
+	})
+	if code != http.StatusAccepted {
+		t.Fatalf("reshard on new shard returned %d: %s", code, body)
+	}
+	resp, val := doRequest(t, http.MethodGet, urls[2]+"/v1/keys/"+movedToNew[0], nil, nil)
+	if resp.StatusCode != http.StatusOK || string(val) != "v-"+movedToNew[0] {
+		t.Fatalf("dual read of %s returned %d %q", movedToNew[0], resp.StatusCode, val)
+	}
+
+	// A delete during migration must not be undone by the copy.
+	deleted := movedToNew[1]
+	resp, _ = doRequest(t, http.MethodDelete, urls[2]+"/v1/keys/"+deleted, nil, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE %s returned %d", deleted, resp.StatusCode)
+	}
+
+	// Nor by a copy read before the delete.
+	stale, _ := json.Marshal([]db.KV{{Key: deleted, Value: []byte("v-" + deleted)}})
+	resp, written := doRequest(t, http.MethodPost, urls[2]+"/v1/internal/migrate", stale, nil)
+	if resp.StatusCode != http.StatusOK || string(written) != "0" {
+		t.Fatalf("copy of deleted key %s returned %d %q", deleted, resp.StatusCode, written)
+	}
+
+	for i := 0; i < 2; i++ {
+		code, body := reshard(t, urls[i], http.MethodPost, url.Values{"config-file": {"new.toml"}})
+		if code != http.StatusAccepted {
+			t.Fatalf("reshard on shard %d returned %d: %s", i, code, body)
+		}
+	}
+
+	for i := 0; i < nodes; i++ {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, body := reshard(t, urls[i], http.MethodGet, nil)
+			var st web.MigrationStatus
+			json.Unmarshal([]byte(body), &st)
+			if st.State == "copied" {
+				break
+			}
+			if st.State == "failed" || time.Now().After(deadline) {
+				t.Fatalf("shard %d did not finish copying: %s", i, body)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if code, body := reshard(t, urls[i], http.MethodDelete, nil); code != http.StatusOK {
+			t.Fatalf("finishing reshard on shard %d returned %d: %s", i, code, body)
+		}
+	}
+
+	// Every key now lives only on its owner under the new layout.
+	for _, key := range keys {
+		owner := ring.Index(key)
+		for i, database := range databases {
+			_, err := database.GetKey(key)
+			switch {
+			case key == deleted:
+				if err == nil {
+					t.Errorf("deleted key %s came back on shard %d", key, i)
+				}
+			case i == owner && err != nil:
+				t.Errorf("key %s missing on its new owner %d: %v", key, i, err)
+			case i != owner && err == nil:
+				t.Errorf("key %s still present on shard %d, owner is %d", key, i, owner)
+			}
+		}
+
+		if key == deleted {
+			continue
+		}
+		resp, val := doRequest(t, http.MethodGet, urls[0]+"/v1/keys/"+key, nil, nil)
+		if resp.StatusCode != http.StatusOK || string(val) != "v-"+key {
+			t.Errorf("GET %s after reshard returned %d %q", key, resp.StatusCode, val)
+		}
+	}
+}
+
+func TestMigrateWithRaft(t *testing.T) {
+	g := newRaftGroup(t, 3)
+	follower := g.urls[(g.leader()+1)%3]
+
+	// Copies and deletes sent to a follower are committed by the leader.
+	copies, _ := json.Marshal([]db.KV{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}})
+	resp, written := doRequest(t, http.MethodPost, follower+"/v1/internal/migrate", copies, nil)
+	if resp.StatusCode != http.StatusOK || string(written) != "2" {
+		t.Fatalf("copy via follower returned %d %q", resp.StatusCode, written)
+	}
+	resp, _ = doRequest(t, http.MethodDelete, follower+"/v1/internal/migrate?key=a", nil, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete via follower returned %d", resp.StatusCode)
+	}
+
+	for i, database := range g.databases {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, errA := database.GetKey("a")
+			val, errB := database.GetKey("b")
+			if errors.Is(errA, db.ErrNotFound) && errB == nil && string(val) == "2" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("member %d never applied the migration: a: %v, b: %q %v", i, errA, val, errB)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
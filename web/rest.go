@@ -0,0 +1,313 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/replication"
+)
+
+// maxValueSize caps the request body accepted by PUT /v1/keys/{key}.
+const maxValueSize = 32 << 20
+
+const (
+	// VersionHeader carries the version of a key read or written, when it
+	// is known. Every write gives a key a new, higher version.
+	VersionHeader = "X-Key-Version"
+	// IfValueHeader makes a PUT or DELETE conditional on the key's current
+	// value, given base64-encoded.
+	IfValueHeader = "X-If-Value"
+	// TTLHeader sets the time-to-live of a key written with PUT, as a
+	// duration such as "90s" or a number of seconds. Reads of a key that
+	// expires report its remaining time-to-live in seconds.
+	TTLHeader = "X-TTL"
+)
+
+// parseTTL parses a time-to-live given as a duration or a number of
+// seconds. Keys expire with a one second resolution, so shorter TTLs are
+// refused.
+func parseTTL(s string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(s)
+	if n, nerr := strconv.ParseUint(s, 10, 32); nerr == nil {
+		ttl, err = time.Duration(n)*time.Second, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	if ttl < time.Second {
+		return 0, fmt.Errorf("TTL %q is shorter than one second", s)
+	}
+	return ttl, nil
+}
+
+// setTTLHeader reports the remaining time-to-live of e, if it expires.
+func setTTLHeader(w http.ResponseWriter, e db.Entry) {
+	if e.ExpiresAt != 0 {
+		w.Header().Set(TTLHeader, strconv.FormatInt(int64(e.TTL().Seconds()), 10))
+	}
+}
+
+// etag returns a strong entity tag for a value.
+func etag(value []byte) string {
+	h := fnv.New64a()
+	h.Write(value)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// etagMatches reports whether an If-Match / If-None-Match header value
+// matches tag. "*" matches any existing value.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatches reports whether an If-Match header value lists tag or version.
+// Versions are given as plain numbers.
+func ifMatches(header, tag string, version uint64) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if n, err := strconv.ParseUint(candidate, 10, 64); err == nil {
+			if version != 0 && n == version {
+				return true
+			}
+			continue
+		}
+		if etagMatches(candidate, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyHandler serves the RESTful key API at /v1/keys/{key}. Values are the
+// raw request and response bodies.
+//
+//	GET    returns the value (404 if missing, 304 if If-None-Match matches)
+//	PUT    stores the request body and returns 204 with the new ETag; the
+//	       key expires after the X-TTL header's duration if it is set
+//	DELETE removes the key and returns 204
+//
+// PUT and DELETE are conditional with If-Match (ETags or versions, or * for
+// any existing value), If-None-Match: * (create only) or X-If-Value, and
+// answer 412 if the condition does not hold.
+func (s *Server) KeyHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	if db.IsReservedKey(key) {
+		http.Error(w, fmt.Sprintf("Invalid key: %v", db.ErrReservedKey), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	routed := s.route
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		routed = s.routeRead
+	}
+	if !routed(key, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if s.readable(w, r) {
+			s.getKey(w, r, key)
+		}
+	case http.MethodPut:
+		if s.writable(w, r) {
+			s.putKey(w, r, key)
+		}
+	case http.MethodDelete:
+		if s.writable(w, r) {
+			s.deleteKey(w, r, key)
+		}
+	}
+}
+
+func (s *Server) getKey(w http.ResponseWriter, r *http.Request, key string) {
+	e, err := s.readKey(r.Context(), key)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	val := e.Value
+	tag := etag(val)
+	w.Header().Set("ETag", tag)
+	if e.Version != 0 {
+		w.Header().Set(VersionHeader, strconv.FormatUint(e.Version, 10))
+	}
+	setTTLHeader(w, e)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(len(val)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(val)
+}
+
+// writeCondition returns the condition of a PUT or DELETE, from its
+// If-Match, If-None-Match and X-If-Value headers. If-Match is checked
+// against the current value here and turned into a condition that the
+// value is still at the same version when the write is applied.
+func (s *Server) writeCondition(w http.ResponseWriter, r *http.Request, key string) (db.Condition, bool) {
+	var cond db.Condition
+	if v := r.Header.Get(IfValueHeader); v != "" {
+		val, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %s: %v", IfValueHeader, err), http.StatusBadRequest)
+			return cond, false
+		}
+		cond.Value = val
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if strings.TrimSpace(inm) != "*" {
+			http.Error(w, "If-None-Match must be * on writes", http.StatusBadRequest)
+			return cond, false
+		}
+		cond.Absent = true
+	}
+
+	im := strings.TrimSpace(r.Header.Get("If-Match"))
+	if im == "" {
+		return cond, true
+	}
+	cond.Exists = true
+	if im == "*" {
+		return cond, true
+	}
+
+	// Only the Raft leader has the latest value to match against.
+	if s.raft != nil && !s.raft.IsLeader() {
+		if leader := s.raft.Leader(); leader != "" {
+			s.forward(leader, w, r)
+		} else {
+			http.Error(w, "No leader elected for this shard", http.StatusServiceUnavailable)
+		}
+		return cond, false
+	}
+	e, err := s.db.GetEntry(key)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		http.Error(w, fmt.Sprintf("Failed to get key: %v", err), http.StatusInternalServerError)
+		return cond, false
+	}
+	if err != nil || !ifMatches(im, etag(e.Value), e.Version) {
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return cond, false
+	}
+	// Keys written before versions were recorded are compared by value.
+	cond.Version = e.Version
+	if e.Version == 0 && cond.Value == nil {
+		cond.Value = e.Value
+	}
+	return cond, true
+}
+
+func (s *Server) putKey(w http.ResponseWriter, r *http.Request, key string) {
+	cond, ok := s.writeCondition(w, r, key)
+	if !ok {
+		return
+	}
+	var expiresAt uint64
+	if h := r.Header.Get(TTLHeader); h != "" {
+		ttl, err := parseTTL(h)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		expiresAt = db.ExpiryAfter(ttl)
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Value too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to read value: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var version uint64
+	if s.raft != nil {
+		// Restore the body in case the write has to be forwarded to the leader.
+		r.Body = io.NopCloser(bytes.NewReader(value))
+		result, ok := s.propose(w, r, replication.Command{Op: replication.OpSet, Key: key, Value: value, ExpiresAt: expiresAt, Cond: conditional(cond)})
+		if !ok {
+			return
+		}
+		version, _ = result.(uint64)
+	} else if version, err = s.db.SetKeyExpiring(key, value, expiresAt, cond); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set key: %v", err), writeStatus(err))
+		return
+	}
+
+	w.Header().Set("ETag", etag(value))
+	if version != 0 {
+		w.Header().Set(VersionHeader, strconv.FormatUint(version, 10))
+	}
+	s.setWriteToken(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, r *http.Request, key string) {
+	cond, ok := s.writeCondition(w, r, key)
+	if !ok {
+		return
+	}
+	if s.raft != nil {
+		if _, ok := s.propose(w, r, replication.Command{Op: replication.OpDelete, Key: key, Cond: conditional(cond)}); !ok {
+			return
+		}
+	} else if err := s.db.DeleteKeyIf(key, cond); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete key: %v", err), writeStatus(err))
+		return
+	}
+	if err := s.deletePrevious(r.Context(), key); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete key from previous owner: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	s.setWriteToken(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// conditional returns cond for a Raft command, or nil if it always holds.
+func conditional(cond db.Condition) *db.Condition {
+	if !cond.Exists && !cond.Absent && cond.Version == 0 && cond.Value == nil {
+		return nil
+	}
+	return &cond
+}
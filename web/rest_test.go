@@ -0,0 +1,248 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// newRESTCluster starts count shards serving the /v1/keys API and returns
+// their base URLs.
+func newRESTCluster(t *testing.T, count int) []string {
+	t.Helper()
+	return newCluster(t, count, func(server *web.Server, mux *http.ServeMux) {
+		mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+		mux.HandleFunc("/v1/batch", server.BatchHandler)
+		mux.HandleFunc("/v1/incr/{key...}", server.IncrementHandler)
+		mux.HandleFunc("/v1/watch", server.WatchHandler)
+	})
+}
+
+func doRequest(t *testing.T, method, url string, body []byte, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, data
+}
+
+func TestKeyHandlerBinaryRoundTrip(t *testing.T) {
+	urls := newRESTCluster(t, 2)
+
+	value := []byte{0x00, 0xff, 0x10, '\n', 0x80}
+	for _, key := range []string{"bin-one", "bin-two", "nested/path/key"} {
+		resp, _ := doRequest(t, http.MethodPut, urls[0]+"/v1/keys/"+key, value,
+			http.Header{"Content-Type": {"application/octet-stream"}})
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("PUT %s returned %d", key, resp.StatusCode)
+		}
+		putTag := resp.Header.Get("ETag")
+		if putTag == "" {
+			t.Fatalf("PUT %s returned no ETag", key)
+		}
+
+		// Read from the other shard to exercise forwarding both ways.
+		resp, body := doRequest(t, http.MethodGet, urls[1]+"/v1/keys/"+key, nil, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s returned %d", key, resp.StatusCode)
+		}
+		if !bytes.Equal(body, value) {
+			t.Errorf("GET %s returned %v, want %v", key, body, value)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/octet-stream" {
+			t.Errorf("GET %s returned Content-Type %q", key, ct)
+		}
+		if tag := resp.Header.Get("ETag"); tag != putTag {
+			t.Errorf("GET %s returned ETag %q, PUT returned %q", key, tag, putTag)
+		}
+	}
+}
+
+func TestKeyHandlerStatusCodes(t *testing.T) {
+	urls := newRESTCluster(t, 1)
+	url := urls[0] + "/v1/keys/status-key"
+
+	resp, _ := doRequest(t, http.MethodGet, url, nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of a missing key returned %d, want 404", resp.StatusCode)
+	}
+
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v1"), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT returned %d", resp.StatusCode)
+	}
+	tag := resp.Header.Get("ETag")
+
+	resp, body := doRequest(t, http.MethodGet, url, nil, http.Header{"If-None-Match": {tag}})
+	if resp.StatusCode != http.StatusNotModified || len(body) != 0 {
+		t.Errorf("Conditional GET returned %d with %q, want 304", resp.StatusCode, body)
+	}
+
+	resp, _ = doRequest(t, http.MethodPost, url, []byte("x"), nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned %d, want 405", resp.StatusCode)
+	}
+
+	resp, _ = doRequest(t, http.MethodDelete, url, nil, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE returned %d, want 204", resp.StatusCode)
+	}
+
+	resp, _ = doRequest(t, http.MethodGet, url, nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE returned %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestReservedKeysRejected(t *testing.T) {
+	base := newRESTCluster(t, 1)[0]
+
+	for _, req := range []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/v1/keys/meta:last-seq", ""},
+		{http.MethodPut, "/v1/keys/meta:last-seq", "1"},
+		{http.MethodDelete, "/v1/keys/replog:x", ""},
+		{http.MethodPost, "/v1/incr/meta:version-seq", ""},
+		{http.MethodPost, "/v1/batch", `{"ops":[{"op":"put","key":"meta:last-seq","value":"MQ=="}]}`},
+	} {
+		resp, body := doRequest(t, req.method, base+req.path, []byte(req.body), nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s %s returned %d (%s), want 400", req.method, req.path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestKeyHandlerConditionalWrites(t *testing.T) {
+	urls := newRESTCluster(t, 2)
+	url := urls[0] + "/v1/keys/" + keyForShard(2, 1)
+
+	// Create-only: the second writer loses.
+	resp, _ := doRequest(t, http.MethodPut, url, []byte("v1"), http.Header{"If-None-Match": {"*"}})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("create-only PUT returned %d", resp.StatusCode)
+	}
+	version := resp.Header.Get(web.VersionHeader)
+	tag := resp.Header.Get("ETag")
+	if version == "" {
+		t.Fatalf("PUT returned no %s", web.VersionHeader)
+	}
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v2"), http.Header{"If-None-Match": {"*"}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("second create-only PUT returned %d, want 412", resp.StatusCode)
+	}
+
+	resp, _ = doRequest(t, http.MethodGet, url, nil, nil)
+	if got := resp.Header.Get(web.VersionHeader); got != version {
+		t.Errorf("GET returned version %q, PUT returned %q", got, version)
+	}
+
+	// Compare-and-swap by version: a stale version is refused.
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v2"), http.Header{"If-Match": {version}})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT If-Match version returned %d", resp.StatusCode)
+	}
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v3"), http.Header{"If-Match": {version}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale version returned %d, want 412", resp.StatusCode)
+	}
+	// The ETag of v1 no longer matches either, even though v1's ETag is known.
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v3"), http.Header{"If-Match": {tag}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag returned %d, want 412", resp.StatusCode)
+	}
+
+	// Compare-value.
+	resp, _ = doRequest(t, http.MethodDelete, url, nil, http.Header{web.IfValueHeader: {base64.StdEncoding.EncodeToString([]byte("v1"))}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with the wrong value returned %d, want 412", resp.StatusCode)
+	}
+	resp, _ = doRequest(t, http.MethodDelete, url, nil, http.Header{web.IfValueHeader: {base64.StdEncoding.EncodeToString([]byte("v2"))}})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE with the current value returned %d", resp.StatusCode)
+	}
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v4"), http.Header{"If-Match": {"*"}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT If-Match * on a missing key returned %d, want 412", resp.StatusCode)
+	}
+}
+
+func TestVersionConditionAfterRaftRestart(t *testing.T) {
+	g := newRaftGroup(t, 3)
+	leader := g.leader()
+	url := g.urls[leader] + "/v1/keys/k"
+
+	for _, v := range []string{"v1", "v2"} {
+		if resp, body := doRequest(t, http.MethodPut, url, []byte(v), nil); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("PUT %s returned %d: %s", v, resp.StatusCode, body)
+		}
+	}
+	waitForValue := func(value string) {
+		t.Helper()
+		for i, database := range g.databases {
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				val, err := database.GetKey("k")
+				if err == nil && string(val) == value {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("member %d never applied %s: %q, %v", i, value, val, err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	waitForValue("v2")
+
+	follower := (leader + 1) % len(g.urls)
+	g.restart(t, follower)
+	url = g.urls[g.leader()] + "/v1/keys/k"
+
+	resp, _ := doRequest(t, http.MethodGet, url, nil, nil)
+	version := resp.Header.Get(web.VersionHeader)
+	if version == "" {
+		t.Fatalf("GET returned no %s", web.VersionHeader)
+	}
+	resp, body := doRequest(t, http.MethodPut, url, []byte("v3"), http.Header{"If-Match": {version}})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT If-Match %s returned %d: %s", version, resp.StatusCode, body)
+	}
+	written := resp.Header.Get(web.VersionHeader)
+
+	// Every member, the restarted one included, agrees on the version the
+	// condition was checked against, so they all apply the write.
+	waitForValue("v3")
+	var versions []uint64
+	for _, database := range g.databases {
+		e, err := database.GetEntry("k")
+		if err != nil {
+			t.Fatalf("GetEntry failed: %v", err)
+		}
+		versions = append(versions, e.Version)
+	}
+	if versions[0] == 0 || versions[0] != versions[1] || versions[1] != versions[2] {
+		t.Errorf("members disagree on the version: %v", versions)
+	}
+	if written != strconv.FormatUint(versions[0], 10) {
+		t.Errorf("PUT returned %s %q, want %d", web.VersionHeader, written, versions[0])
+	}
+}
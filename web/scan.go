@@ -0,0 +1,190 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/Sagor0078/distribKV/db"
+)
+
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// ScanResult is the response body of the scan and list endpoints.
+type ScanResult struct {
+	Items []db.KV `json:"items"`
+	// Next is passed back as "start" (scan) or "cursor" (list) to fetch the
+	// following page. It is empty when there are no more keys.
+	Next string `json:"next,omitempty"`
+}
+
+// ScanHandler serves GET /v1/scan?start=&end=&limit=, returning keys in
+// [start, end) across all shards in sorted order.
+func (s *Server) ScanHandler(w http.ResponseWriter, r *http.Request) {
+	start := r.URL.Query().Get("start")
+	end := r.URL.Query().Get("end")
+	s.rangeQuery(w, r, func(limit int) ([]db.KV, string, error) {
+		return s.db.Scan(start, end, limit)
+	})
+}
+
+// ListHandler serves GET /v1/list?prefix=&cursor=&limit=, returning keys
+// with the given prefix across all shards in sorted order.
+func (s *Server) ListHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	cursor := r.URL.Query().Get("cursor")
+	s.rangeQuery(w, r, func(limit int) ([]db.KV, string, error) {
+		return s.db.ListPrefix(prefix, cursor, limit)
+	})
+}
+
+// rangeQuery runs a range query locally, or on every shard and merges the
+// results when the request did not come from another shard.
+func (s *Server) rangeQuery(w http.ResponseWriter, r *http.Request, local func(limit int) ([]db.KV, string, error)) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultScanLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxScanLimit)
+	}
+
+	if !s.checkEpoch(w, r) {
+		return
+	}
+
+	var res ScanResult
+	var err error
+	if query.Get("local") == "true" || s.topology().Count <= 1 {
+		res.Items, res.Next, err = local(limit)
+	} else {
+		res, err = s.fanOut(r.Context(), r.URL.Path, query, limit, local)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Range query failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if res.Items == nil {
+		res.Items = []db.KV{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// fanOut runs the query on every shard and merges the sorted pages.
+func (s *Server) fanOut(ctx context.Context, path string, query url.Values, limit int, local func(limit int) ([]db.KV, string, error)) (ScanResult, error) {
+	shards := s.topology()
+	results := make([]ScanResult, shards.Count)
+	errs := make([]error, shards.Count)
+
+	var wg sync.WaitGroup
+	for idx, addr := range shards.Addrs {
+		wg.Add(1)
+		go func(idx int, addr string) {
+			defer wg.Done()
+			if idx == shards.CurIdx {
+				results[idx].Items, results[idx].Next, errs[idx] = local(limit)
+				return
+			}
+			if s.balancer != nil {
+				if be := s.balancer.Pick(idx); be != nil {
+					done := be.Start()
+					results[idx], errs[idx] = s.fetchShardRange(ctx, be.Addr, path, query, limit, shards.Epoch)
+					done(errs[idx])
+					return
+				}
+			}
+			results[idx], errs[idx] = s.fetchShardRange(ctx, addr, path, query, limit, shards.Epoch)
+		}(idx, addr)
+	}
+	wg.Wait()
+
+	for idx, err := range errs {
+		if err != nil {
+			return ScanResult{}, fmt.Errorf("shard %d: %w", idx, err)
+		}
+	}
+	return mergeRanges(results, limit), nil
+}
+
+func (s *Server) fetchShardRange(ctx context.Context, addr, path string, query url.Values, limit int, epoch uint64) (ScanResult, error) {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("local", "true")
+	q.Set("limit", strconv.Itoa(limit))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	req.Header.Set(EpochHeader, strconv.FormatUint(epoch, 10))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ScanResult{}, fmt.Errorf("%s returned %d", addr, resp.StatusCode)
+	}
+	var res ScanResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return ScanResult{}, err
+	}
+	return res, nil
+}
+
+// mergeRanges combines per-shard pages into one sorted page of at most
+// limit items. A shard that stopped early has not reported keys past its
+// cursor, so the merged page must stop at the smallest such cursor.
+func mergeRanges(pages []ScanResult, limit int) ScanResult {
+	var merged ScanResult
+	for _, p := range pages {
+		if p.Next != "" && (merged.Next == "" || p.Next < merged.Next) {
+			merged.Next = p.Next
+		}
+	}
+
+	for _, p := range pages {
+		for _, kv := range p.Items {
+			if merged.Next == "" || kv.Key < merged.Next {
+				merged.Items = append(merged.Items, kv)
+			}
+		}
+	}
+	sort.Slice(merged.Items, func(i, j int) bool {
+		return merged.Items[i].Key < merged.Items[j].Key
+	})
+
+	// While resharding, a key can briefly live on both its old and new owner.
+	merged.Items = slices.CompactFunc(merged.Items, func(a, b db.KV) bool {
+		return a.Key == b.Key
+	})
+
+	if len(merged.Items) > limit {
+		merged.Next = merged.Items[limit].Key
+		merged.Items = merged.Items[:limit]
+	}
+	return merged
+}
@@ -0,0 +1,108 @@
+package web_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// newScanCluster starts count shards serving the range endpoints, loads
+// keys through their owning shard and returns the base URLs.
+func newScanCluster(t *testing.T, count int, keys []string) []string {
+	t.Helper()
+
+	urls := newCluster(t, count, func(server *web.Server, mux *http.ServeMux) {
+		mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+		mux.HandleFunc("/v1/scan", server.ScanHandler)
+		mux.HandleFunc("/v1/list", server.ListHandler)
+	})
+	for _, key := range keys {
+		if resp, body := doRequest(t, http.MethodPut, urls[0]+"/v1/keys/"+key, []byte("v-"+key), nil); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("failed to set %q: %d %s", key, resp.StatusCode, body)
+		}
+	}
+	return urls
+}
+
+func getRange(t *testing.T, base, path string, params url.Values) web.ScanResult {
+	t.Helper()
+	resp, err := http.Get(base + path + "?" + params.Encode())
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %d", path, resp.StatusCode)
+	}
+	var res web.ScanResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	return res
+}
+
+func TestScanHandlerMergesShards(t *testing.T) {
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("key-%02d", i))
+	}
+	urls := newScanCluster(t, 3, keys)
+
+	// Page through everything from one node with a small limit.
+	var got []string
+	params := url.Values{"limit": {"4"}}
+	for {
+		res := getRange(t, urls[1], "/v1/scan", params)
+		for _, kv := range res.Items {
+			got = append(got, kv.Key)
+			if string(kv.Value) != "v-"+kv.Key {
+				t.Errorf("Unexpected value for %q: %q", kv.Key, kv.Value)
+			}
+		}
+		if res.Next == "" {
+			break
+		}
+		params.Set("start", res.Next)
+	}
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("Scan returned %v, want %v", got, keys)
+	}
+
+	res := getRange(t, urls[0], "/v1/scan", url.Values{"start": {"key-05"}, "end": {"key-08"}})
+	var bounded []string
+	for _, kv := range res.Items {
+		bounded = append(bounded, kv.Key)
+	}
+	if !reflect.DeepEqual(bounded, []string{"key-05", "key-06", "key-07"}) {
+		t.Errorf("Bounded scan returned %v", bounded)
+	}
+}
+
+func TestListHandlerHidesInternalKeys(t *testing.T) {
+	urls := newScanCluster(t, 2, []string{"app:a", "app:b", "app:c", "other"})
+
+	res := getRange(t, urls[0], "/v1/list", url.Values{"prefix": {"app:"}})
+	var got []string
+	for _, kv := range res.Items {
+		got = append(got, kv.Key)
+	}
+	if !reflect.DeepEqual(got, []string{"app:a", "app:b", "app:c"}) {
+		t.Errorf("List returned %v", got)
+	}
+
+	res = getRange(t, urls[0], "/v1/scan", url.Values{})
+	for _, kv := range res.Items {
+		if strings.HasPrefix(kv.Key, "meta:") || strings.HasPrefix(kv.Key, "replog:") {
+			t.Errorf("Scan leaked internal key %q", kv.Key)
+		}
+	}
+	if len(res.Items) != 4 {
+		t.Errorf("Expected 4 user keys, got %d", len(res.Items))
+	}
+}
@@ -0,0 +1,36 @@
+package web
+
+import (
+	"log"
+	"net/http"
+)
+
+// SnapshotHandler serves GET /v1/internal/snapshot, a consistent snapshot
+// of the shard leader's data that a new replica loads before following the
+// replication log from the position the snapshot reflects. Members of a
+// Raft group catch up from the Raft log instead, so nodes started with
+// -raft answer 501 Not Implemented.
+func (s *Server) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.raft != nil {
+		http.Error(w, "Snapshots are not supported with Raft", http.StatusNotImplemented)
+		return
+	}
+	if s.replica != nil {
+		http.Error(w, "Snapshots are served by shard leaders", http.StatusMisdirectedRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	seq, err := s.db.Snapshot(w)
+	if err != nil {
+		// The status is already sent; the replica sees a truncated body.
+		log.Printf("Failed to send snapshot: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	log.Printf("Sent snapshot at log position %d to %s", seq, r.RemoteAddr)
+}
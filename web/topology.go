@@ -0,0 +1,217 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Sagor0078/distribKV/client"
+	"github.com/Sagor0078/distribKV/config"
+)
+
+// EpochHeader carries the topology epoch of the node that sent a request or
+// response. Nodes compare it with their own to detect stale configs.
+const EpochHeader = "X-Topology-Epoch"
+
+// reloadBackoff limits how often a request from a node with a newer epoch
+// makes this node re-read its config file.
+const reloadBackoff = time.Second
+
+var (
+	// ErrStaleTopology is returned when a reload would go back to an older
+	// topology epoch, or change the shard layout without a newer one.
+	ErrStaleTopology = errors.New("topology epoch is not newer than the current one")
+
+	errNoTopologyFile = errors.New("no config file to reload the topology from")
+	errReshardRunning = errors.New("reshard in progress")
+)
+
+// WithTopologyFile sets the sharding config file the topology is reloaded
+// from by ReloadTopology.
+func WithTopologyFile(file string) Option {
+	return func(s *Server) {
+		s.topologyFile = file
+	}
+}
+
+// ReloadTopology re-reads the sharding config and switches routing to it.
+// An empty file means the file given to WithTopologyFile. The reload is
+// refused if the new epoch is older than the current one, if the layout
+// changed without the epoch increasing, or while a reshard is copying
+// keys. The shard's own Raft peers and replication leader are fixed at
+// startup and are not affected.
+func (s *Server) ReloadTopology(file string) (*config.Shards, error) {
+	if file == "" {
+		file = s.topologyFile
+	}
+	if file == "" {
+		return nil, errNoTopologyFile
+	}
+
+	cur := s.topology()
+	shards, err := loadTopology(file, cur.CurName)
+	if err != nil {
+		return nil, err
+	}
+
+	s.topoMu.Lock()
+	defer s.topoMu.Unlock()
+
+	if err := checkReplace(s.shards, shards); err != nil {
+		return nil, err
+	}
+	if s.migration != nil && s.migration.snapshot().State == "copying" {
+		return nil, errReshardRunning
+	}
+	if s.shards.Epoch != shards.Epoch {
+		log.Printf("Topology reloaded: epoch %d → %d, %d shards, this node is shard %d",
+			s.shards.Epoch, shards.Epoch, shards.Count, shards.CurIdx)
+	}
+	s.setTopologyLocked(shards)
+	return shards, nil
+}
+
+// configFile returns the path of the config file a request names by its
+// path relative to the directory of the file given to WithTopologyFile.
+// Requests cannot make the node read files outside that directory.
+func (s *Server) configFile(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("missing config-file")
+	}
+	if s.topologyFile == "" {
+		return "", errNoTopologyFile
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("config file %q is not in the node's config directory", name)
+	}
+	return filepath.Join(filepath.Dir(s.topologyFile), name), nil
+}
+
+// checkReplace returns ErrStaleTopology unless next may replace cur: its
+// epoch must not be older, and must be newer if the layout changed.
+func checkReplace(cur, next *config.Shards) error {
+	if next.Epoch < cur.Epoch {
+		return fmt.Errorf("%w: %d < %d", ErrStaleTopology, next.Epoch, cur.Epoch)
+	}
+	if next.Epoch == cur.Epoch && !sameLayout(cur, next) {
+		return fmt.Errorf("%w: the layout changed at epoch %d", ErrStaleTopology, next.Epoch)
+	}
+	return nil
+}
+
+// sameLayout reports whether a and b place the shards alike.
+func sameLayout(a, b *config.Shards) bool {
+	return a.Count == b.Count &&
+		maps.Equal(a.Addrs, b.Addrs) &&
+		maps.EqualFunc(a.Replicas, b.Replicas, slices.Equal[[]string]) &&
+		slices.EqualFunc(a.Layout, b.Layout, func(x, y config.Shard) bool {
+			return x.Name == y.Name && x.Idx == y.Idx && x.Address == y.Address &&
+				x.VirtualNodes == y.VirtualNodes && slices.Equal(x.Replicas, y.Replicas)
+		})
+}
+
+// checkEpoch compares the epoch of the node that forwarded r with ours. A
+// request from a node with a newer topology makes this node try to reload
+// its config; if it is still behind, the request is refused with 421 so the
+// sender does not act on a misrouted read or write. Requests from nodes with
+// an older topology are re-routed by the caller using ours. It reports
+// whether the request may proceed.
+func (s *Server) checkEpoch(w http.ResponseWriter, r *http.Request) bool {
+	v := r.Header.Get(EpochHeader)
+	if v == "" {
+		return true
+	}
+	theirs, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid %s: %q", EpochHeader, v), http.StatusBadRequest)
+		return false
+	}
+
+	if theirs <= s.topology().Epoch {
+		return true
+	}
+	s.tryReload()
+	ours := s.topology().Epoch
+	if theirs <= ours {
+		return true
+	}
+
+	w.Header().Set(EpochHeader, strconv.FormatUint(ours, 10))
+	http.Error(w, fmt.Sprintf("Stale topology: request has epoch %d, this node has %d", theirs, ours),
+		http.StatusMisdirectedRequest)
+	return false
+}
+
+// tryReload reloads the topology from the config file, at most once per
+// reloadBackoff.
+func (s *Server) tryReload() {
+	s.topoMu.Lock()
+	if s.topologyFile == "" || time.Since(s.lastReload) < reloadBackoff {
+		s.topoMu.Unlock()
+		return
+	}
+	s.lastReload = time.Now()
+	s.topoMu.Unlock()
+
+	if _, err := s.ReloadTopology(""); err != nil {
+		log.Printf("Failed to reload topology: %v", err)
+	}
+}
+
+// Topology describes the routing topology of a node. It is defined in the
+// client package, which decodes it.
+type Topology = client.Topology
+
+// TopologyHandler serves the topology this node routes by.
+//
+//	GET                      returns the current topology
+//	POST [?config-file=...]  reloads it from the node's config file, or
+//	                         from another file in its directory
+func (s *Server) TopologyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var file string
+		if name := r.URL.Query().Get("config-file"); name != "" {
+			var err error
+			if file, err = s.configFile(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		_, err := s.ReloadTopology(file)
+		switch {
+		case errors.Is(err, ErrStaleTopology), errors.Is(err, errReshardRunning):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, errNoTopologyFile):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Printf("Failed to reload topology: %v", err)
+			http.Error(w, "Failed to load the config file", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	shards := s.topology()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Topology{
+		Epoch:    shards.Epoch,
+		Shard:    shards.CurIdx,
+		Addrs:    shards.Addrs,
+		Replicas: shards.Replicas,
+		Shards:   shards.Layout,
+	})
+}
@@ -0,0 +1,162 @@
+package web_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// newTopologyCluster starts one shard per epoch, each loading its topology from
+// its own config file at that epoch. It returns the base URLs, addresses and
+// the config file of each node.
+func newTopologyCluster(t *testing.T, epochs []uint64) ([]string, []string, []string) {
+	t.Helper()
+
+	count := len(epochs)
+	n := startNodes(t, count)
+	files := make([]string, count)
+	for i := range count {
+		files[i] = filepath.Join(t.TempDir(), "sharding.toml")
+		writeTopologyTOML(t, files[i], epochs[i], n.addrs)
+
+		shards, err := config.LoadShards(files[i], fmt.Sprintf("shard-%d", i))
+		if err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+		server := web.NewServer(createTempDB(t, i), shards, web.WithTopologyFile(files[i]))
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+		mux.HandleFunc("/v1/admin/topology", server.TopologyHandler)
+		n.serve(i, mux)
+	}
+	return n.urls, n.addrs, files
+}
+
+func getTopology(t *testing.T, base, method string, query string) (int, web.Topology) {
+	t.Helper()
+
+	resp, body := doRequest(t, method, base+"/v1/admin/topology"+query, nil, nil)
+	var topo web.Topology
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, &topo); err != nil {
+			t.Fatalf("failed to decode topology %q: %v", body, err)
+		}
+	}
+	return resp.StatusCode, topo
+}
+
+func TestTopologyHandlerReload(t *testing.T) {
+	urls, addrs, files := newTopologyCluster(t, []uint64{1})
+
+	code, topo := getTopology(t, urls[0], http.MethodGet, "")
+	if code != http.StatusOK || topo.Epoch != 1 || len(topo.Addrs) != 1 {
+		t.Fatalf("GET topology returned %d %+v", code, topo)
+	}
+
+	grown := append(addrs, "127.0.0.1:1")
+	writeTopologyTOML(t, files[0], 2, grown)
+	code, topo = getTopology(t, urls[0], http.MethodPost, "")
+	if code != http.StatusOK || topo.Epoch != 2 || len(topo.Addrs) != 2 || topo.Shard != 0 {
+		t.Fatalf("reload returned %d %+v", code, topo)
+	}
+
+	// Going back to an older epoch is refused.
+	writeTopologyTOML(t, filepath.Join(filepath.Dir(files[0]), "older.toml"), 1, addrs)
+	code, _ = getTopology(t, urls[0], http.MethodPost, "?config-file=older.toml")
+	if code != http.StatusConflict {
+		t.Errorf("reload of an older epoch returned %d, want 409", code)
+	}
+
+	// So is changing the layout without increasing the epoch, while reloading
+	// the same layout again is not.
+	writeTopologyTOML(t, files[0], 2, addrs)
+	code, _ = getTopology(t, urls[0], http.MethodPost, "")
+	if code != http.StatusConflict {
+		t.Errorf("reload of a new layout at the same epoch returned %d, want 409", code)
+	}
+	writeTopologyTOML(t, files[0], 2, grown)
+	code, _ = getTopology(t, urls[0], http.MethodPost, "")
+	if code != http.StatusOK {
+		t.Errorf("reload of the same layout returned %d, want 200", code)
+	}
+
+	// Only files in the node's config directory are read.
+	for _, name := range []string{"missing.toml", "/etc/hosts", "../sharding.toml"} {
+		code, _ = getTopology(t, urls[0], http.MethodPost, "?config-file="+url.QueryEscape(name))
+		if code != http.StatusBadRequest {
+			t.Errorf("reload of %q returned %d, want 400", name, code)
+		}
+	}
+
+	_, topo = getTopology(t, urls[0], http.MethodGet, "")
+	if topo.Epoch != 2 {
+		t.Errorf("epoch after refused reloads is %d, want 2", topo.Epoch)
+	}
+}
+
+func TestStaleNodeRefusesNewerEpoch(t *testing.T) {
+	urls, addrs, files := newTopologyCluster(t, []uint64{1})
+	url := urls[0] + "/v1/keys/epoch-key"
+
+	// The node picks up a newer config that is already on disk.
+	writeTopologyTOML(t, files[0], 5, addrs)
+	resp, _ := doRequest(t, http.MethodPut, url, []byte("v"), http.Header{web.EpochHeader: {"5"}})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT with the on-disk epoch returned %d, want 204", resp.StatusCode)
+	}
+	if got := resp.Header.Get(web.EpochHeader); got != "5" {
+		t.Errorf("response epoch is %q, want 5", got)
+	}
+
+	// Without a newer config it refuses instead of misrouting.
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v"), http.Header{web.EpochHeader: {"9"}})
+	if resp.StatusCode != http.StatusMisdirectedRequest {
+		t.Fatalf("PUT with a newer epoch returned %d, want 421", resp.StatusCode)
+	}
+	if got := resp.Header.Get(web.EpochHeader); got != "5" {
+		t.Errorf("refusal carries epoch %q, want 5", got)
+	}
+
+	// Requests from nodes with an older topology are served.
+	resp, _ = doRequest(t, http.MethodGet, url, nil, http.Header{web.EpochHeader: {"2"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET with an older epoch returned %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestForwardCarriesEpoch(t *testing.T) {
+	urls, addrs, _ := newTopologyCluster(t, []uint64{3, 3})
+
+	routing, err := config.ParseTopology([]config.Shard{
+		{Name: "shard-0", Idx: 0, Address: addrs[0]},
+		{Name: "shard-1", Idx: 1, Address: addrs[1]},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse topology: %v", err)
+	}
+	key := "forward-0"
+	for i := 1; routing.Index(key) != 1; i++ {
+		key = fmt.Sprintf("forward-%d", i)
+	}
+
+	// Shard 0 forwards to shard 1, which reports its epoch back.
+	resp, _ := doRequest(t, http.MethodPut, urls[0]+"/v1/keys/"+key, []byte("v"), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("forwarded PUT returned %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(web.EpochHeader); got != "3" {
+		t.Errorf("forwarded response epoch is %q, want 3", got)
+	}
+
+	// An owner behind the sending node refuses the write.
+	resp, _ = doRequest(t, http.MethodPut, urls[1]+"/v1/keys/"+key, []byte("v"), http.Header{web.EpochHeader: {"4"}})
+	if resp.StatusCode != http.StatusMisdirectedRequest {
+		t.Errorf("PUT from a newer node returned %d, want 421", resp.StatusCode)
+	}
+}
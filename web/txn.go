@@ -0,0 +1,459 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sagor0078/distribKV/db"
+)
+
+const (
+	// TxnRecoveryInterval is how often RunTxnRecovery resolves transactions
+	// left unfinished by a crash.
+	TxnRecoveryInterval = 5 * time.Second
+
+	// txnUnknown is reported for transactions the coordinator has no record
+	// of. They never committed: a committed record is kept until every
+	// participant has applied it.
+	txnUnknown = "unknown"
+
+	// Outcomes sent to participants.
+	txnCommit = "commit"
+	txnAbort  = "abort"
+)
+
+// errTxnConflict is returned when a participant refuses to prepare because
+// another transaction holds one of the keys.
+var errTxnConflict = errors.New("conflicting transaction")
+
+// TxnRequest is the body of POST /v1/txn. Only puts and deletes are allowed.
+type TxnRequest struct {
+	Ops []db.BatchOp `json:"ops"`
+}
+
+// TxnResponse reports the outcome of a transaction.
+type TxnResponse struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// TxnHandler serves POST /v1/txn, applying writes across shards atomically
+// with two-phase commit coordinated by this node. Each owning shard leader
+// first prepares its writes, locking the keys, and the writes are applied
+// only once every shard has prepared. The response is 200 once the
+// transaction has committed, even if some participants have yet to apply
+// it; recovery finishes the commit. A transaction that could not prepare
+// everywhere is aborted with 409 Conflict when keys were locked by another
+// transaction, or 503 otherwise.
+func (s *Server) TxnHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.raft != nil {
+		http.Error(w, "Transactions are not supported with Raft", http.StatusNotImplemented)
+		return
+	}
+	if !s.writable(w, r) || !s.checkEpoch(w, r) {
+		return
+	}
+
+	var req TxnRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid transaction: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Ops) == 0 || len(req.Ops) > maxBatchOps {
+		http.Error(w, fmt.Sprintf("Invalid transaction: must have 1 to %d operations, got %d", maxBatchOps, len(req.Ops)), http.StatusBadRequest)
+		return
+	}
+	for i, op := range req.Ops {
+		switch {
+		case op.Op != db.BatchPut && op.Op != db.BatchDelete:
+			http.Error(w, fmt.Sprintf("Invalid operation %d: op must be put or delete, got %q", i, op.Op), http.StatusBadRequest)
+			return
+		case op.Key == "":
+			http.Error(w, fmt.Sprintf("Invalid operation %d: missing key", i), http.StatusBadRequest)
+			return
+		case db.IsReservedKey(op.Key):
+			http.Error(w, fmt.Sprintf("Invalid operation %d: %v", i, db.ErrReservedKey), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rec, err := s.runTxn(r.Context(), req.Ops)
+	resp := TxnResponse{ID: rec.ID, State: rec.State}
+	status := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		status = http.StatusServiceUnavailable
+		if errors.Is(err, errTxnConflict) || errors.Is(err, db.ErrLocked) {
+			status = http.StatusConflict
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runTxn coordinates a transaction: it records it, prepares it on every
+// participant, records the decision and sends it to the participants.
+func (s *Server) runTxn(ctx context.Context, ops []db.BatchOp) (db.TxnRecord, error) {
+	shards := s.topology()
+	self := shards.Addrs[shards.CurIdx]
+	groups := make(map[string][]db.BatchOp)
+	for _, op := range ops {
+		addr := shards.Addrs[shards.Index(op.Key)]
+		groups[addr] = append(groups[addr], op)
+	}
+
+	rec := db.TxnRecord{ID: newTxnID(), State: db.TxnPending, Started: time.Now()}
+	for addr := range groups {
+		rec.Participants = append(rec.Participants, addr)
+	}
+	sort.Strings(rec.Participants)
+
+	s.txnMu.Lock()
+	s.txns[rec.ID] = true
+	s.txnMu.Unlock()
+	defer func() {
+		s.txnMu.Lock()
+		delete(s.txns, rec.ID)
+		s.txnMu.Unlock()
+	}()
+
+	if err := s.db.PutTxnRecord(rec); err != nil {
+		rec.State = db.TxnAborted
+		return rec, fmt.Errorf("recording transaction: %w", err)
+	}
+
+	errs := make([]error, len(rec.Participants))
+	var wg sync.WaitGroup
+	for i, addr := range rec.Participants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in := db.Intent{ID: rec.ID, Coordinator: self, Ops: groups[addr], Prepared: time.Now()}
+			if addr == self {
+				errs[i] = s.db.PrepareIntent(in)
+			} else {
+				errs[i] = s.sendPrepare(ctx, addr, in)
+			}
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("prepare on %s: %w", addr, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	prepareErr := errors.Join(errs...)
+	rec.State = db.TxnCommitted
+	if prepareErr != nil {
+		rec.State = db.TxnAborted
+	}
+	// This is the commit point. If the decision cannot be recorded, the
+	// pending record makes recovery abort the transaction.
+	if err := s.db.PutTxnRecord(rec); err != nil {
+		rec.State = db.TxnAborted
+		return rec, errors.Join(prepareErr, fmt.Errorf("recording decision: %w", err))
+	}
+	s.finishTxn(rec)
+	return rec, prepareErr
+}
+
+// finishTxn sends a decided transaction's outcome to its participants and
+// forgets the transaction once all of them have applied it. Participants
+// that cannot be reached are retried by recovery.
+func (s *Server) finishTxn(rec db.TxnRecord) {
+	outcome := txnAbort
+	if rec.State == db.TxnCommitted {
+		outcome = txnCommit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.forwardTimeout)
+	defer cancel()
+
+	self := s.topology().Addrs[s.topology().CurIdx]
+	errs := make([]error, len(rec.Participants))
+	var wg sync.WaitGroup
+	for i, addr := range rec.Participants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addr == self {
+				errs[i] = s.decideLocal(rec.ID, outcome)
+			} else {
+				errs[i] = s.sendDecision(ctx, addr, rec.ID, outcome)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("Transaction %s %s is unfinished: %v", rec.ID, rec.State, err)
+		return
+	}
+	if err := s.db.DeleteTxnRecord(rec.ID); err != nil {
+		log.Printf("Failed to delete record of finished transaction %s: %v", rec.ID, err)
+	}
+}
+
+func (s *Server) decideLocal(id, outcome string) error {
+	if outcome == txnCommit {
+		return s.db.CommitIntent(id)
+	}
+	return s.db.AbortIntent(id)
+}
+
+// txnState returns the state of a transaction coordinated by this node.
+func (s *Server) txnState(id string) (string, error) {
+	s.txnMu.Lock()
+	active := s.txns[id]
+	s.txnMu.Unlock()
+
+	rec, err := s.db.GetTxnRecord(id)
+	switch {
+	case errors.Is(err, db.ErrNotFound) && active:
+		return db.TxnPending, nil
+	case errors.Is(err, db.ErrNotFound):
+		return txnUnknown, nil
+	case err != nil:
+		return "", err
+	}
+	return rec.State, nil
+}
+
+// RunTxnRecovery resolves unfinished transactions every TxnRecoveryInterval
+// until ctx is cancelled.
+func (s *Server) RunTxnRecovery(ctx context.Context) {
+	ticker := time.NewTicker(TxnRecoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RecoverTransactions(ctx); err != nil {
+			log.Printf("Transaction recovery: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecoverTransactions resolves transactions left unfinished, typically by a
+// crash. As coordinator, it aborts transactions that were never decided
+// and resends decisions participants have not acknowledged. As participant,
+// it asks the coordinator of each prepared intent for the outcome; intents
+// the coordinator has no record of are aborted.
+func (s *Server) RecoverTransactions(ctx context.Context) error {
+	recs, err := s.db.TxnRecords()
+	if err != nil {
+		return fmt.Errorf("listing transactions: %w", err)
+	}
+	for _, rec := range recs {
+		s.txnMu.Lock()
+		active := s.txns[rec.ID]
+		s.txnMu.Unlock()
+		if active {
+			continue
+		}
+		if rec.State == db.TxnPending {
+			log.Printf("Aborting transaction %s, which was never decided", rec.ID)
+			rec.State = db.TxnAborted
+			if err := s.db.PutTxnRecord(rec); err != nil {
+				return fmt.Errorf("aborting transaction %s: %w", rec.ID, err)
+			}
+		}
+		s.finishTxn(rec)
+	}
+
+	intents, err := s.db.Intents()
+	if err != nil {
+		return fmt.Errorf("listing intents: %w", err)
+	}
+	self := s.topology().Addrs[s.topology().CurIdx]
+	var errs []error
+	for _, in := range intents {
+		var state string
+		if in.Coordinator == self {
+			state, err = s.txnState(in.ID)
+		} else {
+			state, err = s.queryTxnState(ctx, in.Coordinator, in.ID)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("transaction %s: %w", in.ID, err))
+			continue
+		}
+
+		switch state {
+		case db.TxnPending:
+			continue
+		case db.TxnCommitted:
+			err = s.db.CommitIntent(in.ID)
+		default:
+			err = s.db.AbortIntent(in.ID)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("transaction %s: %w", in.ID, err))
+			continue
+		}
+		log.Printf("Resolved in-doubt transaction %s: %s", in.ID, state)
+	}
+	return errors.Join(errs...)
+}
+
+// TxnPrepareHandler serves POST /v1/internal/txn/prepare, preparing a
+// db.Intent sent by a coordinator.
+func (s *Server) TxnPrepareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.raft != nil || s.replica != nil {
+		http.Error(w, "Transactions are only prepared on shard leaders without Raft", http.StatusNotImplemented)
+		return
+	}
+	if !s.checkEpoch(w, r) {
+		return
+	}
+
+	var in db.Intent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&in); err != nil || in.ID == "" {
+		http.Error(w, fmt.Sprintf("Invalid intent: %v", err), http.StatusBadRequest)
+		return
+	}
+	shards := s.topology()
+	for _, op := range in.Ops {
+		if shards.Index(op.Key) != shards.CurIdx {
+			http.Error(w, fmt.Sprintf("Key %q is not owned by shard %d", op.Key, shards.CurIdx), http.StatusMisdirectedRequest)
+			return
+		}
+	}
+
+	if err := s.db.PrepareIntent(in); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to prepare transaction: %v", err), writeStatus(err))
+		return
+	}
+	fmt.Fprint(w, "prepared")
+}
+
+// TxnDecideHandler serves POST /v1/internal/txn/decide?id=&outcome=, where
+// outcome is commit or abort.
+func (s *Server) TxnDecideHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, outcome := r.URL.Query().Get("id"), r.URL.Query().Get("outcome")
+	if id == "" || (outcome != txnCommit && outcome != txnAbort) {
+		http.Error(w, "Missing id or invalid outcome", http.StatusBadRequest)
+		return
+	}
+	if err := s.decideLocal(id, outcome); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to %s transaction: %v", outcome, err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+// TxnStatusHandler serves GET /v1/internal/txn/status?id=, the state of a
+// transaction coordinated by this node: pending, committed, aborted or
+// unknown.
+func (s *Server) TxnStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+	state, err := s.txnState(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read transaction: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TxnResponse{ID: id, State: state})
+}
+
+func (s *Server) sendPrepare(ctx context.Context, addr string, in db.Intent) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, err = s.txnRequest(ctx, http.MethodPost, addr, "/v1/internal/txn/prepare", nil, body)
+	return err
+}
+
+func (s *Server) sendDecision(ctx context.Context, addr, id, outcome string) error {
+	_, err := s.txnRequest(ctx, http.MethodPost, addr, "/v1/internal/txn/decide", url.Values{"id": {id}, "outcome": {outcome}}, nil)
+	return err
+}
+
+func (s *Server) queryTxnState(ctx context.Context, addr, id string) (string, error) {
+	data, err := s.txnRequest(ctx, http.MethodGet, addr, "/v1/internal/txn/status", url.Values{"id": {id}}, nil)
+	if err != nil {
+		return "", err
+	}
+	var resp TxnResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("invalid status from %s: %w", addr, err)
+	}
+	return resp.State, nil
+}
+
+// txnRequest sends a node-to-node transaction request and returns the
+// response body. A 409 from the node is reported as errTxnConflict.
+func (s *Server) txnRequest(ctx context.Context, method, addr, path string, query url.Values, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.forwardTimeout)
+	defer cancel()
+
+	u := "http://" + addr + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(EpochHeader, strconv.FormatUint(s.topology().Epoch, 10))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%w: %s", errTxnConflict, bytes.TrimSpace(data))
+	default:
+		return nil, fmt.Errorf("%s returned %d: %s", addr, resp.StatusCode, bytes.TrimSpace(data))
+	}
+}
+
+func newTxnID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
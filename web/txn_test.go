@@ -0,0 +1,303 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// txnNode is a shard leader that can be killed and restarted on the same
+// address and data directory.
+type txnNode struct {
+	dir    string
+	db     *db.Database
+	closer func() error
+	srv    *web.Server
+	// crashOnDecide makes the node drop the connection and stop answering
+	// when it is sent a transaction outcome, as if it died mid-commit.
+	crashOnDecide atomic.Bool
+	crashed       atomic.Bool
+}
+
+type txnCluster struct {
+	*testNodes
+	nodes []*txnNode
+}
+
+func newTxnCluster(t *testing.T, count int) *txnCluster {
+	t.Helper()
+
+	c := &txnCluster{testNodes: startNodes(t, count), nodes: make([]*txnNode, count)}
+	for i := range count {
+		c.nodes[i] = &txnNode{dir: t.TempDir()}
+		c.start(t, i)
+	}
+	t.Cleanup(func() {
+		for i, n := range c.nodes {
+			if n.db != nil {
+				c.stop(t, i)
+			}
+		}
+	})
+	return c
+}
+
+// start opens node i's database and serves it.
+func (c *txnCluster) start(t *testing.T, i int) {
+	t.Helper()
+	n := c.nodes[i]
+
+	var err error
+	if n.db, n.closer, err = db.NewDatabase(n.dir, false); err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	n.srv = web.NewServer(n.db, &config.Shards{Addrs: c.shardAddrs(), Count: len(c.addrs), CurIdx: i})
+	n.crashOnDecide.Store(false)
+	n.crashed.Store(false)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/{key...}", n.srv.KeyHandler)
+	mux.HandleFunc("/v1/txn", n.srv.TxnHandler)
+	mux.HandleFunc("/v1/internal/txn/prepare", n.srv.TxnPrepareHandler)
+	mux.HandleFunc("/v1/internal/txn/decide", n.srv.TxnDecideHandler)
+	mux.HandleFunc("/v1/internal/txn/status", n.srv.TxnStatusHandler)
+	c.serve(i, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/internal/txn/decide" && n.crashOnDecide.Load() {
+			n.crashed.Store(true)
+		}
+		if n.crashed.Load() {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+// stop kills node i, taking it down and closing its database.
+func (c *txnCluster) stop(t *testing.T, i int) {
+	t.Helper()
+	n := c.nodes[i]
+	c.serve(i, nil)
+	if err := n.closer(); err != nil {
+		t.Errorf("failed to close db: %v", err)
+	}
+	n.db = nil
+}
+
+func runTxn(t *testing.T, base string, ops []db.BatchOp) (int, web.TxnResponse) {
+	t.Helper()
+
+	body, err := json.Marshal(web.TxnRequest{Ops: ops})
+	if err != nil {
+		t.Fatalf("failed to encode transaction: %v", err)
+	}
+	resp, data := doRequest(t, http.MethodPost, base+"/v1/txn", body, nil)
+	var res web.TxnResponse
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusServiceUnavailable {
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatalf("invalid transaction response %q: %v", data, err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func assertValue(t *testing.T, database *db.Database, key, want string) {
+	t.Helper()
+	got, err := database.GetKey(key)
+	if want == "" {
+		if !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("GetKey(%s) = %q, %v; want not found", key, got, err)
+		}
+		return
+	}
+	if err != nil || string(got) != want {
+		t.Errorf("GetKey(%s) = %q, %v; want %q", key, got, err, want)
+	}
+}
+
+func TestTxnCommitsAcrossShards(t *testing.T) {
+	c := newTxnCluster(t, 3)
+	k0, k1, k2 := keyForShard(3, 0), keyForShard(3, 1), keyForShard(3, 2)
+	if err := c.nodes[2].db.SetKey(k2, []byte("old")); err != nil {
+		t.Fatalf("SetKey failed: %v", err)
+	}
+
+	status, res := runTxn(t, c.urls[1], []db.BatchOp{
+		{Op: db.BatchPut, Key: k0, Value: []byte("v0")},
+		{Op: db.BatchPut, Key: k1, Value: []byte("v1")},
+		{Op: db.BatchDelete, Key: k2},
+	})
+	if status != http.StatusOK || res.State != db.TxnCommitted || res.ID == "" {
+		t.Fatalf("transaction returned %d %+v", status, res)
+	}
+	assertValue(t, c.nodes[0].db, k0, "v0")
+	assertValue(t, c.nodes[1].db, k1, "v1")
+	assertValue(t, c.nodes[2].db, k2, "")
+
+	for i, n := range c.nodes {
+		if intents, _ := n.db.Intents(); len(intents) != 0 {
+			t.Errorf("node %d still holds intents %+v", i, intents)
+		}
+	}
+	if recs, _ := c.nodes[1].db.TxnRecords(); len(recs) != 0 {
+		t.Errorf("coordinator kept records of a finished transaction: %+v", recs)
+	}
+
+	for _, ops := range [][]db.BatchOp{
+		nil,
+		{{Op: db.BatchGet, Key: k0}},
+		{{Op: db.BatchPut}},
+	} {
+		if status, _ := runTxn(t, c.urls[0], ops); status != http.StatusBadRequest {
+			t.Errorf("transaction %+v returned %d, want 400", ops, status)
+		}
+	}
+}
+
+func TestTxnConflictsWithPreparedTxn(t *testing.T) {
+	c := newTxnCluster(t, 2)
+	k0, k1 := keyForShard(2, 0), keyForShard(2, 1)
+
+	// Another coordinator's transaction holds k1.
+	err := c.nodes[1].db.PrepareIntent(db.Intent{ID: "other", Coordinator: c.addrs[0], Ops: []db.BatchOp{{Op: db.BatchPut, Key: k1, Value: []byte("x")}}})
+	if err != nil {
+		t.Fatalf("PrepareIntent failed: %v", err)
+	}
+
+	status, res := runTxn(t, c.urls[0], []db.BatchOp{
+		{Op: db.BatchPut, Key: k0, Value: []byte("v0")},
+		{Op: db.BatchPut, Key: k1, Value: []byte("v1")},
+	})
+	if status != http.StatusConflict || res.State != db.TxnAborted {
+		t.Fatalf("conflicting transaction returned %d %+v", status, res)
+	}
+	assertValue(t, c.nodes[0].db, k0, "")
+
+	// The aborted transaction released k0; the prepared one still holds k1.
+	if resp, _ := doRequest(t, http.MethodPut, c.urls[0]+"/v1/keys/"+k0, []byte("plain"), nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("PUT to released key returned %d", resp.StatusCode)
+	}
+	if resp, _ := doRequest(t, http.MethodPut, c.urls[0]+"/v1/keys/"+k1, []byte("plain"), nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("PUT to locked key returned %d, want 409", resp.StatusCode)
+	}
+}
+
+func TestTxnAbortsWhenParticipantIsDown(t *testing.T) {
+	c := newTxnCluster(t, 2)
+	k0, k1 := keyForShard(2, 0), keyForShard(2, 1)
+	c.stop(t, 1)
+
+	status, res := runTxn(t, c.urls[0], []db.BatchOp{
+		{Op: db.BatchPut, Key: k0, Value: []byte("v0")},
+		{Op: db.BatchPut, Key: k1, Value: []byte("v1")},
+	})
+	if status != http.StatusServiceUnavailable || res.State != db.TxnAborted {
+		t.Fatalf("transaction with a dead participant returned %d %+v", status, res)
+	}
+	assertValue(t, c.nodes[0].db, k0, "")
+	if err := c.nodes[0].db.SetKey(k0, []byte("plain")); err != nil {
+		t.Errorf("aborted transaction left %s locked: %v", k0, err)
+	}
+
+	// Once the participant is back, the coordinator finishes the abort.
+	c.start(t, 1)
+	if err := c.nodes[0].srv.RecoverTransactions(context.Background()); err != nil {
+		t.Fatalf("recovery failed: %v", err)
+	}
+	if recs, _ := c.nodes[0].db.TxnRecords(); len(recs) != 0 {
+		t.Errorf("coordinator kept records after recovery: %+v", recs)
+	}
+	assertValue(t, c.nodes[1].db, k1, "")
+}
+
+func TestTxnRecoversParticipantKilledMidCommit(t *testing.T) {
+	c := newTxnCluster(t, 3)
+	k0, k1, k2 := keyForShard(3, 0), keyForShard(3, 1), keyForShard(3, 2)
+
+	// Shard 1 dies after preparing, before it applies the commit.
+	c.nodes[1].crashOnDecide.Store(true)
+	status, res := runTxn(t, c.urls[0], []db.BatchOp{
+		{Op: db.BatchPut, Key: k0, Value: []byte("v0")},
+		{Op: db.BatchPut, Key: k1, Value: []byte("v1")},
+		{Op: db.BatchPut, Key: k2, Value: []byte("v2")},
+	})
+	if status != http.StatusOK || res.State != db.TxnCommitted {
+		t.Fatalf("transaction returned %d %+v", status, res)
+	}
+	if !c.nodes[1].crashed.Load() {
+		t.Fatalf("participant was not sent the outcome")
+	}
+	c.stop(t, 1)
+	assertValue(t, c.nodes[0].db, k0, "v0")
+	assertValue(t, c.nodes[2].db, k2, "v2")
+
+	// The dead participant's intent survived, so it recovers the commit from
+	// the coordinator after a restart.
+	c.start(t, 1)
+	if intents, _ := c.nodes[1].db.Intents(); len(intents) != 1 || intents[0].ID != res.ID {
+		t.Fatalf("restarted participant has intents %+v, want %s", intents, res.ID)
+	}
+	if err := c.nodes[1].srv.RecoverTransactions(context.Background()); err != nil {
+		t.Fatalf("participant recovery failed: %v", err)
+	}
+	assertValue(t, c.nodes[1].db, k1, "v1")
+	if err := c.nodes[1].db.SetKey(k1, []byte("after")); err != nil {
+		t.Errorf("recovered transaction left %s locked: %v", k1, err)
+	}
+
+	// The coordinator forgets the transaction once everyone has applied it.
+	if recs, _ := c.nodes[0].db.TxnRecords(); len(recs) != 1 {
+		t.Fatalf("coordinator has records %+v, want the unfinished transaction", recs)
+	}
+	if err := c.nodes[0].srv.RecoverTransactions(context.Background()); err != nil {
+		t.Fatalf("coordinator recovery failed: %v", err)
+	}
+	if recs, _ := c.nodes[0].db.TxnRecords(); len(recs) != 0 {
+		t.Errorf("coordinator kept records after recovery: %+v", recs)
+	}
+}
+
+func TestTxnRecoveryAbortsUndecided(t *testing.T) {
+	c := newTxnCluster(t, 2)
+	k0, k1 := keyForShard(2, 0), keyForShard(2, 1)
+
+	// The coordinator crashed before deciding: its record is still pending
+	// and its participant holds an intent.
+	if err := c.nodes[0].db.PutTxnRecord(db.TxnRecord{ID: "undecided", State: db.TxnPending, Participants: []string{c.addrs[1]}, Started: time.Now()}); err != nil {
+		t.Fatalf("PutTxnRecord failed: %v", err)
+	}
+	if err := c.nodes[1].db.PrepareIntent(db.Intent{ID: "undecided", Coordinator: c.addrs[0], Ops: []db.BatchOp{{Op: db.BatchPut, Key: k1, Value: []byte("x")}}}); err != nil {
+		t.Fatalf("PrepareIntent failed: %v", err)
+	}
+	// An intent whose coordinator has no record of it never committed.
+	if err := c.nodes[0].db.PrepareIntent(db.Intent{ID: "orphan", Coordinator: c.addrs[1], Ops: []db.BatchOp{{Op: db.BatchPut, Key: k0, Value: []byte("x")}}}); err != nil {
+		t.Fatalf("PrepareIntent failed: %v", err)
+	}
+
+	for i := range c.nodes {
+		if err := c.nodes[i].srv.RecoverTransactions(context.Background()); err != nil {
+			t.Fatalf("recovery on node %d failed: %v", i, err)
+		}
+	}
+	for i, n := range c.nodes {
+		if intents, _ := n.db.Intents(); len(intents) != 0 {
+			t.Errorf("node %d still holds intents %+v", i, intents)
+		}
+	}
+	if recs, _ := c.nodes[0].db.TxnRecords(); len(recs) != 0 {
+		t.Errorf("coordinator kept records after recovery: %+v", recs)
+	}
+	assertValue(t, c.nodes[0].db, k0, "")
+	assertValue(t, c.nodes[1].db, k1, "")
+}
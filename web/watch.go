@@ -0,0 +1,412 @@
+package web
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sagor0078/distribKV/db"
+)
+
+const (
+	// watchKeepAlive is how often an idle watch stream sends a comment, so
+	// proxies and clients can tell it is still alive.
+	watchKeepAlive = 15 * time.Second
+	// watchBatch is how many log entries a watch reads at a time.
+	watchBatch = 100
+)
+
+// Watch event types.
+const (
+	WatchPut    = "put"
+	WatchDelete = "delete"
+)
+
+// WatchEvent is a change to a watched key, sent as the data of a
+// Server-Sent Event of the same type.
+type WatchEvent struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	Shard int    `json:"shard"`
+	// Revision is the position of the write in its shard's log.
+	Revision uint64 `json:"revision"`
+}
+
+// WatchHandler serves GET /v1/watch?prefix=&rev=, a Server-Sent Events
+// stream of the puts and deletes of keys with the given prefix (every key
+// if it is empty), across all shards, in write order within each shard.
+//
+// The id of every event is a cursor with the revision reached on each
+// shard, such as "0:12,1:40". A client that reconnects with it as the
+// Last-Event-ID header or the rev parameter resumes after the last event it
+// saw; without one, the watch starts with the next write. Events are read
+// from the shard leaders' replication logs, which keep their latest entries
+// (see WithLogRetention) and those open watches have yet to read. A cursor
+// older than what the logs still hold is refused with 410 Gone, and the
+// client has to read the keys again before watching from the present.
+//
+// Raft groups apply writes without a replication log, so nodes started
+// with -raft answer 501 Not Implemented.
+func (s *Server) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.raft != nil {
+		http.Error(w, "Watches are not supported with Raft", http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	local := query.Get("local") == "true"
+	if local && s.replica != nil {
+		http.Error(w, "Watches are served by shard leaders", http.StatusMisdirectedRequest)
+		return
+	}
+	cursor, err := parseCursor(cmp.Or(query.Get("rev"), r.Header.Get("Last-Event-ID")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.checkEpoch(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	prefix := query.Get("prefix")
+	shards := s.topology()
+
+	// Open every shard's stream before answering, so a refused cursor is
+	// reported with its status.
+	var sources []func(chan<- WatchEvent) error
+	for idx, addr := range shards.Addrs {
+		if local && idx != shards.CurIdx {
+			continue
+		}
+		after, resume := cursor[idx]
+		if idx == shards.CurIdx && s.replica == nil {
+			if !resume {
+				after = s.db.LastSeq()
+			}
+			pin := s.pinLog(after)
+			defer s.unpinLog(pin)
+			if err := s.checkRevision(after); err != nil {
+				http.Error(w, err.Error(), revisionStatus(err))
+				return
+			}
+			sources = append(sources, func(out chan<- WatchEvent) error {
+				return s.watchLocal(ctx, idx, prefix, pin, out)
+			})
+			continue
+		}
+
+		if idx == shards.CurIdx {
+			addr = s.replica.LeaderAddr()
+		}
+		body, err := s.openWatch(ctx, addr, prefix, idx, after, resume, shards.Epoch)
+		if err != nil {
+			var statusErr *watchStatusError
+			if errors.As(err, &statusErr) {
+				http.Error(w, statusErr.Error(), statusErr.code)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to watch shard %d: %v", idx, err), http.StatusBadGateway)
+			return
+		}
+		sources = append(sources, func(out chan<- WatchEvent) error {
+			defer body.Close()
+			return readWatch(ctx, body, out)
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(EpochHeader, strconv.FormatUint(shards.Epoch, 10))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := make(chan WatchEvent)
+	errc := make(chan error, len(sources))
+	for _, source := range sources {
+		go func() { errc <- source(events) }()
+	}
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev := <-events:
+			cursor[ev.Shard] = ev.Revision
+			if err := writeWatchEvent(w, cursor, ev); err != nil {
+				return
+			}
+		case err := <-errc:
+			// The client reconnects with its last cursor.
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Watch of %q ended: %v", prefix, err)
+			}
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeWatchEvent writes ev as a Server-Sent Event with cursor as its id. An
+// event without a type only advances the client's cursor.
+func writeWatchEvent(w io.Writer, cursor map[int]uint64, ev WatchEvent) error {
+	if ev.Type == "" {
+		_, err := fmt.Fprintf(w, "id: %s\n\n", formatCursor(cursor))
+		return err
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", formatCursor(cursor), ev.Type, data)
+	return err
+}
+
+// checkRevision returns an error unless this shard's log can be watched
+// after the given revision.
+func (s *Server) checkRevision(after uint64) error {
+	if last := s.db.LastSeq(); after > last {
+		return fmt.Errorf("revision %d is ahead of shard %d, which is at %d", after, s.topology().CurIdx, last)
+	}
+	_, err := s.db.ReplicationLog(after, 1)
+	return err
+}
+
+func revisionStatus(err error) int {
+	if errors.Is(err, db.ErrLogTruncated) {
+		return http.StatusGone
+	}
+	return http.StatusBadRequest
+}
+
+// watchLocal sends the writes to keys with prefix logged on this shard
+// after the revision pin holds to out, starting with an event without a
+// type that reports the revision, until ctx is done. It moves pin along as
+// it reads the log.
+func (s *Server) watchLocal(ctx context.Context, shard int, prefix string, pin *watchPin, out chan<- WatchEvent) error {
+	after := s.pinned(pin)
+	progress := WatchEvent{Shard: shard, Revision: after}
+	if err := sendEvent(ctx, out, progress); err != nil {
+		return err
+	}
+	for {
+		entries, err := s.db.ReplicationLog(after, watchBatch)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := s.db.WaitForWrite(ctx, after); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, e := range entries {
+			after = e.Seq
+			if !strings.HasPrefix(e.Key, prefix) {
+				continue
+			}
+			ev := WatchEvent{Type: WatchPut, Key: e.Key, Value: e.Value, Shard: shard, Revision: e.Seq}
+			if e.Op == db.OpDelete {
+				ev.Type, ev.Value = WatchDelete, nil
+			}
+			if err := sendEvent(ctx, out, ev); err != nil {
+				return err
+			}
+			progress.Revision = e.Seq
+		}
+		s.movePin(pin, after)
+		// Move the cursor past writes to other keys, so a client resuming
+		// does not have to read them again.
+		if progress.Revision != after {
+			progress.Revision = after
+			if err := sendEvent(ctx, out, progress); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchPin is the log position a local watch has read up to. The log is
+// not trimmed past it while the watch runs.
+type watchPin struct {
+	after uint64
+}
+
+// pinLog keeps the log entries after position after until unpinLog.
+func (s *Server) pinLog(after uint64) *watchPin {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	p := &watchPin{after: after}
+	s.watchPins[p] = struct{}{}
+	return p
+}
+
+// pinned returns the position p keeps the log after.
+func (s *Server) pinned(p *watchPin) uint64 {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	return p.after
+}
+
+// movePin lets the log be trimmed up to after, which p's watch has read.
+func (s *Server) movePin(p *watchPin, after uint64) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	p.after = after
+}
+
+func (s *Server) unpinLog(p *watchPin) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	delete(s.watchPins, p)
+}
+
+func sendEvent(ctx context.Context, out chan<- WatchEvent, ev WatchEvent) error {
+	select {
+	case out <- ev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// watchStatusError is a watch refused by another node.
+type watchStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *watchStatusError) Error() string {
+	return e.msg
+}
+
+// openWatch opens the local watch stream of shard on the node at addr.
+func (s *Server) openWatch(ctx context.Context, addr, prefix string, shard int, after uint64, resume bool, epoch uint64) (io.ReadCloser, error) {
+	q := url.Values{"prefix": {prefix}, "local": {"true"}}
+	if resume {
+		q.Set("rev", formatCursor(map[int]uint64{shard: after}))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/v1/watch?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(EpochHeader, strconv.FormatUint(epoch, 10))
+	// Unlike forwarded requests, the stream is not bounded by a timeout.
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &watchStatusError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return resp.Body, nil
+}
+
+// readWatch sends the events of a local watch stream to out until it ends.
+func readWatch(ctx context.Context, body io.Reader, out chan<- WatchEvent) error {
+	br := bufio.NewReader(body)
+	var id string
+	var data []byte
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("watch stream closed")
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = []byte(strings.TrimPrefix(line, "data: "))
+		case line == "" && data != nil:
+			var ev WatchEvent
+			if err := json.Unmarshal(data, &ev); err != nil {
+				return fmt.Errorf("invalid watch event: %w", err)
+			}
+			if err := sendEvent(ctx, out, ev); err != nil {
+				return err
+			}
+			id, data = "", nil
+		case line == "" && id != "":
+			cursor, err := parseCursor(id)
+			if err != nil {
+				return err
+			}
+			for shard, rev := range cursor {
+				if err := sendEvent(ctx, out, WatchEvent{Shard: shard, Revision: rev}); err != nil {
+					return err
+				}
+			}
+			id = ""
+		}
+	}
+}
+
+// parseCursor parses a watch cursor such as "0:12,1:40", mapping shards to
+// the revision reached on them.
+func parseCursor(s string) (map[int]uint64, error) {
+	cursor := make(map[int]uint64)
+	if s == "" {
+		return cursor, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		shard, rev, ok := strings.Cut(strings.TrimSpace(part), ":")
+		idx, err := strconv.Atoi(shard)
+		if !ok || err != nil || idx < 0 {
+			return nil, fmt.Errorf("invalid watch cursor %q", s)
+		}
+		if cursor[idx], err = strconv.ParseUint(rev, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid watch cursor %q", s)
+		}
+	}
+	return cursor, nil
+}
+
+func formatCursor(cursor map[int]uint64) string {
+	shards := make([]int, 0, len(cursor))
+	for idx := range cursor {
+		shards = append(shards, idx)
+	}
+	sort.Ints(shards)
+
+	parts := make([]string, len(shards))
+	for i, idx := range shards {
+		parts[i] = fmt.Sprintf("%d:%d", idx, cursor[idx])
+	}
+	return strings.Join(parts, ",")
+}
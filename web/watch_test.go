@@ -0,0 +1,174 @@
+package web_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sagor0078/distribKV/config"
+	"github.com/Sagor0078/distribKV/web"
+)
+
+// watchStream reads the events of a watch, remembering the last event id.
+type watchStream struct {
+	resp   *http.Response
+	events chan web.WatchEvent
+	lastID chan string
+}
+
+func openWatch(t *testing.T, url string, header http.Header) *watchStream {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %d", url, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("watch Content-Type is %q", ct)
+	}
+
+	ws := &watchStream{resp: resp, events: make(chan web.WatchEvent, 100), lastID: make(chan string, 1)}
+	go func() {
+		defer close(ws.events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if id, ok := strings.CutPrefix(line, "id: "); ok {
+				select {
+				case <-ws.lastID:
+				default:
+				}
+				ws.lastID <- id
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var ev web.WatchEvent
+				if err := json.Unmarshal([]byte(data), &ev); err != nil {
+					t.Errorf("invalid watch event %q: %v", data, err)
+					return
+				}
+				ws.events <- ev
+			}
+		}
+	}()
+	return ws
+}
+
+func (ws *watchStream) next(t *testing.T) web.WatchEvent {
+	t.Helper()
+
+	select {
+	case ev, ok := <-ws.events:
+		if !ok {
+			t.Fatal("watch stream ended")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a watch event")
+	}
+	return web.WatchEvent{}
+}
+
+// close stops the watch and returns the id of the last event it read.
+func (ws *watchStream) close() string {
+	ws.resp.Body.Close()
+	for range ws.events {
+	}
+	return <-ws.lastID
+}
+
+func TestWatchHandler(t *testing.T) {
+	urls := newRESTCluster(t, 2)
+	key0, key1 := keyForShard(2, 0), keyForShard(2, 1)
+
+	ws := openWatch(t, urls[0]+"/v1/watch?prefix=key-", nil)
+	doRequest(t, http.MethodPut, urls[0]+"/v1/keys/other", []byte("ignored"), nil)
+	doRequest(t, http.MethodPut, urls[0]+"/v1/keys/"+key0, []byte("a"), nil)
+	doRequest(t, http.MethodPut, urls[0]+"/v1/keys/"+key1, []byte("b"), nil)
+	doRequest(t, http.MethodDelete, urls[1]+"/v1/keys/"+key1, nil, nil)
+
+	// Events are ordered within a shard, not across shards.
+	got := map[string][]string{}
+	for range 3 {
+		ev := ws.next(t)
+		got[ev.Key] = append(got[ev.Key], ev.Type+":"+string(ev.Value))
+		if want := map[string]int{key0: 0, key1: 1}[ev.Key]; ev.Shard != want || ev.Revision == 0 {
+			t.Errorf("event %+v, want shard %d and a revision", ev, want)
+		}
+	}
+	if strings.Join(got[key0], ",") != "put:a" || strings.Join(got[key1], ",") != "put:b,delete:" {
+		t.Fatalf("watch events = %v", got)
+	}
+	lastID := ws.close()
+
+	// A watcher that reconnects with its last id gets what it missed.
+	doRequest(t, http.MethodPut, urls[1]+"/v1/keys/"+key1, []byte("c"), nil)
+	ws = openWatch(t, urls[1]+"/v1/watch?prefix=key-", http.Header{"Last-Event-ID": {lastID}})
+	if ev := ws.next(t); ev.Key != key1 || ev.Type != web.WatchPut || string(ev.Value) != "c" {
+		t.Errorf("resumed watch returned %+v, want put of %s", ev, key1)
+	}
+	ws.close()
+
+	for _, rev := range []string{"x", "0:1000"} {
+		if resp, _ := doRequest(t, http.MethodGet, urls[0]+"/v1/watch?rev="+rev, nil, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("watch from %q returned %d, want 400", rev, resp.StatusCode)
+		}
+	}
+	if resp, _ := doRequest(t, http.MethodPost, urls[0]+"/v1/watch", nil, nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /v1/watch returned %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestWatchResumesAfterLogTrim(t *testing.T) {
+	database := createTempDB(t, 0)
+	server := web.NewServer(database, &config.Shards{
+		Addrs:    map[int]string{0: "leader"},
+		Replicas: map[int][]string{0: {"r1"}},
+		Count:    1,
+		CurIdx:   0,
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+	mux.HandleFunc("/v1/watch", server.WatchHandler)
+	mux.HandleFunc("/replication-ack", server.ReplicationAckHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ws := openWatch(t, ts.URL+"/v1/watch", nil)
+	doRequest(t, http.MethodPut, ts.URL+"/v1/keys/a", []byte("1"), nil)
+	if ev := ws.next(t); ev.Key != "a" {
+		t.Fatalf("watch returned %+v, want put of a", ev)
+	}
+	lastID := ws.close()
+
+	// The replica acknowledges everything and the log is trimmed, but the
+	// latest entries are kept for watches to resume from.
+	doRequest(t, http.MethodPut, ts.URL+"/v1/keys/b", []byte("2"), nil)
+	seq := strconv.FormatUint(database.LastSeq(), 10)
+	if resp, body := doRequest(t, http.MethodPost, ts.URL+"/replication-ack?replica=r1&seq="+seq, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("ack returned %d: %s", resp.StatusCode, body)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	server.RunLogTrim(ctx)
+
+	ws = openWatch(t, ts.URL+"/v1/watch", http.Header{"Last-Event-ID": {lastID}})
+	if ev := ws.next(t); ev.Key != "b" || string(ev.Value) != "2" {
+		t.Errorf("resumed watch returned %+v, want put of b", ev)
+	}
+	ws.close()
+}
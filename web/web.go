@@ -1,101 +1,357 @@
 package web
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/Sagor0078/distribKV/balancer"
 	"github.com/Sagor0078/distribKV/config"
 	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/raft"
 	"github.com/Sagor0078/distribKV/replication"
 )
 
 // Server contains HTTP handlers to interact with the key-value store.
 type Server struct {
-	db     *db.Database
-	shards *config.Shards
+	db      *db.Database
+	raft    *raft.Node
+	replica *replication.Client
+	// balancer spreads reads for other shards over their replicas.
+	balancer *balancer.Balancer
+
+	// topoMu guards the routing topology, which changes during resharding.
+	topoMu       sync.RWMutex
+	shards       *config.Shards
+	migration    *migration
+	topologyFile string
+	lastReload   time.Time
+
+	// client is shared by all requests to other nodes.
+	client         *http.Client
+	forwardTimeout time.Duration
+	redirects      bool
+
+	// txnMu guards txns, the transactions this node is coordinating.
+	txnMu sync.Mutex
+	txns  map[string]bool
+
+	// incrMu serializes increments through Raft, which would otherwise
+	// fail each other's conditions.
+	incrMu sync.Mutex
+
+	// logRetention is how many of the latest log entries are kept after
+	// the replicas acknowledged them, for watches to resume from.
+	logRetention uint64
+	// watchMu guards watchPins, the log positions of the local watches,
+	// past which the log is not trimmed.
+	watchMu   sync.Mutex
+	watchPins map[*watchPin]struct{}
+}
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithRaft commits writes through the shard group's Raft log instead of
+// writing them straight to the local database.
+func WithRaft(node *raft.Node) Option {
+	return func(s *Server) {
+		s.raft = node
+	}
 }
 
 // NewServer creates a new HTTP server instance with database and shard metadata.
-func NewServer(db *db.Database, shards *config.Shards) *Server {
-	return &Server{
-		db:     db,
-		shards: shards,
+func NewServer(db *db.Database, shards *config.Shards, opts ...Option) *Server {
+	s := &Server{
+		db:             db,
+		shards:         shards,
+		client:         newHTTPClient(),
+		forwardTimeout: defaultForwardTimeout,
+		txns:           make(map[string]bool),
+		logRetention:   defaultLogRetention,
+		watchPins:      make(map[*watchPin]struct{}),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-// redirect forwards a request to the correct shard based on key hash.
-func (s *Server) redirect(shard int, w http.ResponseWriter, r *http.Request) {
-	target := "http://" + s.shards.Addrs[shard] + r.RequestURI
-	log.Printf("Redirecting request to shard %d → %d (%s)", s.shards.CurIdx, shard, target)
+// topology returns the shard layout requests are currently routed by.
+func (s *Server) topology() *config.Shards {
+	s.topoMu.RLock()
+	defer s.topoMu.RUnlock()
+	return s.shards
+}
 
-	resp, err := http.Get(target)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error redirecting request: %v", err)
-		return
+// setTopologyLocked switches routing to shards. topoMu must be held.
+func (s *Server) setTopologyLocked(shards *config.Shards) {
+	s.shards = shards
+	if s.balancer != nil {
+		s.balancer.Update(shards)
 	}
-	defer resp.Body.Close()
+}
 
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+// route reports whether key is owned by this shard. Otherwise the request
+// has been forwarded to the owning shard, or refused because this node's
+// topology is older than the sender's, and the caller must return.
+func (s *Server) route(key string, w http.ResponseWriter, r *http.Request) bool {
+	return s.routeKey(key, false, w, r)
 }
 
-// GetHandler handles GET requests for a key.
+// routeRead is route for reads, which may be sent to any healthy replica
+// of the owning shard when the server balances reads.
+func (s *Server) routeRead(key string, w http.ResponseWriter, r *http.Request) bool {
+	return s.routeKey(key, true, w, r)
+}
+
+func (s *Server) routeKey(key string, read bool, w http.ResponseWriter, r *http.Request) bool {
+	if !s.checkEpoch(w, r) {
+		return false
+	}
+
+	shards := s.topology()
+	shard := shards.Index(key)
+	if shard == shards.CurIdx {
+		w.Header().Set(EpochHeader, strconv.FormatUint(shards.Epoch, 10))
+		return true
+	}
+	s.redirect(shards, shard, read, w, r)
+	return false
+}
+
+// redirect forwards a request to the correct shard based on key hash. Reads
+// go to a replica picked by the balancer, if any, unless they ask for
+// strong consistency.
+func (s *Server) redirect(shards *config.Shards, shard int, read bool, w http.ResponseWriter, r *http.Request) {
+	if read && s.balancer != nil && r.URL.Query().Get("consistency") != ConsistencyStrong {
+		if be := s.balancer.Pick(shard); be != nil {
+			log.Printf("Redirecting read to shard %d → %d at %s", shards.CurIdx, shard, be.Addr)
+			s.forwardTo(be, w, r)
+			return
+		}
+	}
+	log.Printf("Redirecting request to shard %d → %d", shards.CurIdx, shard)
+	s.forward(shards.Addrs[shard], w, r)
+}
+
+// GetHandler handles GET requests for a key. The key is read from the
+// query string only, leaving any body intact for the request to be
+// forwarded.
 func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	key := r.Form.Get("key")
+	key := r.URL.Query().Get("key")
 	if key == "" {
 		http.Error(w, "Missing key", http.StatusBadRequest)
 		return
 	}
+	if db.IsReservedKey(key) {
+		http.Error(w, fmt.Sprintf("Invalid key: %v", db.ErrReservedKey), http.StatusBadRequest)
+		return
+	}
 
-	shard := s.shards.Index(key)
-	if shard != s.shards.CurIdx {
-		s.redirect(shard, w, r)
+	if !s.routeRead(key, w, r) || !s.readable(w, r) {
 		return
 	}
 
-	val, err := s.db.GetKey(key)
-	if err != nil {
+	e, err := s.readKey(r.Context(), key)
+	if errors.Is(err, db.ErrNotFound) {
 		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	fmt.Fprintf(w, "Value: %s", val)
+	setTTLHeader(w, e)
+	fmt.Fprintf(w, "Value: %s", e.Value)
 }
 
-// SetHandler handles write requests for a key-value pair.
+// SetHandler handles write requests for a key-value pair. The pair is read
+// from the query string or form, or from a JSON body with "key" and "value"
+// fields. An optional "ttl", a duration such as "90s" or a number of
+// seconds, makes the key expire.
 func (s *Server) SetHandler(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	key := r.Form.Get("key")
-	value := r.Form.Get("value")
+	req, err := parseSetRequest(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	key, value := req.Key, req.Value
 	if key == "" || value == "" {
 		http.Error(w, "Missing key or value", http.StatusBadRequest)
 		return
 	}
+	if db.IsReservedKey(key) {
+		http.Error(w, fmt.Sprintf("Invalid key: %v", db.ErrReservedKey), http.StatusBadRequest)
+		return
+	}
+	var expiresAt uint64
+	if req.TTL != "" {
+		ttl, err := parseTTL(req.TTL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		expiresAt = db.ExpiryAfter(ttl)
+	}
 
-	shard := s.shards.Index(key)
-	if shard != s.shards.CurIdx {
-		s.redirect(shard, w, r)
+	if !s.route(key, w, r) || !s.writable(w, r) {
 		return
 	}
 
-	err := s.db.SetKey(key, []byte(value))
+	if s.raft != nil {
+		if _, ok := s.propose(w, r, replication.Command{Op: replication.OpSet, Key: key, Value: []byte(value), ExpiresAt: expiresAt}); !ok {
+			return
+		}
+	} else if _, err := s.db.SetKeyExpiring(key, []byte(value), expiresAt, db.Condition{}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set key: %v", err), writeStatus(err))
+		return
+	}
+
+	s.setWriteToken(w)
+	fmt.Fprintf(w, "Key set successfully on shard %d", s.topology().CurIdx)
+}
+
+// setRequest is the body of a /set request.
+type setRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   string `json:"ttl"`
+}
+
+// parseSetRequest reads a /set request. The body is buffered and restored
+// so the request can still be forwarded.
+func parseSetRequest(r *http.Request) (setRequest, error) {
+	var req setRequest
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxValueSize+1))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to set key: %v", err), http.StatusInternalServerError)
+		return req, err
+	}
+	if len(body) > maxValueSize {
+		return req, fmt.Errorf("body larger than %d bytes", maxValueSize)
+	}
+	restore := func() { r.Body = io.NopCloser(bytes.NewReader(body)) }
+	defer restore()
+
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, err
+		}
+		q := r.URL.Query()
+		req.Key, req.Value, req.TTL = cmp.Or(req.Key, q.Get("key")), cmp.Or(req.Value, q.Get("value")), cmp.Or(req.TTL, q.Get("ttl"))
+		return req, nil
+	}
+
+	restore()
+	if err := r.ParseForm(); err != nil {
+		return req, err
+	}
+	return setRequest{Key: r.Form.Get("key"), Value: r.Form.Get("value"), TTL: r.Form.Get("ttl")}, nil
+}
+
+// DeleteHandler handles DELETE requests for a key.
+func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	key := r.Form.Get("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	if db.IsReservedKey(key) {
+		http.Error(w, fmt.Sprintf("Invalid key: %v", db.ErrReservedKey), http.StatusBadRequest)
+		return
+	}
+
+	if !s.route(key, w, r) || !s.writable(w, r) {
+		return
+	}
+
+	if s.raft != nil {
+		if _, ok := s.propose(w, r, replication.Command{Op: replication.OpDelete, Key: key}); !ok {
+			return
+		}
+	} else if err := s.db.DeleteKey(key); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete key: %v", err), writeStatus(err))
 		return
 	}
+	if err := s.deletePrevious(r.Context(), key); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete key from previous owner: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	s.setWriteToken(w)
+	fmt.Fprintf(w, "Key deleted successfully on shard %d", s.topology().CurIdx)
+}
+
+// propose commits a write through Raft, forwarding it to the group leader
+// when this node is a follower. It returns the result of applying the
+// write and reports whether it was committed here; otherwise the response
+// has already been written.
+func (s *Server) propose(w http.ResponseWriter, r *http.Request, cmd replication.Command) (any, bool) {
+	data, err := cmd.Encode()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode command: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	result, err := s.raft.Propose(r.Context(), data)
+	return result, s.proposed(w, r, cmd.Op, err)
+}
 
-	fmt.Fprintf(w, "Key set successfully on shard %d", shard)
+// proposed handles the outcome err of proposing an op as propose does.
+func (s *Server) proposed(w http.ResponseWriter, r *http.Request, op string, err error) bool {
+	var notLeader *raft.NotLeaderError
+	if errors.As(err, &notLeader) {
+		if notLeader.Leader == "" {
+			http.Error(w, "No leader elected for this shard", http.StatusServiceUnavailable)
+			return false
+		}
+		s.forward(notLeader.Leader, w, r)
+		return false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to commit %s: %v", op, err), writeStatus(err))
+		return false
+	}
+	return true
+}
+
+// writeStatus returns the status for a failed write: 409 Conflict while a
+// prepared transaction holds the key or for an increment of a value that is
+// not a counter, 412 Precondition Failed if a conditional write's condition
+// does not hold, 500 otherwise.
+func writeStatus(err error) int {
+	switch {
+	case errors.Is(err, db.ErrLocked), isCounterError(err):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrConditionFailed):
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
 }
 
 // DeleteExtraKeysHandler deletes keys that don't belong to the current shard.
 func (s *Server) DeleteExtraKeysHandler(w http.ResponseWriter, r *http.Request) {
+	shards := s.topology()
 	err := s.db.DeleteExtraKeys(func(key string) bool {
-		return s.shards.Index(key) != s.shards.CurIdx
+		return shards.Index(key) != shards.CurIdx
 	})
 
 	if err != nil {
@@ -106,34 +362,188 @@ func (s *Server) DeleteExtraKeysHandler(w http.ResponseWriter, r *http.Request)
 	fmt.Fprintf(w, "Extra keys deleted successfully")
 }
 
-// GetNextKeyForReplication serves the next key to be replicated to followers.
-func (s *Server) GetNextKeyForReplication(w http.ResponseWriter, r *http.Request) {
-	key, value, err := s.db.GetNextKeyForReplication()
-	res := &replication.NextKeyValue{
-		Key:   string(key),
-		Value: string(value),
-		Err:   err,
+const (
+	defaultStreamBatch = 1000
+	maxStreamBatch     = 10000
+	maxStreamWait      = 30 * time.Second
+)
+
+// ReplicationStreamHandler serves a batch of replication log entries after
+// the sequence number given in "from". With "wait" set, the request is held
+// open until new writes arrive or the wait elapses (long polling).
+func (s *Server) ReplicationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	from, err := parseUintParam(r, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultStreamBatch
+	if v := r.Form.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxStreamBatch)
+	}
+
+	var wait time.Duration
+	if v := r.Form.Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid wait: %v", err), http.StatusBadRequest)
+			return
+		}
+		wait = min(wait, maxStreamWait)
+	}
+
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		err := s.db.WaitForWrite(ctx, from)
+		cancel()
+		if err != nil && r.Context().Err() != nil {
+			return // client went away
+		}
 	}
+
+	batch := replication.Batch{LastSeq: s.db.LastSeq()}
+	batch.Entries, err = s.db.ReplicationLog(from, limit)
+	if errors.Is(err, db.ErrLogTruncated) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading replication log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(batch)
 }
 
-// DeleteReplicationKey deletes a key from the replication queue.
-func (s *Server) DeleteReplicationKey(w http.ResponseWriter, r *http.Request) {
+// ReplicationAckHandler records the position a replica has applied and trims
+// log entries that every configured replica of this shard has acknowledged.
+func (s *Server) ReplicationAckHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	key := r.Form.Get("key")
-	value := r.Form.Get("value")
-
-	if key == "" || value == "" {
-		http.Error(w, "Missing key or value", http.StatusBadRequest)
+	replica := r.Form.Get("replica")
+	if replica == "" {
+		http.Error(w, "Missing replica", http.StatusBadRequest)
 		return
 	}
-
-	err := s.db.DeleteReplicationKey([]byte(key), []byte(value))
+	seq, err := parseUintParam(r, "seq")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting replication key: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if err := s.db.AckReplication(replica, seq); err != nil {
+		http.Error(w, fmt.Sprintf("Error recording ack: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := s.trimReplicationLog(); err != nil {
+		log.Printf("Failed to trim replication log: %v", err)
+	}
+
 	fmt.Fprint(w, "ok")
 }
+
+// LogTrimInterval is how often RunLogTrim trims the replication log.
+var LogTrimInterval = time.Minute
+
+// defaultLogRetention is how many of the latest log entries are kept for
+// watches unless WithLogRetention says otherwise.
+const defaultLogRetention = 10000
+
+// WithLogRetention keeps the latest n entries of the replication log even
+// once every replica has acknowledged them, so watches can resume from a
+// cursor that recent. Entries local watches have yet to read are kept
+// regardless.
+func WithLogRetention(n uint64) Option {
+	return func(s *Server) {
+		s.logRetention = n
+	}
+}
+
+// RunLogTrim trims the replication log every LogTrimInterval until ctx is
+// cancelled. Entries are dropped once every replica in the config has
+// acknowledged them, as on each ack; a shard without replicas drops them
+// all, since no replica will ever acknowledge. Either way the entries
+// within the retention window, or not yet read by a watch, are kept.
+func (s *Server) RunLogTrim(ctx context.Context) {
+	ticker := time.NewTicker(LogTrimInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		if shards := s.topology(); len(shards.GetReplicas(shards.CurIdx)) == 0 {
+			err = s.db.TrimReplicationLog(s.trimLimit(s.db.LastSeq()))
+		} else {
+			err = s.trimReplicationLog()
+		}
+		if err != nil {
+			log.Printf("Failed to trim replication log: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trimReplicationLog drops entries acknowledged by all replicas in the config.
+func (s *Server) trimReplicationLog() error {
+	shards := s.topology()
+	replicas := shards.GetReplicas(shards.CurIdx)
+	if len(replicas) == 0 {
+		return nil
+	}
+
+	acks, err := s.db.ReplicationAcks()
+	if err != nil {
+		return err
+	}
+
+	var upTo uint64
+	for i, replica := range replicas {
+		acked, ok := acks[replica]
+		if !ok {
+			return nil
+		}
+		if i == 0 || acked < upTo {
+			upTo = acked
+		}
+	}
+	return s.db.TrimReplicationLog(s.trimLimit(upTo))
+}
+
+// trimLimit lowers upTo, the position the log could be trimmed to, to keep
+// the retention window and every entry a local watch has yet to read.
+func (s *Server) trimLimit(upTo uint64) uint64 {
+	last := s.db.LastSeq()
+	if last <= s.logRetention {
+		return 0
+	}
+	upTo = min(upTo, last-s.logRetention)
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for p := range s.watchPins {
+		upTo = min(upTo, p.after)
+	}
+	return upTo
+}
+
+// parseUintParam parses an optional unsigned integer form value.
+func parseUintParam(r *http.Request, name string) (uint64, error) {
+	v := r.Form.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %v", name, err)
+	}
+	return n, nil
+}
@@ -2,6 +2,7 @@ package web_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,10 +10,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Sagor0078/distribKV/config"
 	"github.com/Sagor0078/distribKV/db"
+	"github.com/Sagor0078/distribKV/raft"
 	"github.com/Sagor0078/distribKV/replication"
 	"github.com/Sagor0078/distribKV/web"
 )
@@ -50,6 +54,78 @@ func createTestServer(t *testing.T, idx int, addrs map[int]string) (*db.Database
 	return database, server
 }
 
+// testNodes are HTTP servers started before their handlers exist, so every
+// node's address can go into the others' configuration.
+type testNodes struct {
+	urls  []string
+	addrs []string
+
+	mu       sync.Mutex
+	handlers []http.Handler
+}
+
+// startNodes starts count servers. A node without a handler drops every
+// connection, as if it were down.
+func startNodes(t *testing.T, count int) *testNodes {
+	t.Helper()
+
+	n := &testNodes{
+		urls:     make([]string, count),
+		addrs:    make([]string, count),
+		handlers: make([]http.Handler, count),
+	}
+	for i := range count {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n.mu.Lock()
+			h := n.handlers[i]
+			n.mu.Unlock()
+			if h == nil {
+				if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					conn.Close()
+				}
+				return
+			}
+			h.ServeHTTP(w, r)
+		}))
+		t.Cleanup(ts.Close)
+		n.urls[i] = ts.URL
+		n.addrs[i] = ts.Listener.Addr().String()
+	}
+	return n
+}
+
+// serve makes node i answer with h, or takes it down if h is nil.
+func (n *testNodes) serve(i int, h http.Handler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[i] = h
+}
+
+// shardAddrs returns the node addresses by shard index.
+func (n *testNodes) shardAddrs() map[int]string {
+	addrs := make(map[int]string, len(n.addrs))
+	for i, addr := range n.addrs {
+		addrs[i] = addr
+	}
+	return addrs
+}
+
+// newCluster starts count shards routing by key hash, each serving the
+// handlers register adds to its mux, and returns their base URLs.
+func newCluster(t *testing.T, count int, register func(*web.Server, *http.ServeMux)) []string {
+	t.Helper()
+
+	n := startNodes(t, count)
+	addrs := n.shardAddrs()
+	for i := range count {
+		_, server := createTestServer(t, i, addrs)
+		mux := http.NewServeMux()
+		register(server, mux)
+		n.serve(i, mux)
+	}
+	return n.urls
+}
+
 func TestSetAndGetHandler(t *testing.T) {
 	var ts1Handler, ts2Handler http.HandlerFunc
 
@@ -119,6 +195,124 @@ func TestSetAndGetHandler(t *testing.T) {
 	}
 }
 
+func TestSetHandlerTTL(t *testing.T) {
+	database, server := createTestServer(t, 0, map[int]string{0: "127.0.0.1:0"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", server.SetHandler)
+	mux.HandleFunc("/get", server.GetHandler)
+	mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/set", "application/json", strings.NewReader(`{"key":"session","value":"s","ttl":"1h"}`))
+	if err != nil {
+		t.Fatalf("POST /set failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /set with a TTL returned %d", resp.StatusCode)
+	}
+	e, err := database.GetEntry("session")
+	if err != nil || e.TTL() < 59*time.Minute {
+		t.Fatalf("stored entry %+v, %v; want a TTL of about an hour", e, err)
+	}
+
+	for _, url := range []string{ts.URL + "/get?key=session", ts.URL + "/v1/keys/session"} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", url, err)
+		}
+		resp.Body.Close()
+		if ttl := resp.Header.Get(web.TTLHeader); ttl != "3599" && ttl != "3600" {
+			t.Errorf("GET %s reported a TTL of %q, want about 3600", url, ttl)
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/set?key=forever&value=f")
+	if err != nil {
+		t.Fatalf("GET /set failed: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(ts.URL + "/get?key=forever")
+	if err != nil {
+		t.Fatalf("GET /get failed: %v", err)
+	}
+	resp.Body.Close()
+	if ttl := resp.Header.Get(web.TTLHeader); ttl != "" {
+		t.Errorf("key without a TTL reported %s %q", web.TTLHeader, ttl)
+	}
+
+	for _, ttl := range []string{"soon", "500ms", "-5"} {
+		resp, err := http.Get(ts.URL + "/set?key=bad&value=v&ttl=" + ttl)
+		if err != nil {
+			t.Fatalf("GET /set failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("/set with TTL %q returned %d, want 400", ttl, resp.StatusCode)
+		}
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	urls := newCluster(t, 2, func(server *web.Server, mux *http.ServeMux) {
+		mux.HandleFunc("/set", server.SetHandler)
+		mux.HandleFunc("/get", server.GetHandler)
+		mux.HandleFunc("DELETE /key", server.DeleteHandler)
+		mux.HandleFunc("/delete", server.DeleteHandler)
+	})
+
+	keys := []string{"key-one", "key-two", "key-three", "key-four"}
+	for _, key := range keys {
+		resp, err := http.Get(fmt.Sprintf("%s/set?key=%s&value=v", urls[0], key))
+		if err != nil {
+			t.Fatalf("Failed to set key %q: %v", key, err)
+		}
+		resp.Body.Close()
+	}
+
+	// Deletes sent to shard 0 are routed to whichever shard owns the key,
+	// through either route.
+	for i, key := range keys {
+		path := "/key"
+		if i%2 == 1 {
+			path = "/delete"
+		}
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s%s?key=%s", urls[0], path, key), nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to delete key %q: %v", key, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Delete of %q returned %d: %s", key, resp.StatusCode, body)
+		}
+	}
+
+	for _, key := range keys {
+		resp, err := http.Get(fmt.Sprintf("%s/get?key=%s", urls[1], key))
+		if err != nil {
+			t.Fatalf("Failed to get key %q: %v", key, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected %q to be gone, got status %d", key, resp.StatusCode)
+		}
+	}
+
+	for _, path := range []string{"/key", "/delete"} {
+		resp, err := http.Get(urls[0] + path + "?key=key-one")
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405 for GET %s, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
 func TestDeleteExtraKeysHandler(t *testing.T) {
 	addrs := map[int]string{
 		0: "localhost:1111",
@@ -148,47 +342,281 @@ func TestDeleteExtraKeysHandler(t *testing.T) {
 	}
 }
 
-func TestGetNextKeyForReplication(t *testing.T) {
+func fetchBatch(t *testing.T, server *web.Server, query string) replication.Batch {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/replication-stream?"+query, nil)
+	w := httptest.NewRecorder()
+	server.ReplicationStreamHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream returned %d: %s", w.Code, w.Body.String())
+	}
+	var batch replication.Batch
+	if err := json.NewDecoder(w.Result().Body).Decode(&batch); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return batch
+}
+
+func TestReplicationStreamHandler(t *testing.T) {
 	db := createTempDB(t, 0)
 	server := web.NewServer(db, &config.Shards{Addrs: map[int]string{}, Count: 1, CurIdx: 0})
 
-	// Add to replication queue
-	key := "foo"
-	value := []byte("bar")
-	_ = db.SetKey(key, value)
-
-	req := httptest.NewRequest("GET", "/next", nil)
-	w := httptest.NewRecorder()
-	server.GetNextKeyForReplication(w, req)
+	for i := 0; i < 5; i++ {
+		_ = db.SetKey("foo", []byte(fmt.Sprintf("v%d", i)))
+	}
 
-	resp := w.Result()
-	var result replication.NextKeyValue
-	err := json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		t.Fatalf("failed to decode response: %v", err)
+	batch := fetchBatch(t, server, "from=1&limit=3")
+	if len(batch.Entries) != 3 || batch.LastSeq != 5 {
+		t.Fatalf("Unexpected batch: %+v", batch)
+	}
+	for i, e := range batch.Entries {
+		if e.Seq != uint64(i+2) || string(e.Value) != fmt.Sprintf("v%d", i+1) {
+			t.Errorf("Unexpected entry %d: %+v", i, e)
+		}
 	}
 
-	if result.Key != key || result.Value != string(value) || result.Err != nil {
-		t.Errorf("Unexpected replication result: %+v", result)
+	// A long poll returns as soon as a write lands.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = db.SetKey("bar", []byte("late"))
+	}()
+	start := time.Now()
+	batch = fetchBatch(t, server, "from=5&wait=5s")
+	if len(batch.Entries) != 1 || batch.Entries[0].Key != "bar" {
+		t.Fatalf("Unexpected batch after wait: %+v", batch)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Long poll did not return promptly")
 	}
 }
 
-func TestDeleteReplicationKey(t *testing.T) {
+func TestReplicationAckHandlerTrimsLog(t *testing.T) {
 	db := createTempDB(t, 0)
-	server := web.NewServer(db, &config.Shards{Addrs: map[int]string{}, Count: 1, CurIdx: 0})
+	server := web.NewServer(db, &config.Shards{
+		Addrs:    map[int]string{0: "leader"},
+		Replicas: map[int][]string{0: {"r1", "r2"}},
+		Count:    1,
+		CurIdx:   0,
+	}, web.WithLogRetention(0))
+
+	for i := 0; i < 4; i++ {
+		_ = db.SetKey(fmt.Sprintf("k%d", i), []byte("v"))
+	}
 
-	key := "temp-key"
-	value := "temp-val"
+	ack := func(replica string, seq int) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/replication-ack?replica=%s&seq=%d", replica, seq), nil)
+		w := httptest.NewRecorder()
+		server.ReplicationAckHandler(w, req)
+		if w.Body.String() != "ok" {
+			t.Fatalf("Expected 'ok', got: %q", w.Body.String())
+		}
+	}
 
-	_ = db.SetKey(key, []byte(value)) // Enqueue for replication
+	// Only one replica has acked: nothing may be trimmed.
+	ack("r1", 3)
+	if batch := fetchBatch(t, server, "from=0"); len(batch.Entries) != 4 {
+		t.Fatalf("Expected untrimmed log, got %d entries", len(batch.Entries))
+	}
 
-	req := httptest.NewRequest("POST", fmt.Sprintf("/delete-replication?key=%s&value=%s", key, value), nil)
+	// Both acked: entries up to the slowest replica's position go away.
+	ack("r2", 2)
+	req := httptest.NewRequest("GET", "/replication-stream?from=0", nil)
 	w := httptest.NewRecorder()
-	server.DeleteReplicationKey(w, req)
+	server.ReplicationStreamHandler(w, req)
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected 410 for trimmed entries, got %d", w.Code)
+	}
 
-	resp := w.Result()
-	body, _ := io.ReadAll(resp.Body)
-	if string(body) != "ok" {
-		t.Errorf("Expected 'ok', got: %q", body)
+	batch := fetchBatch(t, server, "from=2")
+	if len(batch.Entries) != 2 || batch.Entries[0].Seq != 3 {
+		t.Errorf("Unexpected batch after trim: %+v", batch)
+	}
+}
+
+func TestRunLogTrimWithoutReplicas(t *testing.T) {
+	db := createTempDB(t, 0)
+	server := web.NewServer(db, &config.Shards{
+		Addrs:  map[int]string{0: "leader"},
+		Count:  1,
+		CurIdx: 0,
+	}, web.WithLogRetention(1))
+
+	for i := 0; i < 4; i++ {
+		_ = db.SetKey(fmt.Sprintf("k%d", i), []byte("v"))
+	}
+
+	// A cancelled context trims once and returns, keeping the latest entry.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	server.RunLogTrim(ctx)
+
+	trimmed, err := db.TrimmedSeq()
+	if err != nil || trimmed != 3 {
+		t.Errorf("Expected log trimmed up to 3, got %d (%v)", trimmed, err)
+	}
+}
+
+// raftGroup is a shard whose members replicate writes through Raft.
+type raftGroup struct {
+	*testNodes
+	databases []*db.Database
+	storages  []*raft.MemoryStorage
+
+	mu    sync.Mutex
+	nodes []*raft.Node
+}
+
+// newRaftGroup starts a shard of size members and waits until every member
+// knows the leader.
+func newRaftGroup(t *testing.T, size int) *raftGroup {
+	t.Helper()
+
+	g := &raftGroup{
+		testNodes: startNodes(t, size),
+		databases: make([]*db.Database, size),
+		storages:  make([]*raft.MemoryStorage, size),
+		nodes:     make([]*raft.Node, size),
+	}
+	for i := range size {
+		g.databases[i] = createTempDB(t, i)
+		g.storages[i] = raft.NewMemoryStorage()
+		g.start(t, i)
+	}
+	t.Cleanup(func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, node := range g.nodes {
+			node.Stop()
+		}
+	})
+	g.waitForLeader(t)
+	return g
+}
+
+// start starts member i from its Raft log and database, as after a restart.
+func (g *raftGroup) start(t *testing.T, i int) {
+	t.Helper()
+
+	applied, err := g.databases[i].RaftApplied()
+	if err != nil {
+		t.Fatalf("failed to read applied index: %v", err)
+	}
+	node, err := raft.NewNode(raft.Config{
+		ID:                g.addrs[i],
+		Peers:             g.addrs,
+		Storage:           g.storages[i],
+		Apply:             replication.StateMachine(g.databases[i]),
+		Applied:           applied,
+		ElectionTimeout:   150 * time.Millisecond,
+		HeartbeatInterval: 30 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft node: %v", err)
+	}
+
+	server := web.NewServer(g.databases[i], &config.Shards{
+		Addrs:    map[int]string{0: g.addrs[0]},
+		Replicas: map[int][]string{0: g.addrs[1:]},
+		Count:    1,
+		CurIdx:   0,
+	}, web.WithRaft(node))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/vote", node.VoteHandler)
+	mux.HandleFunc("/raft/append", node.AppendHandler)
+	mux.HandleFunc("/set", server.SetHandler)
+	mux.HandleFunc("/v1/keys/{key...}", server.KeyHandler)
+	mux.HandleFunc("/v1/batch", server.BatchHandler)
+	mux.HandleFunc("/v1/incr/{key...}", server.IncrementHandler)
+	mux.HandleFunc("/v1/internal/migrate", server.MigrateHandler)
+
+	g.mu.Lock()
+	g.nodes[i] = node
+	g.mu.Unlock()
+	g.serve(i, mux)
+	node.Start()
+}
+
+// restart stops member i and starts it again.
+func (g *raftGroup) restart(t *testing.T, i int) {
+	t.Helper()
+
+	g.mu.Lock()
+	node := g.nodes[i]
+	g.mu.Unlock()
+	node.Stop()
+	g.start(t, i)
+	g.waitForLeader(t)
+}
+
+// leader returns the index of the member leading the group.
+func (g *raftGroup) leader() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, n := range g.nodes {
+		if n.IsLeader() {
+			return i
+		}
+	}
+	return -1
+}
+
+// waitForLeader waits until every member knows the leader, so followers can
+// forward.
+func (g *raftGroup) waitForLeader(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		g.mu.Lock()
+		known := 0
+		for _, n := range g.nodes {
+			if n.Leader() != "" || n.IsLeader() {
+				known++
+			}
+		}
+		g.mu.Unlock()
+		if known == len(g.nodes) && g.leader() >= 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no leader elected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetHandlerWithRaft(t *testing.T) {
+	const size = 3
+	g := newRaftGroup(t, size)
+	// Write through every member; followers forward to the leader.
+	for i, base := range g.urls {
+		resp, err := http.Get(fmt.Sprintf("%s/set?key=k%d&value=v%d", base, i, i))
+		if err != nil {
+			t.Fatalf("set via member %d failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("set via member %d returned %d: %s", i, resp.StatusCode, body)
+		}
+	}
+
+	for i, database := range g.databases {
+		for k := 0; k < size; k++ {
+			key := fmt.Sprintf("k%d", k)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				val, err := database.GetKey(key)
+				if err == nil && string(val) == fmt.Sprintf("v%d", k) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("member %d never applied %s: %v", i, key, err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
 	}
 }